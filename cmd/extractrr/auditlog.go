@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditRecord is the line of the audit log for one extracted file
+type auditRecord struct {
+	Time time.Time `json:"time"`
+	// Job is the id the log lines of the image carry
+	Job   string `json:"job"`
+	Image string `json:"image"`
+	// Path is the absolute destination of the file
+	Path     string `json:"path"`
+	SrcPath  string `json:"src_path"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+	Hash     string `json:"hash"`
+	// DurationMS is how long the copy took in milliseconds
+	DurationMS int64 `json:"duration_ms"`
+	Worker     int   `json:"worker"`
+}
+
+// auditLog appends a JSON line for every extracted file as soon as it is complete, so it
+// can be followed while the extraction runs. It is shared by all images of a run.
+type auditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openAuditLog opens path for appending, lines already in it are kept
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &auditLog{f: f}, nil
+}
+
+// record appends the line of a completed file. Every line is written with a single write,
+// so readers never see half of one. Nothing is recorded without an audit log.
+func (a *auditLog) record(r auditRecord) error {
+	if a == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.f.Write(data)
+	return err
+}
+
+// Close flushes the log to the device and closes it
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
+
+// auditHash returns the checksum of a result in the manifest's algorithm
+func auditHash(result fileResult, algorithm string) string {
+	if algorithm == ChecksumCRC32 {
+		return fmt.Sprintf("%08x", result.CRC32)
+	}
+	return hex.EncodeToString(result.Digest)
+}
+
+// newAuditRecord describes a file a worker of the pool for src extracted
+func newAuditRecord(opts ExtractOptions, src *imageSource, worker int, job Job, result fileResult) auditRecord {
+	return auditRecord{
+		Time:       time.Now().UTC(),
+		Job:        opts.jobID,
+		Image:      src.String(),
+		Path:       job.DstPath,
+		SrcPath:    job.SrcPath,
+		Size:       job.Size,
+		Checksum:   opts.checksum(),
+		Hash:       auditHash(result, opts.checksum()),
+		DurationMS: result.Duration.Milliseconds(),
+		Worker:     worker,
+	}
+}
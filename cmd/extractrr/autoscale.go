@@ -0,0 +1,93 @@
+package main
+
+import (
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// scaleInterval is how long each number of active workers is measured before the next step
+const scaleInterval = 10 * time.Second
+
+// scaleTolerance is the relative change in throughput that counts as better or worse,
+// anything smaller is noise and the worker count is kept
+const scaleTolerance = 0.05
+
+// workerScaler looks for the number of active workers with the best throughput by hill
+// climbing. It keeps stepping one way while throughput improves, turns around once it
+// gets worse and holds while it stays level, so it follows the source and destination
+// as they slow down or speed up during the run.
+type workerScaler struct {
+	max       int
+	step      int
+	rate      float64
+	processed int64
+	sampledAt time.Time
+}
+
+// initialWorkers is where scaling starts, half of the workers leaves room to go either way
+func initialWorkers(workers int) int {
+	return max(workers/2, 1)
+}
+
+// scale adjusts the active worker limit every scaleInterval until done is closed
+func (s *poolState) scale(workers int, done <-chan struct{}) {
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+
+	scaler := &workerScaler{max: workers, step: 1, sampledAt: time.Now()}
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			s.scaleStep(scaler, now)
+		}
+	}
+}
+
+// scaleStep measures the throughput since the last step and moves the limit
+func (s *poolState) scaleStep(scaler *workerScaler, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rate := float64(s.processed-scaler.processed) / now.Sub(scaler.sampledAt).Seconds()
+	scaler.processed, scaler.sampledAt = s.processed, now
+
+	// A stuck run or the last few files say nothing about the best worker count
+	if s.aborted != nil || s.stuck || rate <= 0 || len(s.queue) < s.activeLimit {
+		return
+	}
+
+	previous := scaler.rate
+	scaler.rate = rate
+	switch {
+	case previous == 0 || rate > previous*(1+scaleTolerance):
+	case rate < previous*(1-scaleTolerance):
+		scaler.step = -scaler.step
+	default:
+		return
+	}
+
+	limit := min(max(s.activeLimit+scaler.step, 1), scaler.max)
+	if limit == s.activeLimit {
+		// At either end the next move can only go back
+		scaler.step = -scaler.step
+		return
+	}
+
+	s.activeLimit = limit
+	s.cond.Broadcast()
+	s.logger.Info("Adjusting workers to throughput", "workers", limit, "rate", humanize.IBytes(uint64(rate))+"/s")
+}
+
+// busyLocked returns how many workers are working on a file
+func (s *poolState) busyLocked() int {
+	busy := 0
+	for _, w := range s.workers {
+		if w.busy && !w.abandoned {
+			busy++
+		}
+	}
+	return busy
+}
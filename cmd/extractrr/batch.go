@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Batch orders for images matched by a glob
+const (
+	OrderName     = "name"
+	OrderOldest   = "oldest"
+	OrderNewest   = "newest"
+	OrderSmallest = "smallest"
+	OrderLargest  = "largest"
+)
+
+var batchOrders = []string{OrderName, OrderOldest, OrderNewest, OrderSmallest, OrderLargest}
+
+func validateOrder(order string) error {
+	if !slices.Contains(batchOrders, order) {
+		return fmt.Errorf("invalid order %q: must be one of %s", order, strings.Join(batchOrders, ", "))
+	}
+	return nil
+}
+
+// Layouts of a batch of images in the destination
+const (
+	LayoutSubdir   = "subdir"
+	LayoutFlat     = "flat"
+	LayoutTemplate = "template"
+)
+
+var batchLayouts = []string{LayoutSubdir, LayoutFlat, LayoutTemplate}
+
+// resolveBatchLayout validates layout, "" picks template for a destination template and subdir otherwise
+func resolveBatchLayout(layout string, template bool) (string, error) {
+	switch {
+	case layout == "" && template:
+		return LayoutTemplate, nil
+	case layout == "":
+		return LayoutSubdir, nil
+	case !slices.Contains(batchLayouts, layout):
+		return "", fmt.Errorf("invalid batch layout %q: must be one of %s", layout, strings.Join(batchLayouts, ", "))
+	case layout == LayoutTemplate && !template:
+		return "", fmt.Errorf("--batch-layout template needs a destination template")
+	case layout != LayoutTemplate && template:
+		return "", fmt.Errorf("--batch-layout %s can't be used with a destination template, it places every image itself", layout)
+	}
+	return layout, nil
+}
+
+// batchDestination returns where an image of a batch is extracted to
+func batchDestination(layout, baseDir, isoFile string) string {
+	if layout != LayoutSubdir {
+		return baseDir
+	}
+	baseName := filepath.Base(isoFile)
+	return filepath.Join(baseDir, strings.TrimSuffix(baseName, filepath.Ext(baseName)))
+}
+
+// runBatch calls extract for every image index, up to parallel at once, and returns how many
+// succeeded. A stuck image most likely means the next would hang too, no more are started
+// after one and its error is returned once the running ones are done.
+func runBatch(images, parallel int, extract func(i int) error) (int, error) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		succeeded int
+		stuck     error
+	)
+	slots := make(chan struct{}, max(parallel, 1))
+
+	for i := range images {
+		slots <- struct{}{}
+		mu.Lock()
+		aborted := stuck != nil
+		mu.Unlock()
+		if aborted {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			err := extract(i)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, errStuck):
+				if stuck == nil {
+					stuck = err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return succeeded, stuck
+}
+
+// expandImagePattern returns the images matching a glob pattern, URLs are used as is
+func expandImagePattern(pattern string) ([]string, error) {
+	if isRemotePath(pattern) {
+		return []string{pattern}, nil
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern: %w", err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files found matching pattern: %s", pattern)
+	}
+
+	return matches, nil
+}
+
+// orderImages sorts a batch of images by order. Images whose name matches one of the
+// priority patterns come first, grouped by the first pattern they match.
+func orderImages(images []string, order string, priority []string) ([]string, error) {
+	for _, pattern := range priority {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid priority pattern %q: %w", pattern, err)
+		}
+	}
+
+	type entry struct {
+		path  string
+		rank  int
+		size  int64
+		mtime time.Time
+	}
+
+	entries := make([]entry, 0, len(images))
+	for _, image := range images {
+		e := entry{path: image, rank: len(priority)}
+		for i, pattern := range priority {
+			if ok, _ := filepath.Match(pattern, filepath.Base(image)); ok {
+				e.rank = i
+				break
+			}
+		}
+
+		if order != OrderName {
+			info, err := os.Stat(image)
+			if err != nil {
+				return nil, err
+			}
+			e.size, e.mtime = info.Size(), info.ModTime()
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+
+		switch order {
+		case OrderOldest:
+			return a.mtime.Before(b.mtime)
+		case OrderNewest:
+			return a.mtime.After(b.mtime)
+		case OrderSmallest:
+			return a.size < b.size
+		case OrderLargest:
+			return a.size > b.size
+		default:
+			return a.path < b.path
+		}
+	})
+
+	ordered := make([]string, len(entries))
+	for i, e := range entries {
+		ordered[i] = e.path
+	}
+
+	return ordered, nil
+}
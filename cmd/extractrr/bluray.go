@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+)
+
+// What to do with the interleaved streams of a 3D Blu-ray
+const (
+	SSIFKeep = "keep"
+	SSIFSkip = "skip"
+)
+
+var ssifModes = []string{SSIFKeep, SSIFSkip}
+
+func validateSSIFMode(mode string) error {
+	if !slices.Contains(ssifModes, mode) {
+		return fmt.Errorf("invalid ssif mode %q: must be one of %s", mode, strings.Join(ssifModes, ", "))
+	}
+	return nil
+}
+
+// ssifSkipReason is why interleaved streams are left out with --ssif skip
+const ssifSkipReason = "3D interleaved stream, --ssif skip"
+
+// blurayIndexUHD is the index.bdmv version of Ultra HD Blu-rays
+const blurayIndexUHD = "INDX0300"
+
+// blurayLayout describes the structure of a Blu-ray beyond it being one
+type blurayLayout struct {
+	// UHD is set for Ultra HD discs, recognized by the version of index.bdmv
+	UHD bool
+	// Stereo3D is set if there are interleaved SSIF streams
+	Stereo3D bool
+}
+
+// isSSIF reports whether srcPath is an interleaved stream of a 3D Blu-ray. Its extents
+// are those of a 2D stream and its dependent view in STREAM, so extracting it copies
+// that data a second time.
+func isSSIF(srcPath string) bool {
+	dir, name := path.Split(strings.ToUpper(srcPath))
+	return strings.HasSuffix(dir, "/BDMV/STREAM/SSIF/") && strings.HasSuffix(name, ".SSIF")
+}
+
+// findEntry returns the path of the entry of dir named name in any case, "" if there is none
+func findEntry(image SourceBackend, dir, name string) string {
+	entries, err := readDir(image, dir)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if strings.EqualFold(entry.Name, name) {
+			return path.Join(dir, entry.Name)
+		}
+	}
+	return ""
+}
+
+// readBlurayLayout looks at the BDMV directory below the root of the image
+func readBlurayLayout(image SourceBackend) blurayLayout {
+	var layout blurayLayout
+
+	bdmv := findEntry(image, "/", "BDMV")
+	if bdmv == "" {
+		return layout
+	}
+
+	if index := findEntry(image, bdmv, "index.bdmv"); index != "" {
+		if f, err := image.OpenFile(index); err == nil {
+			header := make([]byte, len(blurayIndexUHD))
+			if _, err := io.ReadFull(f, header); err == nil {
+				layout.UHD = string(header) == blurayIndexUHD
+			}
+			f.Close()
+		}
+	}
+
+	if stream := findEntry(image, bdmv, "STREAM"); stream != "" {
+		if ssif := findEntry(image, stream, "SSIF"); ssif != "" {
+			entries, _ := readDir(image, ssif)
+			layout.Stereo3D = len(entries) > 0
+		}
+	}
+
+	return layout
+}
+
+// ssifSize returns how many interleaved streams the scan is about to extract and their size
+func ssifSize(scan *scanResult) (int, int64) {
+	var files int
+	var size int64
+	for _, job := range scan.Jobs {
+		if isSSIF(job.SrcPath) {
+			files++
+			size += job.Size
+		}
+	}
+	return files, size
+}
+
+// applySSIFMode leaves the interleaved streams out of the scan with --ssif skip, the discs
+// still play from the 2D streams and their dependent views
+func applySSIFMode(scan *scanResult, mode string) {
+	if mode != SSIFSkip {
+		return
+	}
+
+	jobs := scan.Jobs[:0]
+	for _, job := range scan.Jobs {
+		if !isSSIF(job.SrcPath) {
+			jobs = append(jobs, job)
+			continue
+		}
+		scan.Skipped = append(scan.Skipped, skippedEntry{SrcPath: job.SrcPath, DstPath: job.DstPath, Size: job.Size, Reason: ssifSkipReason})
+		scan.TotalSize -= job.Size
+		scan.FileCount--
+	}
+	scan.Jobs = jobs
+}
+
+// logBlurayLayout logs what kind of Blu-ray the image is, if it is one
+func logBlurayLayout(logger *slog.Logger, image SourceBackend, scan *scanResult) {
+	layout := readBlurayLayout(image)
+	files, size := ssifSize(scan)
+	if !layout.UHD && !layout.Stereo3D {
+		return
+	}
+
+	logger.Info("Blu-ray structure", "uhd", layout.UHD, "3d", layout.Stereo3D, "ssif_files", files, "ssif_bytes", size)
+	if files > 0 {
+		logger.Info("SSIF streams repeat the data of the 2D streams and their dependent views, --ssif skip leaves them out", "bytes", size)
+	}
+}
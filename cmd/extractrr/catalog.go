@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+// catalogName is the catalog file in the extractrr user config directory
+const catalogName = "catalog.jsonl"
+
+// catalogFile is a file of a cataloged image
+type catalogFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Checksum is the hex checksum of the file with the image's algorithm, if it was hashed
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// catalogImage is what the catalog knows about an image, recorded without extracting it
+type catalogImage struct {
+	Path  string    `json:"path"`
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+	// Hash is the fingerprint describeSource computes, rescans skip images that still have it
+	Hash        string `json:"hash"`
+	VolumeLabel string `json:"volume_label,omitempty"`
+	DiscType    string `json:"disc_type"`
+	UHD         bool   `json:"uhd,omitempty"`
+	Stereo3D    bool   `json:"stereo_3d,omitempty"`
+	// TotalSize and FileCount are what extracting the image would write
+	TotalSize int64 `json:"total_size"`
+	FileCount int   `json:"file_count"`
+	// Checksum is the algorithm of the file checksums, empty if the files weren't hashed
+	Checksum    string        `json:"checksum,omitempty"`
+	Files       []catalogFile `json:"files"`
+	CatalogedAt time.Time     `json:"cataloged_at"`
+}
+
+// imageCatalog is a searchable index of a library of images keyed by their absolute path.
+// Every cataloged image is appended to the file as a line of JSON, so an interrupted run
+// keeps what it did and cataloging doesn't slow down as the library grows. The latest line
+// of an image replaces the earlier ones.
+type imageCatalog struct {
+	path   string
+	Images map[string]*catalogImage
+	// superseded counts the lines replaced by later ones, compact drops them
+	superseded int
+}
+
+// openCatalog reads the catalog at path, or from the user config directory when path is empty
+func openCatalog(path string) (*imageCatalog, error) {
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate config directory: %w", err)
+		}
+		path = filepath.Join(dir, "extractrr", catalogName)
+	}
+
+	c := &imageCatalog{path: path, Images: make(map[string]*catalogImage)}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// A decoder rather than a line scanner, the file lists of large images make long lines
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for n := 1; ; n++ {
+		var image catalogImage
+		err := decoder.Decode(&image)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid catalog %s entry %d: %w", path, n, err)
+		}
+		if c.Images[image.Path] != nil {
+			c.superseded++
+		}
+		c.Images[image.Path] = &image
+	}
+
+	return c, nil
+}
+
+// record adds an image to the catalog and appends it to the file
+func (c *imageCatalog) record(image *catalogImage) error {
+	data, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if c.Images[image.Path] != nil {
+		c.superseded++
+	}
+	c.Images[image.Path] = image
+	return nil
+}
+
+// compact rewrites the file with only the latest line of every image, if any were replaced
+func (c *imageCatalog) compact() error {
+	if c.superseded == 0 {
+		return nil
+	}
+
+	tmp := c.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, path := range c.paths() {
+		if err = encoder.Encode(c.Images[path]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, c.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	c.superseded = 0
+	return nil
+}
+
+// paths returns the cataloged images in order
+func (c *imageCatalog) paths() []string {
+	paths := make([]string, 0, len(c.Images))
+	for path := range c.Images {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// catalogOptions configure how images are cataloged
+type catalogOptions struct {
+	// Checksum hashes every file with this algorithm, which reads the whole image
+	Checksum   string
+	Workers    int
+	BufferSize int
+	Rescan     bool
+}
+
+// catalogImageFile scans one image into the catalog, skipping it if it was cataloged with
+// the same fingerprint and checksum already
+func catalogImageFile(logger *slog.Logger, c *imageCatalog, isoFile string, opts catalogOptions) error {
+	source, err := describeSource(isoFile)
+	if err != nil {
+		return err
+	}
+	if known := c.Images[source.Path]; known != nil && !opts.Rescan && known.Hash == source.Hash && (opts.Checksum == "" || known.Checksum == opts.Checksum) {
+		logger.Info("Image unchanged, skipping")
+		return nil
+	}
+
+	src := &imageSource{Path: isoFile}
+	image, err := openBackend(src)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	scan := &scanResult{}
+	if err := scanISOStructure(image, "/", "", 0, nil, scan); err != nil {
+		return fmt.Errorf("failed to scan ISO: %w", err)
+	}
+	meta, err := readImageMetadata(image, isoFile, scan.TotalSize, scan.FileCount)
+	if err != nil {
+		return err
+	}
+
+	entry := &catalogImage{
+		Path:        source.Path,
+		Size:        source.Size,
+		Mtime:       source.Mtime,
+		Hash:        source.Hash,
+		VolumeLabel: meta.VolumeLabel,
+		DiscType:    meta.DiscType,
+		UHD:         meta.UHD,
+		Stereo3D:    meta.Stereo3D,
+		TotalSize:   scan.TotalSize,
+		FileCount:   scan.FileCount,
+		Checksum:    opts.Checksum,
+		Files:       make([]catalogFile, len(scan.Jobs)),
+		CatalogedAt: time.Now(),
+	}
+	for i, job := range scan.Jobs {
+		entry.Files[i] = catalogFile{Path: job.SrcPath, Size: job.Size}
+	}
+
+	if opts.Checksum != "" {
+		logger.Info("Hashing files", "files", len(scan.Jobs), "bytes", scan.TotalSize, "size", humanize.IBytes(uint64(scan.TotalSize)))
+		results, err := runPool(logger, src, scan.Jobs, scan.TotalSize, poolOptions{Workers: opts.Workers, BufferSize: opts.BufferSize}, func(ctx context.Context, image SourceBackend, job Job, buffer []byte, progressChan chan<- int64) fileResult {
+			h := newChecksum(opts.Checksum)
+			_, err := readImageFile(image, job.SrcPath, buffer, func(chunk []byte) error {
+				h.Write(chunk)
+				progressChan <- int64(len(chunk))
+				return nil
+			})
+			return fileResult{Digest: h.Sum(nil), Err: err}
+		})
+		if err != nil {
+			return err
+		}
+		if failed := reportFailures(logger, scan.Jobs, results); failed > 0 {
+			return fmt.Errorf("failed to hash %d files", failed)
+		}
+		for i := range entry.Files {
+			entry.Files[i].Checksum = hex.EncodeToString(results[i].Digest)
+		}
+	}
+
+	if err := c.record(entry); err != nil {
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+	logger.Info("Cataloged image", "label", meta.VolumeLabel, "disc_type", meta.DiscType, "files", scan.FileCount, "size", humanize.IBytes(uint64(scan.TotalSize)))
+	return nil
+}
+
+func CommandCatalog() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "catalog",
+		Short: "Record volume labels and file lists of many isos in a searchable catalog",
+		Long: `Record volume labels and file lists of many isos in a searchable catalog
+
+Every argument is an image or a glob of images. Their volume labels, disc types and
+files with sizes are recorded in the catalog, by default ` + catalogName + ` in the user
+config directory, without extracting anything. Images cataloged before are skipped while
+their fingerprint is unchanged. With --checksum every file is hashed as well, which reads
+the whole image. Search the catalog with "catalog query".`,
+		Example: `  extractrr catalog "/isos/*.iso"
+  extractrr catalog --db catalog.jsonl --checksum xxh3 /isos/*/*.iso`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return fmt.Errorf("requires at least one arg")
+			}
+			return nil
+		},
+	}
+
+	var (
+		dbPath     = command.PersistentFlags().String("db", "", "Catalog file (default: "+catalogName+" in the user config directory)")
+		checksum   = command.Flags().String("checksum", "", "Also hash every file with this algorithm: crc32, sha256, blake3 or xxh3")
+		rescan     = command.Flags().Bool("rescan", false, "Catalog images again even if they are unchanged")
+		numWorkers = command.Flags().Int("workers", runtime.NumCPU(), "With --checksum, number of parallel workers hashing files")
+		bufferSize = bufferFlag(command.Flags(), "With --checksum, buffer size for file reading")
+	)
+
+	command.AddCommand(CommandCatalogQuery(dbPath))
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		if *checksum != "" {
+			if err := validateChecksum(*checksum); err != nil {
+				return err
+			}
+		}
+
+		var images []string
+		for _, pattern := range args {
+			matches, err := expandImagePattern(pattern)
+			if err != nil {
+				return err
+			}
+			images = append(images, matches...)
+		}
+
+		catalog, err := openCatalog(*dbPath)
+		if err != nil {
+			return err
+		}
+		// Images cataloged again left their old lines behind
+		if err := catalog.compact(); err != nil {
+			return fmt.Errorf("failed to compact catalog: %w", err)
+		}
+
+		opts := catalogOptions{Checksum: *checksum, Workers: *numWorkers, BufferSize: *bufferSize, Rescan: *rescan}
+		failed := 0
+		for _, isoFile := range images {
+			logger := slog.With("iso", isoFile)
+			if err := catalogImageFile(logger, catalog, isoFile, opts); err != nil {
+				logger.Error("Failed to catalog image", "error", err)
+				failed++
+			}
+		}
+
+		slog.Info("Catalog updated", "db", catalog.path, "images", len(catalog.Images))
+		if failed > 0 {
+			return fmt.Errorf("failed to catalog %d of %d images", failed, len(images))
+		}
+		return nil
+	}
+
+	return command
+}
+
+// catalogQuery selects files of the catalog, every condition that is set has to match
+type catalogQuery struct {
+	Patterns []string
+	Label    string
+	DiscType string
+	MinSize  int64
+	MaxSize  int64
+	Checksum string
+}
+
+func (q catalogQuery) matchesImage(image *catalogImage) bool {
+	switch {
+	case q.Label != "" && !globFold(q.Label, image.VolumeLabel):
+		return false
+	case q.DiscType != "" && !strings.EqualFold(q.DiscType, image.DiscType):
+		return false
+	}
+	return true
+}
+
+func (q catalogQuery) matchesFile(file catalogFile) bool {
+	if len(q.Patterns) > 0 {
+		if _, ok := matchAny(q.Patterns, file.Path); !ok {
+			return false
+		}
+	}
+	switch {
+	case q.MinSize > 0 && file.Size < q.MinSize:
+		return false
+	case q.MaxSize > 0 && file.Size > q.MaxSize:
+		return false
+	case q.Checksum != "" && !strings.EqualFold(q.Checksum, file.Checksum):
+		return false
+	}
+	return true
+}
+
+// printCatalogImage prints the summary line of an image in the catalog
+func printCatalogImage(w io.Writer, image *catalogImage) error {
+	_, err := fmt.Fprintf(w, "%s  %s  %s  %d files  %s\n", image.Path, image.VolumeLabel, image.DiscType, image.FileCount, humanize.IBytes(uint64(image.TotalSize)))
+	return err
+}
+
+func CommandCatalogQuery(dbPath *string) *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "query",
+		Short: "Search the catalog for files or images",
+		Long: `Search the catalog for files or images
+
+Arguments are glob patterns over the in-image paths, matched against the full path when
+they contain a slash and against the file name otherwise, like --include. Every matching
+file is printed with its size and the image it is in, with --images only the images
+having a match are.`,
+		Example: `  extractrr catalog query "*.m2ts" --min-size 20GiB
+  extractrr catalog query --label "*UHD*" --images
+  extractrr catalog query --checksum 5f0e3c9a1b2d4e6f`,
+	}
+
+	var (
+		label    = command.Flags().String("label", "", "Only search images whose volume label matches this glob, ignoring case")
+		discType = command.Flags().String("disc-type", "", "Only search images of this disc type: BD, DVD or data")
+		minSize  = command.Flags().String("min-size", "", "Only match files of at least this size, e.g. 1GiB")
+		maxSize  = command.Flags().String("max-size", "", "Only match files of at most this size")
+		checksum = command.Flags().String("checksum", "", "Only match files with this hex checksum, of images cataloged with --checksum")
+		images   = command.Flags().Bool("images", false, "Print the matching images instead of the files")
+	)
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		q := catalogQuery{Patterns: args, Label: *label, DiscType: *discType, Checksum: *checksum}
+		for _, pattern := range append(args, *label) {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+		for _, bound := range []struct {
+			name, value string
+			size        *int64
+		}{{"--min-size", *minSize, &q.MinSize}, {"--max-size", *maxSize, &q.MaxSize}} {
+			if bound.value == "" {
+				continue
+			}
+			size, err := humanize.ParseBytes(bound.value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", bound.name, err)
+			}
+			*bound.size = int64(size)
+		}
+
+		catalog, err := openCatalog(*dbPath)
+		if err != nil {
+			return err
+		}
+
+		w := bufio.NewWriter(c.OutOrStdout())
+		defer w.Flush()
+
+		for _, path := range catalog.paths() {
+			image := catalog.Images[path]
+			if !q.matchesImage(image) {
+				continue
+			}
+			for _, file := range image.Files {
+				if !q.matchesFile(file) {
+					continue
+				}
+				if *images {
+					if err := printCatalogImage(w, image); err != nil {
+						return err
+					}
+					break
+				}
+				if _, err := fmt.Fprintf(w, "%14d  %s:%s\n", file.Size, image.Path, file.Path); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return command
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCatalogAppendsAndCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), catalogName)
+	c, err := openCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enough files to go past the line length a bufio.Scanner would take
+	large := &catalogImage{Path: "/isos/large.iso", VolumeLabel: "LARGE"}
+	for i := range 5000 {
+		large.Files = append(large.Files, catalogFile{Path: fmt.Sprintf("/BDMV/STREAM/%05d.m2ts", i), Size: int64(i)})
+	}
+	for _, image := range []*catalogImage{
+		{Path: "/isos/a.iso", VolumeLabel: "OLD"},
+		large,
+		{Path: "/isos/a.iso", VolumeLabel: "NEW"},
+	} {
+		if err := c.record(image); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := func() int {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes.Count(data, []byte("\n"))
+	}
+	if n := lines(); n != 3 {
+		t.Fatalf("catalog has %d lines, want one appended per recorded image", n)
+	}
+
+	check := func(c *imageCatalog) {
+		t.Helper()
+		if len(c.Images) != 2 {
+			t.Fatalf("catalog has %d images, want 2", len(c.Images))
+		}
+		if label := c.Images["/isos/a.iso"].VolumeLabel; label != "NEW" {
+			t.Errorf("image recorded twice has label %q, want the latest NEW", label)
+		}
+		if files := len(c.Images["/isos/large.iso"].Files); files != 5000 {
+			t.Errorf("large image has %d files, want 5000", files)
+		}
+	}
+
+	c, err = openCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(c)
+	if c.superseded != 1 {
+		t.Errorf("counted %d superseded lines, want 1", c.superseded)
+	}
+
+	if err := c.compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n := lines(); n != 2 {
+		t.Errorf("compacted catalog has %d lines, want one per image", n)
+	}
+	c, err = openCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(c)
+	if c.superseded != 0 {
+		t.Errorf("compacted catalog still has %d superseded lines", c.superseded)
+	}
+}
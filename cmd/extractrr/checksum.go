@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/zeebo/xxh3"
+	"lukechampine.com/blake3"
+)
+
+// Checksum algorithms for manifests and the hash command. crc32 is what SFV files
+// use, the others trade cryptographic strength for speed: xxh3 > blake3 > sha256.
+const (
+	ChecksumCRC32  = "crc32"
+	ChecksumSHA256 = "sha256"
+	ChecksumBLAKE3 = "blake3"
+	ChecksumXXH3   = "xxh3"
+)
+
+var checksumAlgorithms = []string{ChecksumCRC32, ChecksumSHA256, ChecksumBLAKE3, ChecksumXXH3}
+
+func validateChecksum(algo string) error {
+	if !slices.Contains(checksumAlgorithms, algo) {
+		return fmt.Errorf("invalid checksum algorithm %q: must be one of %s", algo, strings.Join(checksumAlgorithms, ", "))
+	}
+	return nil
+}
+
+// newChecksum returns a hash for algo, which must have been validated
+func newChecksum(algo string) hash.Hash {
+	switch algo {
+	case ChecksumSHA256:
+		return sha256.New()
+	case ChecksumBLAKE3:
+		return blake3.New(32, nil)
+	case ChecksumXXH3:
+		return xxh3.New()
+	default:
+		return crc32.NewIEEE()
+	}
+}
+
+// hashWriter combines the non-nil hashes into one writer, or returns nil if there are none
+func hashWriter(hashes ...hash.Hash) io.Writer {
+	writers := make([]io.Writer, 0, len(hashes))
+	for _, h := range hashes {
+		if h != nil {
+			writers = append(writers, h)
+		}
+	}
+
+	switch len(writers) {
+	case 0:
+		return nil
+	case 1:
+		return writers[0]
+	default:
+		return io.MultiWriter(writers...)
+	}
+}
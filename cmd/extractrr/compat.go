@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Modes of the destination filesystem check
+const (
+	FSCheckOff  = "off"
+	FSCheckWarn = "warn"
+	FSCheckFail = "fail"
+)
+
+var fsCheckModes = []string{FSCheckOff, FSCheckWarn, FSCheckFail}
+
+func validateFSCheck(mode string) error {
+	if !slices.Contains(fsCheckModes, mode) {
+		return fmt.Errorf("invalid fs check %q: must be one of %s", mode, strings.Join(fsCheckModes, ", "))
+	}
+	return nil
+}
+
+// fsLimits describes what a destination filesystem can't store
+type fsLimits struct {
+	// MaxFileSize is the largest file the filesystem holds, 0 for no limit
+	MaxFileSize int64
+	// InvalidChars may not appear in names, control characters never may
+	InvalidChars string
+	// NoTrailingDotSpace refuses names ending with a dot or space, like Windows does
+	NoTrailingDotSpace bool
+	// CaseInsensitive filesystems can't keep names apart that only differ in case
+	CaseInsensitive bool
+}
+
+const windowsInvalidChars = `"*:<>?\|`
+
+// knownFSLimits holds the filesystems extractions are known to break on, by the name fsType reports
+var knownFSLimits = map[string]fsLimits{
+	"vfat":  {MaxFileSize: 4<<30 - 1, InvalidChars: windowsInvalidChars, NoTrailingDotSpace: true, CaseInsensitive: true},
+	"exfat": {InvalidChars: windowsInvalidChars, NoTrailingDotSpace: true, CaseInsensitive: true},
+	"ntfs":  {InvalidChars: windowsInvalidChars},
+	"smb":   {InvalidChars: windowsInvalidChars, NoTrailingDotSpace: true, CaseInsensitive: true},
+}
+
+// Problems found by the compatibility check
+const (
+	compatTooLarge     = "larger than 4 GiB"
+	compatInvalidChars = "name contains characters the filesystem doesn't allow"
+	compatTrailing     = "name ends with a dot or space"
+	compatCase         = "name differs only in case from another entry"
+)
+
+// compatProblem lists the destination paths affected by one problem
+type compatProblem struct {
+	Reason string
+	Paths  []string
+}
+
+// compatReport is the result of checking a scan against the destination filesystem
+type compatReport struct {
+	// FSType is empty when the filesystem couldn't be detected
+	FSType   string
+	Problems []compatProblem
+}
+
+// entries returns the number of affected paths
+func (r compatReport) entries() int {
+	n := 0
+	for _, p := range r.Problems {
+		n += len(p.Paths)
+	}
+	return n
+}
+
+// detectFSType returns the filesystem type of dir, or of its closest existing parent
+// when the destination will only be created by the extraction
+func detectFSType(dir string) (string, error) {
+	dir, err := existingParent(dir)
+	if err != nil {
+		return "", err
+	}
+	return fsType(dir)
+}
+
+// existingParent returns dir or its nearest parent that exists, the destination may not yet
+func existingParent(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no existing parent of %s", dir)
+		}
+		dir = parent
+	}
+}
+
+// checkCompatibility reports the scanned entries the filesystem of extractDir can't store
+func checkCompatibility(extractDir string, scan *scanResult) compatReport {
+	fstype, err := detectFSType(extractDir)
+	if err != nil {
+		return compatReport{}
+	}
+
+	report := compatReport{FSType: fstype}
+	limits, ok := knownFSLimits[fstype]
+	if !ok {
+		return report
+	}
+
+	problems := make(map[string]int)
+	add := func(reason, path string) {
+		i, ok := problems[reason]
+		if !ok {
+			i = len(report.Problems)
+			problems[reason] = i
+			report.Problems = append(report.Problems, compatProblem{Reason: reason})
+		}
+		report.Problems[i].Paths = append(report.Problems[i].Paths, path)
+	}
+
+	seen := make(map[string]string)
+	check := func(rel string) {
+		name := filepath.Base(rel)
+		if strings.ContainsAny(name, limits.InvalidChars) || strings.ContainsFunc(name, func(r rune) bool { return r < 0x20 }) {
+			add(compatInvalidChars, rel)
+		}
+		if limits.NoTrailingDotSpace && (strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ")) {
+			add(compatTrailing, rel)
+		}
+		if limits.CaseInsensitive {
+			key := strings.ToLower(rel)
+			if other, ok := seen[key]; ok && other != rel {
+				add(compatCase, rel)
+			} else {
+				seen[key] = rel
+			}
+		}
+	}
+
+	for _, dir := range scan.Dirs {
+		if dir != "" {
+			check(dir)
+		}
+	}
+	for _, job := range scan.Jobs {
+		check(job.DstPath)
+		if limits.MaxFileSize > 0 && job.Size > limits.MaxFileSize && !job.Split {
+			add(compatTooLarge, job.DstPath)
+		}
+	}
+
+	return report
+}
+
+// compatExamples is how many paths are shown for each problem
+const compatExamples = 3
+
+// printCompatibility writes the report for --dry-run
+func printCompatibility(w io.Writer, report compatReport) error {
+	if report.FSType == "" {
+		_, err := fmt.Fprintln(w, "Destination filesystem: unknown, compatibility not checked")
+		return err
+	}
+	if len(report.Problems) == 0 {
+		_, err := fmt.Fprintf(w, "Destination filesystem: %s, no compatibility problems\n", report.FSType)
+		return err
+	}
+
+	fmt.Fprintf(w, "Destination filesystem: %s, %d entries can't be stored\n", report.FSType, report.entries())
+	for _, p := range report.Problems {
+		fmt.Fprintf(w, "  %d %s\n", len(p.Paths), p.Reason)
+		for _, path := range p.Paths[:min(len(p.Paths), compatExamples)] {
+			fmt.Fprintf(w, "    %s\n", path)
+		}
+		if len(p.Paths) > compatExamples {
+			fmt.Fprintf(w, "    ... and %d more\n", len(p.Paths)-compatExamples)
+		}
+	}
+
+	return nil
+}
+
+// enforceCompatibility logs the problems of a report and fails with --fs-check fail
+func enforceCompatibility(logger *slog.Logger, report compatReport, mode string) error {
+	if len(report.Problems) == 0 {
+		return nil
+	}
+
+	for _, p := range report.Problems {
+		logger.Warn("Destination filesystem can't store some entries", "fs", report.FSType, "problem", p.Reason, "entries", len(p.Paths), "example", p.Paths[0])
+	}
+
+	if mode == FSCheckFail {
+		return fmt.Errorf("destination filesystem %s can't store %d entries of the image", report.FSType, report.entries())
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// completeImagePathArgs completes the first argument as an image on disk and every
+// following argument as a path inside that image
+func completeImagePathArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return []string{"iso"}, cobra.ShellCompDirectiveFilterFileExt
+	}
+
+	return completeImagePath(args[0], toComplete)
+}
+
+// completeImagePathFlag completes --path values inside the image given as first argument
+func completeImagePathFlag(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	// The source may be a glob, complete against the first image it matches
+	matches, err := filepath.Glob(args[0])
+	if err != nil || len(matches) == 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	return completeImagePath(matches[0], toComplete)
+}
+
+// completeImagePath opens isoFile and lists the entries of the directory toComplete is in,
+// one level at a time so completion stays fast on large images
+func completeImagePath(isoFile, toComplete string) ([]string, cobra.ShellCompDirective) {
+	image, err := openBackend(&imageSource{Path: isoFile})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	defer image.Close()
+
+	// Keep whatever the user typed up to the last slash so the shell's prefix matching works
+	dir, typed, prefix := "/", "", toComplete
+	if i := strings.LastIndex(toComplete, "/"); i >= 0 {
+		dir, typed, prefix = cleanImagePath(toComplete[:i+1]), toComplete[:i+1], toComplete[i+1:]
+	}
+
+	entries, err := readDir(image, dir)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var completions []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name, prefix) {
+			continue
+		}
+
+		completion := typed + entry.Name
+		if entry.IsDir {
+			completion += "/"
+		}
+		completions = append(completions, completion)
+	}
+
+	// Directories end in a slash, don't add a space so their contents can be completed next
+	return completions, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+// checkOnlyKey marks the context of an extract command that config check runs, it stops
+// once the flags are validated
+type checkOnlyKey struct{}
+
+func checkOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(checkOnlyKey{}).(bool)
+	return only
+}
+
+// configCheck prints the result of every check and counts the failed ones
+type configCheck struct {
+	out      io.Writer
+	problems int
+}
+
+func (c *configCheck) report(what string, err error) {
+	if err != nil {
+		c.problems++
+		fmt.Fprintf(c.out, "FAIL %s: %v\n", what, err)
+		return
+	}
+	fmt.Fprintf(c.out, "ok   %s\n", what)
+}
+
+// checkMessages renders every message template with sample data, a template referring to
+// something messages don't have only fails once it is shown
+func checkMessages(c *configCheck) {
+	ids := make([]string, 0, len(messages))
+	for id := range messages {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	data := messageData{Image: "example.iso", Duration: "1m0s", Done: 1, Total: 2, Version: version}
+	for _, id := range ids {
+		err := messages[id].Execute(io.Discard, data)
+		c.report("message "+id, err)
+	}
+}
+
+// checkIgnoreFile parses the standing ignore rules of the config directory
+func checkIgnoreFile(c *configCheck) {
+	files := ignoreFiles("", "")
+	lines, err := readIgnoreFiles(files, "")
+	if err == nil {
+		_, err = parseIgnoreRules(lines)
+	}
+	c.report("ignore rules", err)
+}
+
+// checkDestination fails if the destination or the closest of its parents that exists
+// isn't a directory, so the job could never create it
+func checkDestination(dest string) error {
+	for dir := dest; ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if errors.Is(err, os.ErrNotExist) && filepath.Dir(dir) != dir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	}
+}
+
+// checkJob validates a job the way running it would, without opening its source
+func checkJob(job jobDefinition) error {
+	args, err := job.args()
+	if err != nil {
+		return err
+	}
+	if !isRemotePath(job.Source) {
+		if _, err := filepath.Glob(job.Source); err != nil {
+			return fmt.Errorf("invalid source pattern: %w", err)
+		}
+	}
+	if !isDestinationTemplate(job.Destination) {
+		if err := checkDestination(job.Destination); err != nil {
+			return fmt.Errorf("invalid destination: %w", err)
+		}
+	}
+
+	extract := CommandExtract()
+	extract.SetArgs(args)
+	extract.SetOut(io.Discard)
+	extract.SilenceUsage = true
+	extract.SilenceErrors = true
+	return extract.ExecuteContext(context.WithValue(context.Background(), checkOnlyKey{}, true))
+}
+
+func CommandConfig() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration files",
+	}
+
+	command.AddCommand(CommandConfigCheck())
+
+	return command
+}
+
+func CommandConfigCheck() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "check",
+		Short: "Validate the jobs, messages and ignore rules before relying on them",
+		Long: `Validate the jobs, messages and ignore rules before relying on them
+
+Every job of the jobs file is checked like running it would: its flags are parsed and
+validated, including the routes file, report key and destination template they refer to.
+Sources aren't opened. Message templates are rendered with sample data and the ignore rules
+of the config directory are parsed. With --probe a desktop notification is shown to test
+that notifications reach the user.`,
+		Example: `  extractrr config check
+  extractrr config check --jobs ./jobs.json --probe`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 0 {
+				return fmt.Errorf("accepts no args")
+			}
+			return nil
+		},
+	}
+
+	var (
+		jobsPath = command.Flags().String("jobs", "", "Job definitions file (default: "+jobsName+" in the user config directory)")
+		probe    = command.Flags().Bool("probe", false, "Show a test desktop notification")
+	)
+
+	command.RunE = func(cmd *cobra.Command, args []string) error {
+		c := &configCheck{out: cmd.OutOrStdout()}
+
+		jobs, err := readJobs(*jobsPath)
+		switch {
+		// Without a jobs file there is nothing to check, unless it was asked for
+		case errors.Is(err, errNoJobs) && *jobsPath == "":
+			fmt.Fprintf(c.out, "skip jobs: %v\n", err)
+		case err != nil:
+			c.report("jobs", err)
+		default:
+			for _, name := range jobNames(jobs) {
+				c.report("job "+name, checkJob(jobs[name]))
+			}
+		}
+
+		checkMessages(c)
+		checkIgnoreFile(c)
+
+		if *probe {
+			title := msg(MsgNotifyCompleteTitle, messageData{})
+			message := msg(MsgNotifyFinished, messageData{Image: "example.iso", Duration: "1m0s"})
+			c.report("desktop notification", notifyDesktop(title, message))
+		}
+
+		if c.problems > 0 {
+			return fmt.Errorf("%d problems found", c.problems)
+		}
+		return nil
+	}
+
+	return command
+}
@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// setupConsole has nothing to do, terminals take UTF-8 and escape sequences as they are
+func setupConsole() {}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// codePageUTF8 is the console code page of UTF-8
+const codePageUTF8 = 65001
+
+// setupConsole makes the console print UTF-8, so file names outside the code page show
+// as they are, and interpret the escape sequences of the progress display. Windows
+// Terminal does both on its own, conhost only when asked to.
+func setupConsole() {
+	windows.SetConsoleOutputCP(codePageUTF8)
+
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		handle := windows.Handle(f.Fd())
+		var mode uint32
+		if err := windows.GetConsoleMode(handle, &mode); err != nil {
+			// Redirected to a file or pipe
+			continue
+		}
+		if err := windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil && f == os.Stderr {
+			// Consoles before Windows 10 can't, the display then redraws a single line
+			consoleEscapes = false
+		}
+	}
+}
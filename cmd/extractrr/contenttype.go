@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sniffLen is how much of the start of a file the type is detected from
+const sniffLen = 4096
+
+// Types detectType reports beyond those of http.DetectContentType
+const (
+	TypeMPEGTS       = "video/mp2t"
+	TypeAACS         = "application/x-aacs-encrypted"
+	TypeHighEntropy  = "application/x-high-entropy"
+	TypeDVDIFO       = "application/x-dvd-ifo"
+	TypeBlurayIndex  = "application/x-bluray-index"
+	TypeBlurayObject = "application/x-bluray-movieobject"
+	TypeBlurayList   = "application/x-bluray-playlist"
+	TypeBlurayClip   = "application/x-bluray-clipinfo"
+)
+
+const typeOctetStream = "application/octet-stream"
+
+// highEntropyBits is the entropy per byte above which unknown data counts as random,
+// sniffLen bytes of random data come out just below 8
+const highEntropyBits = 7.8
+
+// Packet sizes of transport streams and the BDAV streams of Blu-rays, which prefix
+// every packet with a 4 byte header
+const (
+	mpegTSPacketSize = 188
+	blurayPacketSize = 192
+)
+
+// typeExamples is how many paths the warnings about types list
+const typeExamples = 5
+
+// blurayMagics are the headers of the BDMV database files
+var blurayMagics = map[string]string{
+	"INDX": TypeBlurayIndex,
+	"MOBJ": TypeBlurayObject,
+	"MPLS": TypeBlurayList,
+	"HDMV": TypeBlurayClip,
+}
+
+// expectedTypes are the types files of common disc and media extensions should have
+var expectedTypes = map[string]string{
+	".m2ts": TypeMPEGTS,
+	".mts":  TypeMPEGTS,
+	".ts":   TypeMPEGTS,
+	".vob":  "video/mpeg",
+	".ifo":  TypeDVDIFO,
+	".bup":  TypeDVDIFO,
+	".mpls": TypeBlurayList,
+	".clpi": TypeBlurayClip,
+	".mkv":  "video/webm",
+	".mp4":  "video/mp4",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".pdf":  "application/pdf",
+}
+
+// typeSniffer keeps the start of a file as it is written
+type typeSniffer struct {
+	head []byte
+}
+
+func newTypeSniffer() *typeSniffer {
+	return &typeSniffer{head: make([]byte, 0, sniffLen)}
+}
+
+func (s *typeSniffer) Write(p []byte) (int, error) {
+	if n := min(cap(s.head)-len(s.head), len(p)); n > 0 {
+		s.head = append(s.head, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// reset drops what was kept, for a file that is written again
+func (s *typeSniffer) reset() {
+	s.head = s.head[:0]
+}
+
+// withSniffer adds the sniffer to what the hash ring feeds, w may be nil
+func withSniffer(w io.Writer, s *typeSniffer) io.Writer {
+	if w == nil {
+		return s
+	}
+	return io.MultiWriter(w, s)
+}
+
+// detectType names the content of a file from its first bytes. Disc structures and
+// transport streams come first since http.DetectContentType doesn't know them, data it
+// doesn't know either that looks random is reported as such, it is usually encrypted.
+func detectType(head []byte) string {
+	if len(head) == 0 {
+		return ""
+	}
+
+	switch {
+	case len(head) > blurayPacketSize+4 && head[4] == 0x47 && head[blurayPacketSize+4] == 0x47:
+		return TypeMPEGTS
+	// AACS leaves the first bytes of every unit in the clear, with the copy permission
+	// bits of the packet header set
+	case len(head) > 16 && head[4] == 0x47 && head[0]&0xc0 != 0 && entropy(head[16:]) > highEntropyBits:
+		return TypeAACS
+	case len(head) > mpegTSPacketSize && head[0] == 0x47 && head[mpegTSPacketSize] == 0x47:
+		return TypeMPEGTS
+	case bytes.HasPrefix(head, []byte("DVDVIDEO-")):
+		return TypeDVDIFO
+	}
+	if len(head) >= 4 {
+		if t, ok := blurayMagics[string(head[:4])]; ok {
+			return t
+		}
+	}
+
+	t, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	if t == typeOctetStream && len(head) >= sniffLen && entropy(head) > highEntropyBits {
+		return TypeHighEntropy
+	}
+	return t
+}
+
+// entropy returns the Shannon entropy of data in bits per byte
+func entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(data))
+			bits -= p * math.Log2(p)
+		}
+	}
+	return bits
+}
+
+// typeMismatch reports whether a file of a known extension doesn't have its type
+func typeMismatch(path, detected string) bool {
+	expected, ok := expectedTypes[strings.ToLower(filepath.Ext(path))]
+	return ok && detected != "" && detected != expected && detected != TypeAACS && detected != TypeHighEntropy
+}
+
+// typeCounts returns the detected types with how many files have them, the most frequent first
+func typeCounts(results map[string]fileResult) []string {
+	counts := make(map[string]int)
+	for _, result := range results {
+		if result.Type != "" {
+			counts[result.Type]++
+		}
+	}
+
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if counts[types[i]] != counts[types[j]] {
+			return counts[types[i]] > counts[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	for i, t := range types {
+		types[i] = fmt.Sprintf("%s (%d)", t, counts[t])
+	}
+	return types
+}
+
+// warnContentTypes points out files that look encrypted or don't have the type their
+// extension promises
+func warnContentTypes(logger *slog.Logger, results map[string]fileResult) {
+	var encrypted, mismatched []string
+	for path, result := range results {
+		switch {
+		case result.Type == TypeAACS || result.Type == TypeHighEntropy:
+			encrypted = append(encrypted, path)
+		case typeMismatch(path, result.Type):
+			mismatched = append(mismatched, path+" ("+result.Type+")")
+		}
+	}
+	sort.Strings(encrypted)
+	sort.Strings(mismatched)
+
+	if len(encrypted) > 0 {
+		logger.Warn("Files look encrypted", "files", len(encrypted), "examples", encrypted[:min(len(encrypted), typeExamples)])
+	}
+	if len(mismatched) > 0 {
+		logger.Warn("Files don't have the type of their extension", "files", len(mismatched), "examples", mismatched[:min(len(mismatched), typeExamples)])
+	}
+}
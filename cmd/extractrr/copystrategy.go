@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/dustin/go-humanize"
+)
+
+// defaultLargeFileSize is where --large-strategy takes over from --small-strategy
+const defaultLargeFileSize = 64 * 1024 * 1024
+
+// directAlignment is the offset, length and memory alignment of direct writes, a multiple
+// of the logical block size of common devices
+const directAlignment = 4096
+
+var errDirectIOUnsupported = errors.New("direct IO is not supported on this platform")
+
+// copyStrategySettings are the settings of a strategy in the order they are printed
+var copyStrategySettings = []string{"buffer", "preallocate", "fsync", "direct"}
+
+// copyStrategy is how the files of one size class are written
+type copyStrategy struct {
+	// BufferSize replaces --buffer for the class, 0 keeps it
+	BufferSize int `json:"buffer_size,omitempty"`
+	// Preallocate reserves the space of the file before the first write, which keeps
+	// large files from fragmenting on filesystems that support it
+	Preallocate bool `json:"preallocate,omitempty"`
+	// Fsync flushes the file to the device before it is closed
+	Fsync bool `json:"fsync,omitempty"`
+	// DirectIO writes past the page cache, so huge streams don't evict everything else
+	DirectIO bool `json:"direct_io,omitempty"`
+}
+
+// parseCopyStrategy parses a comma separated list of settings like
+// "buffer=16MiB,preallocate,direct", "" is no strategy
+func parseCopyStrategy(spec string) (*copyStrategy, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	var s copyStrategy
+	for _, setting := range strings.Split(spec, ",") {
+		key, value, hasValue := strings.Cut(strings.TrimSpace(setting), "=")
+		if key == "buffer" {
+			if !hasValue {
+				return nil, fmt.Errorf("buffer needs a size, e.g. buffer=16MiB")
+			}
+			var size byteSize
+			if err := size.Set(value); err != nil {
+				return nil, fmt.Errorf("invalid buffer %q: %w", value, err)
+			}
+			s.BufferSize = int(size)
+			continue
+		}
+
+		enabled := true
+		if hasValue {
+			var err error
+			if enabled, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", key, value, err)
+			}
+		}
+		switch key {
+		case "preallocate":
+			s.Preallocate = enabled
+		case "fsync":
+			s.Fsync = enabled
+		case "direct":
+			s.DirectIO = enabled
+		default:
+			return nil, fmt.Errorf("unknown setting %q: must be one of %s", key, strings.Join(copyStrategySettings, ", "))
+		}
+	}
+	return &s, nil
+}
+
+func (s copyStrategy) String() string {
+	var settings []string
+	if s.BufferSize > 0 {
+		settings = append(settings, "buffer="+humanize.IBytes(uint64(s.BufferSize)))
+	}
+	if s.Preallocate {
+		settings = append(settings, "preallocate")
+	}
+	if s.Fsync {
+		settings = append(settings, "fsync")
+	}
+	if s.DirectIO {
+		settings = append(settings, "direct")
+	}
+	return strings.Join(settings, ",")
+}
+
+// strategy returns how a file of size bytes is written
+func (o ExtractOptions) strategy(size int64) copyStrategy {
+	class := o.SmallStrategy
+	if o.LargeFileSize > 0 && size >= o.LargeFileSize {
+		class = o.LargeStrategy
+	}
+
+	var s copyStrategy
+	if class != nil {
+		s = *class
+	}
+	if s.BufferSize == 0 {
+		s.BufferSize = o.BufferSize
+	}
+	return s
+}
+
+// strategyBuffers are the buffers of one buffer size, shared by the classes using it
+type strategyBuffers struct {
+	buffers sync.Pool
+	spares  sync.Pool
+	rings   sync.Pool
+}
+
+// newStrategyBuffers returns the buffer pools for every buffer size opts may use, keyed by
+// the aligned size. The map isn't written to afterwards, so the workers can share it.
+func newStrategyBuffers(opts ExtractOptions, slots chan struct{}) map[int]*strategyBuffers {
+	pools := make(map[int]*strategyBuffers)
+	for _, size := range []int{opts.strategy(0).BufferSize, opts.strategy(max(opts.LargeFileSize, 1)).BufferSize} {
+		size = alignBufferSize(size)
+		if pools[size] != nil {
+			continue
+		}
+		pools[size] = &strategyBuffers{
+			buffers: sync.Pool{New: func() any { return make([]byte, size) }},
+			spares:  sync.Pool{New: func() any { return make([]byte, size) }},
+			rings:   sync.Pool{New: func() any { return newHashRing(size, slots) }},
+		}
+	}
+	return pools
+}
+
+// directIOWarning is logged once if the destination can't be written with direct IO
+var directIOWarning sync.Once
+
+// strategyFile writes a destination file the way its copy strategy says
+type strategyFile struct {
+	f      *os.File
+	direct *directWriter
+	fsync  bool
+	closed bool
+}
+
+// newStrategyFile applies s to f, which is about to receive size bytes
+func newStrategyFile(f *os.File, size int64, s copyStrategy) *strategyFile {
+	w := &strategyFile{f: f, fsync: s.Fsync}
+
+	if s.Preallocate && size > 0 {
+		// Only a hint, the writes find out soon enough if the space isn't there
+		if err := preallocate(f, size); err != nil {
+			slog.Debug("Failed to preallocate file", "file", f.Name(), "bytes", size, "error", err)
+		}
+	}
+
+	if s.DirectIO {
+		if err := enableDirectIO(f); err != nil {
+			directIOWarning.Do(func() {
+				slog.Warn("Writing through the page cache, the destination doesn't support direct IO", "error", err)
+			})
+		} else {
+			size := (s.BufferSize + directAlignment - 1) / directAlignment * directAlignment
+			w.direct = &directWriter{f: f, buf: alignedBuffer(size)}
+		}
+	}
+
+	return w
+}
+
+func (w *strategyFile) Write(p []byte) (int, error) {
+	if w.direct != nil {
+		return w.direct.Write(p)
+	}
+	return w.f.Write(p)
+}
+
+// Close writes what direct IO still holds and flushes the file if asked to. Closing it
+// again does nothing, unlike closing an os.File twice.
+func (w *strategyFile) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	var err error
+	if w.direct != nil {
+		err = w.direct.close()
+	}
+	if w.fsync && err == nil {
+		err = w.f.Sync()
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// directWriter collects writes in an aligned buffer, direct IO only takes whole blocks
+// from aligned memory. The tail of the file is written without it.
+type directWriter struct {
+	f   *os.File
+	buf []byte
+	n   int
+}
+
+func (d *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		if d.n == len(d.buf) {
+			if err := d.flush(d.n); err != nil {
+				return written, err
+			}
+		}
+		n := copy(d.buf[d.n:], p[written:])
+		d.n += n
+		written += n
+	}
+	return written, nil
+}
+
+// flush writes the first n buffered bytes, what a failed write left stays buffered
+func (d *directWriter) flush(n int) error {
+	written, err := d.f.Write(d.buf[:n])
+	d.n = copy(d.buf, d.buf[written:d.n])
+	return err
+}
+
+// close writes the whole blocks still buffered directly and the rest through the page cache
+func (d *directWriter) close() error {
+	if err := d.flush(d.n - d.n%directAlignment); err != nil {
+		return err
+	}
+	if d.n == 0 {
+		return nil
+	}
+	if err := disableDirectIO(d.f); err != nil {
+		return err
+	}
+	return d.flush(d.n)
+}
+
+// alignedBuffer returns a buffer of size bytes starting at a multiple of directAlignment
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directAlignment)
+	offset := 0
+	if r := int(uintptr(unsafe.Pointer(&buf[0])) % directAlignment); r != 0 {
+		offset = directAlignment - r
+	}
+	return buf[offset : offset+size : offset+size]
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate reserves size bytes for f without changing its size, so an interrupted
+// extraction doesn't leave files that look complete
+func preallocate(f *os.File, size int64) error {
+	return unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+}
+
+func enableDirectIO(f *os.File) error {
+	return setFileFlags(f, unix.O_DIRECT, true)
+}
+
+func disableDirectIO(f *os.File) error {
+	return setFileFlags(f, unix.O_DIRECT, false)
+}
+
+func setFileFlags(f *os.File, flag int, on bool) error {
+	flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	if on {
+		flags |= flag
+	} else {
+		flags &^= flag
+	}
+	_, err = unix.FcntlInt(f.Fd(), unix.F_SETFL, flags)
+	return err
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// preallocate does nothing, the space is allocated as the file is written
+func preallocate(f *os.File, size int64) error {
+	return nil
+}
+
+func enableDirectIO(f *os.File) error {
+	return errDirectIOUnsupported
+}
+
+func disableDirectIO(f *os.File) error {
+	return nil
+}
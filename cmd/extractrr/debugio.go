@@ -0,0 +1,98 @@
+package main
+
+/*
+#include <stdlib.h>
+#include <udfread/udfread.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+	"unsafe"
+)
+
+// ioTrace receives a record of every libudfread call when --debug-io is set, nil otherwise
+var ioTrace *slog.Logger
+
+// setupIOTrace enables --debug-io. Records go to the default logger, or as JSON lines
+// to file when one is given so they don't drown the regular output.
+func setupIOTrace(enabled bool, file string) error {
+	if !enabled && file == "" {
+		return nil
+	}
+
+	if file == "" {
+		ioTrace = slog.Default().With("trace", "io")
+		return nil
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open io trace file: %w", err)
+	}
+	ioTrace = slog.New(slog.NewJSONHandler(f, nil))
+
+	return nil
+}
+
+// traceIO records one call, ret is the raw return value of libudfread
+func traceIO(op string, start time.Time, ret int64, attrs ...any) {
+	ioTrace.Info(op, append(attrs, "ret", ret, "duration", time.Since(start).String())...)
+}
+
+// openUDFFile opens a file of the image
+func openUDFFile(udf *C.udfread, path string) *C.UDFFILE {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	if ioTrace == nil {
+		return C.udfread_file_open(udf, cPath)
+	}
+
+	start := time.Now()
+	file := C.udfread_file_open(udf, cPath)
+	ret := int64(0)
+	if file == nil {
+		ret = -1
+	}
+	traceIO("open", start, ret, "path", path)
+
+	return file
+}
+
+// readUDFFile reads the next bytes of file into buf
+func readUDFFile(file *C.UDFFILE, path string, buf []byte) int64 {
+	if ioTrace == nil {
+		return int64(C.udfread_file_read(file, unsafe.Pointer(&buf[0]), C.size_t(len(buf))))
+	}
+
+	offset := int64(C.udfread_file_tell(file))
+	start := time.Now()
+	n := int64(C.udfread_file_read(file, unsafe.Pointer(&buf[0]), C.size_t(len(buf))))
+	traceIO("read", start, n, "path", path, "offset", offset, "size", len(buf))
+
+	return n
+}
+
+// openUDFDir opens a directory of the image
+func openUDFDir(udf *C.udfread, path string) *C.UDFDIR {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	if ioTrace == nil {
+		return C.udfread_opendir(udf, cPath)
+	}
+
+	start := time.Now()
+	dir := C.udfread_opendir(udf, cPath)
+	ret := int64(0)
+	if dir == nil {
+		ret = -1
+	}
+	traceIO("opendir", start, ret, "path", path)
+
+	return dir
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+)
+
+// imageFile is a file of one of the images compared by list --dedupe
+type imageFile struct {
+	Image string
+	Path  string
+	Size  int64
+}
+
+// listDuplicates reports the files that appear in more than one of the images, by size
+// and content, and how much of every image is also found in the others. Only files whose
+// size occurs in several images are hashed.
+func listDuplicates(w io.Writer, patterns []string, workers, bufferSize int) error {
+	var images []string
+	for _, pattern := range patterns {
+		matches, err := expandImagePattern(pattern)
+		if err != nil {
+			return err
+		}
+		images = append(images, matches...)
+	}
+	if len(images) < 2 {
+		return fmt.Errorf("--dedupe needs at least two images, got %d", len(images))
+	}
+
+	scans := make([][]Job, len(images))
+	imagesBySize := make(map[int64]map[int]bool)
+	for i, image := range images {
+		jobs, _, err := scanImageJobs(&imageSource{Path: image}, 0, nil)
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %w", image, err)
+		}
+		scans[i] = jobs
+
+		for _, job := range jobs {
+			if imagesBySize[job.Size] == nil {
+				imagesBySize[job.Size] = make(map[int]bool)
+			}
+			imagesBySize[job.Size][i] = true
+		}
+	}
+
+	// Files of the same content are grouped by size and hash
+	groups := make(map[string][]imageFile)
+	for i, image := range images {
+		var candidates []Job
+		var size int64
+		for _, job := range scans[i] {
+			if job.Size > 0 && len(imagesBySize[job.Size]) > 1 {
+				candidates = append(candidates, job)
+				size += job.Size
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		src := &imageSource{Path: image}
+		logger := slog.With("job", newJobID(), "iso", image)
+		logger.Info("Hashing candidate files", "files", len(candidates), "bytes", size)
+
+		results, err := runPool(logger, src, candidates, size, poolOptions{Workers: workers, BufferSize: bufferSize}, func(ctx context.Context, image SourceBackend, job Job, buffer []byte, progressChan chan<- int64) fileResult {
+			h := newChecksum(ChecksumXXH3)
+			_, err := readImageFile(image, job.SrcPath, buffer, func(chunk []byte) error {
+				h.Write(chunk)
+				progressChan <- int64(len(chunk))
+				return nil
+			})
+			return fileResult{Digest: h.Sum(nil), Err: err}
+		})
+		if err != nil {
+			return err
+		}
+		if failed := reportFailures(logger, candidates, results); failed > 0 {
+			return fmt.Errorf("failed to hash %d files of %s", failed, image)
+		}
+
+		for j, job := range candidates {
+			key := fmt.Sprintf("%d:%s", job.Size, hex.EncodeToString(results[j].Digest))
+			groups[key] = append(groups[key], imageFile{Image: image, Path: job.SrcPath, Size: job.Size})
+		}
+	}
+
+	// A group counts once it spans images, copies within one image are not redundant ISOs
+	var duplicates [][]imageFile
+	shared := make(map[string]int64)
+	for _, files := range groups {
+		spanned := make(map[string]bool)
+		for _, f := range files {
+			spanned[f.Image] = true
+		}
+		if len(spanned) < 2 {
+			continue
+		}
+
+		duplicates = append(duplicates, files)
+		for _, f := range files {
+			shared[f.Image] += f.Size
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i][0].Size != duplicates[j][0].Size {
+			return duplicates[i][0].Size > duplicates[j][0].Size
+		}
+		return duplicates[i][0].Path < duplicates[j][0].Path
+	})
+
+	for _, files := range duplicates {
+		fmt.Fprintf(w, "%s  %s\n", humanize.IBytes(uint64(files[0].Size)), filepath.Base(files[0].Path))
+		for _, f := range files {
+			fmt.Fprintf(w, "  %s:%s\n", f.Image, f.Path)
+		}
+	}
+
+	if len(duplicates) > 0 {
+		fmt.Fprintln(w)
+	}
+	for i, image := range images {
+		var total int64
+		for _, job := range scans[i] {
+			total += job.Size
+		}
+
+		percent := 0.0
+		if total > 0 {
+			percent = float64(shared[image]) / float64(total) * 100
+		}
+		fmt.Fprintf(w, "%s: %s of %s also in other images (%.0f%%)\n", image, humanize.IBytes(uint64(shared[image])), humanize.IBytes(uint64(total)), percent)
+	}
+
+	return nil
+}
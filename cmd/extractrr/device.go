@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// isBlockDevice reports whether path is a block device, e.g. /dev/sr0 or a loop device
+func isBlockDevice(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeDevice != 0 && info.Mode()&os.ModeCharDevice == 0
+}
+
+// sourceDevice returns the block device a local source is read from: the source itself if it
+// is one, or the device an already mounted disc at that directory is backed by
+func sourceDevice(path string) (string, bool) {
+	if isRemotePath(path) {
+		return "", false
+	}
+	if isBlockDevice(path) {
+		return path, true
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	device, err := mountDevice(abs)
+	if err != nil || !isBlockDevice(device) {
+		return "", false
+	}
+	return device, true
+}
+
+// isDeviceSource reports whether a local source is read from a block device
+func isDeviceSource(path string) bool {
+	_, ok := sourceDevice(path)
+	return ok
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// printPlan writes what an extraction into extractDir would do, as a flat list or as a tree
+func printPlan(w io.Writer, extractDir string, scan *scanResult, tree bool) error {
+	bw := bufio.NewWriter(w)
+
+	if tree {
+		root := buildPlanTree(scan)
+		fmt.Fprintf(bw, "%s (%d files, %s)\n", extractDir, scan.FileCount, humanize.IBytes(uint64(scan.TotalSize)))
+		root.print(bw, "")
+	} else {
+		fmt.Fprintf(bw, "Destination: %s\n", extractDir)
+		for _, job := range scan.Jobs {
+			fmt.Fprintf(bw, "  %s -> %s (%s)\n", job.SrcPath, filepath.Join(extractDir, job.DstPath), humanize.IBytes(uint64(job.Size)))
+		}
+		for _, skipped := range scan.Skipped {
+			fmt.Fprintf(bw, "  skip %s (%s)\n", skipped.SrcPath, skipped.Reason)
+		}
+	}
+
+	fmt.Fprintf(bw, "Total: %d files, %s; skipped %d entries, %s\n", scan.FileCount, humanize.IBytes(uint64(scan.TotalSize)), len(scan.Skipped), humanize.IBytes(uint64(scan.skippedSize())))
+	if c := scan.counts(); c != (scanCounts{}) {
+		fmt.Fprintf(bw, "Without data: %d empty files, %d empty directories, %d special entries\n", c.EmptyFiles, c.EmptyDirs, c.Special)
+	}
+	if files, size := ssifSize(scan); files > 0 {
+		fmt.Fprintf(bw, "3D: %d SSIF files, %s, repeat the data of the 2D streams (--ssif skip leaves them out)\n", files, humanize.IBytes(uint64(size)))
+	}
+
+	return bw.Flush()
+}
+
+// planNode is a file or directory in the planned destination layout
+type planNode struct {
+	name     string
+	isDir    bool
+	size     int64
+	reason   string
+	children map[string]*planNode
+}
+
+// buildPlanTree arranges the scanned jobs and skipped entries by their destination paths
+func buildPlanTree(scan *scanResult) *planNode {
+	root := &planNode{isDir: true, children: make(map[string]*planNode)}
+
+	for _, dir := range scan.Dirs {
+		root.insert(dir, true, 0, "")
+	}
+	for _, job := range scan.Jobs {
+		root.insert(job.DstPath, false, job.Size, "")
+	}
+	for _, skipped := range scan.Skipped {
+		root.insert(skipped.DstPath, skipped.IsDir, skipped.Size, skipped.Reason)
+	}
+
+	return root
+}
+
+// insert adds the entry at the relative path rel, creating parent directories as needed.
+// Sizes of kept files are added to every parent directory.
+func (n *planNode) insert(rel string, isDir bool, size int64, reason string) {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+
+	node := n
+	for i, part := range parts {
+		if part == "" || part == "." {
+			continue
+		}
+
+		if reason == "" {
+			node.size += size
+		}
+
+		child, ok := node.children[part]
+		if !ok {
+			child = &planNode{name: part, isDir: true, children: make(map[string]*planNode)}
+			node.children[part] = child
+		}
+
+		if i == len(parts)-1 {
+			child.isDir = isDir
+			child.reason = reason
+			if !isDir && reason == "" {
+				child.size = size
+			}
+		}
+		node = child
+	}
+}
+
+func (n *planNode) print(w io.Writer, prefix string) {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		child := n.children[name]
+
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+
+		label := child.name
+		if child.isDir {
+			label += "/"
+		}
+
+		switch {
+		case child.reason != "":
+			label += fmt.Sprintf(" (skipped: %s)", child.reason)
+		case child.size > 0 || !child.isDir:
+			label += fmt.Sprintf(" (%s)", humanize.IBytes(uint64(child.size)))
+		}
+
+		fmt.Fprintf(w, "%s%s%s\n", prefix, branch, label)
+		child.print(w, prefix+next)
+	}
+}
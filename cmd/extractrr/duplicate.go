@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// What to do when the destination already holds a complete extraction of the same disc
+const (
+	DuplicateOff  = "off"
+	DuplicateWarn = "warn"
+	DuplicateSkip = "skip"
+)
+
+var duplicateModes = []string{DuplicateOff, DuplicateWarn, DuplicateSkip}
+
+func validateDuplicateMode(mode string) error {
+	if !slices.Contains(duplicateModes, mode) {
+		return fmt.Errorf("invalid duplicate mode %q: must be one of %s", mode, strings.Join(duplicateModes, ", "))
+	}
+	return nil
+}
+
+// findDuplicate returns the sidecar of a complete extraction in extractDir of a disc with
+// the same volume label that already holds every scanned file. The same disc ripped twice
+// rarely gives identical images, so the source fingerprint isn't compared.
+func findDuplicate(extractDir, label string, scan *scanResult) (*Sidecar, bool) {
+	if label == "" {
+		return nil, false
+	}
+
+	existing, err := readSidecar(extractDir)
+	if err != nil || existing.Status != StatusComplete || existing.Source.VolumeLabel != label {
+		return nil, false
+	}
+
+	extracted := make(map[string]int64, len(existing.Files))
+	for _, f := range existing.Files {
+		if f.Status == StatusComplete {
+			extracted[f.SrcPath] = f.Size
+		}
+	}
+	for _, job := range scan.Jobs {
+		if size, ok := extracted[job.SrcPath]; !ok || size != job.Size {
+			return nil, false
+		}
+	}
+
+	return existing, true
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errorBurst is how many errors of a kind are logged in full before they are only counted
+const errorBurst = 5
+
+// errorReportInterval is how often the errors of a kind counted since are logged as one line
+const errorReportInterval = 10 * time.Second
+
+// errorKind returns what is the same about errors with the same cause, without the paths
+// that differ between files, e.g. "open: read-only file system"
+func errorKind(err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Op + ": " + pathErr.Err.Error()
+	}
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		return linkErr.Op + ": " + linkErr.Err.Error()
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno.Error()
+	}
+	return err.Error()
+}
+
+// errorThrottle collapses storms of identical per-file errors, when something systemic like
+// a read-only destination fails every file. The first errors of every kind are logged as
+// they are, the rest are counted and logged together every errorReportInterval.
+type errorThrottle struct {
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	kinds map[string]*errorCount
+	order []string
+}
+
+type errorCount struct {
+	msg        string
+	total      int
+	suppressed int
+	lastFile   string
+	lastReport time.Time
+}
+
+func newErrorThrottle(logger *slog.Logger) *errorThrottle {
+	return &errorThrottle{logger: logger, kinds: make(map[string]*errorCount)}
+}
+
+// error logs msg for err unless too many errors of its kind were logged already
+func (t *errorThrottle) error(msg string, err error, file string, attrs ...any) {
+	kind := errorKind(err)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.kinds[kind]
+	if c == nil {
+		c = &errorCount{msg: msg}
+		t.kinds[kind] = c
+		t.order = append(t.order, kind)
+	}
+	c.total++
+
+	if c.total <= errorBurst {
+		t.logger.Error(msg, append([]any{"file", file}, append(attrs, "error", err)...)...)
+		if c.total == errorBurst {
+			t.logger.Warn("Error keeps repeating, logging a count instead", "kind", kind, "interval", errorReportInterval)
+			c.lastReport = time.Now()
+		}
+		return
+	}
+
+	c.suppressed++
+	c.lastFile = file
+	if time.Since(c.lastReport) >= errorReportInterval {
+		t.report(kind, c)
+	}
+}
+
+// report logs the errors of a kind counted since the last report
+func (t *errorThrottle) report(kind string, c *errorCount) {
+	t.logger.Error(c.msg, "kind", kind, "repeated", c.suppressed, "total", c.total, "last_file", c.lastFile)
+	c.suppressed = 0
+	c.lastReport = time.Now()
+}
+
+// flush logs the errors still counted, once no more are coming
+func (t *errorThrottle) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, kind := range t.order {
+		if c := t.kinds[kind]; c.suppressed > 0 {
+			t.report(kind, c)
+		}
+	}
+}
+
+// errorKinds returns how many files failed and the distinct kinds of their errors with
+// counts, the most frequent first
+func errorKinds(results map[string]fileResult) (int, []string) {
+	counts := make(map[string]int)
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			counts[errorKind(result.Err)]++
+			failed++
+		}
+	}
+
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if counts[kinds[i]] != counts[kinds[j]] {
+			return counts[kinds[i]] > counts[kinds[j]]
+		}
+		return kinds[i] < kinds[j]
+	})
+	for i, kind := range kinds {
+		kinds[i] = fmt.Sprintf("%s (%d)", kind, counts[kind])
+	}
+	return failed, kinds
+}
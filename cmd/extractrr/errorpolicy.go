@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Classes of per-file errors that --on-error sets policies for
+const (
+	ErrorClassRead       = "read"
+	ErrorClassWrite      = "write"
+	ErrorClassPermission = "permission"
+	ErrorClassSpace      = "space"
+)
+
+var errorClasses = []string{ErrorClassRead, ErrorClassWrite, ErrorClassPermission, ErrorClassSpace}
+
+// errorBackoff is the delay before the first retry when a policy doesn't set one
+const errorBackoff = time.Second
+
+// errorBackoffMax caps the delay between retries, it doubles with every one
+const errorBackoffMax = time.Minute
+
+// errFailFast is returned when a fail-fast policy ended an extraction
+var errFailFast = errors.New("aborted on a fail-fast error")
+
+// readError marks an error of the source image rather than the destination
+type readError struct {
+	err error
+}
+
+func (e *readError) Error() string { return e.err.Error() }
+func (e *readError) Unwrap() error { return e.err }
+
+// errorClass returns the class of a per-file error. Space and permission problems are
+// recognized wherever they happen, the rest is put down to the side it came from.
+func errorClass(err error) string {
+	var read *readError
+	switch {
+	case errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT):
+		return ErrorClassSpace
+	case errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS):
+		return ErrorClassPermission
+	case errors.As(err, &read):
+		return ErrorClassRead
+	default:
+		return ErrorClassWrite
+	}
+}
+
+func validateErrorClass(class string) error {
+	if !slices.Contains(errorClasses, class) {
+		return fmt.Errorf("invalid error class %q: must be one of %s", class, strings.Join(errorClasses, ", "))
+	}
+	return nil
+}
+
+// errorPolicy is how the files failing with a class of errors are handled
+type errorPolicy struct {
+	// Retries is how often a failed file is extracted again
+	Retries int `json:"retries,omitempty"`
+	// Backoff is the delay before the first retry, 0 for errorBackoff
+	Backoff time.Duration `json:"backoff,omitempty"`
+	// FailFast ends the extraction of the image once a file failed for good
+	FailFast bool `json:"fail_fast,omitempty"`
+}
+
+// parseErrorPolicies parses --on-error values like "read=retry=3,backoff=5s" or
+// "space=fail-fast" into policies by class
+func parseErrorPolicies(specs []string) (map[string]errorPolicy, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	policies := make(map[string]errorPolicy, len(specs))
+	for _, spec := range specs {
+		class, settings, ok := strings.Cut(spec, "=")
+		if !ok || settings == "" {
+			return nil, fmt.Errorf("invalid --on-error %q: must be CLASS=SETTINGS, e.g. read=retry=3", spec)
+		}
+		if err := validateErrorClass(class); err != nil {
+			return nil, err
+		}
+
+		var p errorPolicy
+		for _, setting := range strings.Split(settings, ",") {
+			key, value, hasValue := strings.Cut(strings.TrimSpace(setting), "=")
+			var err error
+			switch {
+			case key == "retry" && hasValue:
+				if p.Retries, err = strconv.Atoi(value); err != nil || p.Retries < 0 {
+					return nil, fmt.Errorf("invalid retry %q for %s: must be a count", value, class)
+				}
+			case key == "backoff" && hasValue:
+				if p.Backoff, err = time.ParseDuration(value); err != nil || p.Backoff <= 0 {
+					return nil, fmt.Errorf("invalid backoff %q for %s: must be a duration like 5s", value, class)
+				}
+			case key == "fail-fast" && !hasValue:
+				p.FailFast = true
+			default:
+				return nil, fmt.Errorf("unknown setting %q for %s: must be one of retry=N, backoff=DURATION, fail-fast", setting, class)
+			}
+		}
+		policies[class] = p
+	}
+	return policies, nil
+}
+
+// errorPolicy returns the policy for the class of err, the zero policy fails the file
+// right away and goes on with the others
+func (o ExtractOptions) errorPolicy(err error) errorPolicy {
+	return o.ErrorPolicies[errorClass(err)]
+}
+
+// retryDelay returns how long to wait before retrying a file that failed with err for the
+// attempt-th time, false if its policy has no retries left
+func (o ExtractOptions) retryDelay(err error, attempt int) (time.Duration, bool) {
+	p := o.errorPolicy(err)
+	if attempt > p.Retries {
+		return 0, false
+	}
+
+	delay := p.Backoff
+	if delay == 0 {
+		delay = errorBackoff
+	}
+	for i := 1; i < attempt && delay < errorBackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, errorBackoffMax), true
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// pathFilter selects which in-image paths a scan picks up. A nil filter selects everything.
+type pathFilter struct {
+	// Paths limits the scan to these files or directory subtrees. They may contain
+	// glob patterns, which are matched one path component at a time.
+	Paths []string
+	// Include and Exclude are glob patterns, matched against the full path when
+	// they contain a slash and against the base name otherwise
+	Include []string
+	Exclude []string
+	// IncludeRegex and ExcludeRegex are matched against the full path
+	IncludeRegex []*regexp.Regexp
+	ExcludeRegex []*regexp.Regexp
+	Ignore       ignoreRules
+	// MaxSize skips files larger than this, 0 for no limit
+	MaxSize int64
+}
+
+// filterOptions are the selection options a pathFilter is built from
+type filterOptions struct {
+	Paths        []string
+	Include      []string
+	Exclude      []string
+	IncludeRegex []string
+	ExcludeRegex []string
+	// Ignore are gitignore-style rules, see ignore.go
+	Ignore []string
+	// MaxSize is --skeleton
+	MaxSize int64
+}
+
+// newPathFilter returns a filter for the selection options, or nil if nothing is filtered
+func newPathFilter(o filterOptions) (*pathFilter, error) {
+	if len(o.Paths) == 0 && len(o.Include) == 0 && len(o.Exclude) == 0 && len(o.IncludeRegex) == 0 && len(o.ExcludeRegex) == 0 && len(o.Ignore) == 0 && o.MaxSize == 0 {
+		return nil, nil
+	}
+
+	f := &pathFilter{MaxSize: o.MaxSize}
+	for _, p := range o.Paths {
+		p = cleanImagePath(p)
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %w", p, err)
+		}
+		f.Paths = append(f.Paths, p)
+	}
+
+	for _, patterns := range [][]string{o.Include, o.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	f.Include = o.Include
+	f.Exclude = o.Exclude
+
+	var err error
+	if f.IncludeRegex, err = compileRegexps(o.IncludeRegex); err != nil {
+		return nil, err
+	}
+	if f.ExcludeRegex, err = compileRegexps(o.ExcludeRegex); err != nil {
+		return nil, err
+	}
+	if f.Ignore, err = parseIgnoreRules(o.Ignore); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func compileRegexps(exprs []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", expr, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// cleanImagePath normalizes a user supplied in-image path to the absolute form used by the scan
+func cleanImagePath(p string) string {
+	return path.Clean("/" + strings.TrimSpace(p))
+}
+
+// selected reports whether the file or directory at p is part of the --path selection
+func (f *pathFilter) selected(p string) bool {
+	if f == nil || len(f.Paths) == 0 {
+		return true
+	}
+
+	for _, sel := range f.Paths {
+		if sel == "/" || p == sel || strings.HasPrefix(p, sel+"/") {
+			return true
+		}
+		if hasGlob(sel) && matchSubtree(sel, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// descend reports whether the directory at p may contain selected entries
+func (f *pathFilter) descend(p string) bool {
+	if f.selected(p) {
+		return true
+	}
+
+	for _, sel := range f.Paths {
+		if p == "/" || strings.HasPrefix(sel, p+"/") {
+			return true
+		}
+		if hasGlob(sel) && matchParent(sel, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasGlob reports whether p contains glob metacharacters
+func hasGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[\\")
+}
+
+// matchSubtree reports whether p or one of its parent directories matches pattern
+func matchSubtree(pattern, p string) bool {
+	for ; p != "/"; p = path.Dir(p) {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchParent reports whether the directory p could contain a match of pattern,
+// i.e. its components match the leading components of the pattern
+func matchParent(pattern, p string) bool {
+	patterns := strings.Split(pattern, "/")
+	names := strings.Split(p, "/")
+	if len(names) >= len(patterns) {
+		return false
+	}
+
+	for i, name := range names {
+		if ok, _ := path.Match(patterns[i], name); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// skipReason returns why the entry at p is filtered out, or an empty string if it is kept.
+// Include patterns and expressions only apply to files so directories are always searched for matches.
+func (f *pathFilter) skipReason(p string, isDir bool) string {
+	if f == nil {
+		return ""
+	}
+
+	if !isDir && !f.selected(p) {
+		return "not selected by --path"
+	}
+
+	if pattern, ok := matchAny(f.Exclude, p); ok {
+		return fmt.Sprintf("excluded by %q", pattern)
+	}
+	if re, ok := matchAnyRegex(f.ExcludeRegex, p); ok {
+		return fmt.Sprintf("excluded by regex %q", re)
+	}
+	if rule, ok := f.Ignore.ignored(p, isDir); ok {
+		return fmt.Sprintf("ignored by %q", rule)
+	}
+
+	if !isDir && len(f.Include) > 0 {
+		if _, ok := matchAny(f.Include, p); !ok {
+			return "not matched by --include"
+		}
+	}
+	if !isDir && len(f.IncludeRegex) > 0 {
+		if _, ok := matchAnyRegex(f.IncludeRegex, p); !ok {
+			return "not matched by --include-regex"
+		}
+	}
+
+	return ""
+}
+
+// sizeSkipReason returns why a file of size is filtered out, or an empty string if it is kept
+func (f *pathFilter) sizeSkipReason(size int64) string {
+	if f == nil || f.MaxSize == 0 || size <= f.MaxSize {
+		return ""
+	}
+	return "larger than --skeleton " + humanize.IBytes(uint64(f.MaxSize))
+}
+
+// matchAnyRegex returns the first expression matching p
+func matchAnyRegex(exprs []*regexp.Regexp, p string) (string, bool) {
+	for _, re := range exprs {
+		if re.MatchString(p) {
+			return re.String(), true
+		}
+	}
+	return "", false
+}
+
+// matchAny returns the first pattern matching p
+func matchAny(patterns []string, p string) (string, bool) {
+	for _, pattern := range patterns {
+		target := path.Base(p)
+		if strings.Contains(pattern, "/") {
+			target = p
+			pattern = cleanImagePath(pattern)
+		}
+
+		if ok, _ := path.Match(pattern, target); ok {
+			return pattern, true
+		}
+	}
+
+	return "", false
+}
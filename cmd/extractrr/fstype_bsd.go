@@ -0,0 +1,25 @@
+//go:build darwin || freebsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// fsNames maps the type names of the BSDs to the ones used on Linux
+var fsNames = map[string]string{
+	"msdos":   "vfat",
+	"msdosfs": "vfat",
+	"smbfs":   "smb",
+}
+
+// fsType returns the filesystem type of dir
+func fsType(dir string) (string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return "", err
+	}
+	name := unix.ByteSliceToString(st.Fstypename[:])
+	if mapped, ok := fsNames[name]; ok {
+		return mapped, nil
+	}
+	return name, nil
+}
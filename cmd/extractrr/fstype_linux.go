@@ -0,0 +1,39 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsMagics names the statfs magic numbers of common filesystems
+var fsMagics = map[int64]string{
+	unix.MSDOS_SUPER_MAGIC: "vfat",
+	unix.EXFAT_SUPER_MAGIC: "exfat",
+	0x5346544e:             "ntfs",
+	0x7366746e:             "ntfs",
+	unix.CIFS_SUPER_MAGIC:  "smb",
+	unix.SMB2_SUPER_MAGIC:  "smb",
+	unix.SMB_SUPER_MAGIC:   "smb",
+	unix.NFS_SUPER_MAGIC:   "nfs",
+	unix.FUSE_SUPER_MAGIC:  "fuse",
+	unix.EXT4_SUPER_MAGIC:  "ext4",
+	unix.XFS_SUPER_MAGIC:   "xfs",
+	unix.BTRFS_SUPER_MAGIC: "btrfs",
+	unix.TMPFS_MAGIC:       "tmpfs",
+	0x2fc12fc1:             "zfs",
+}
+
+// fsType returns the filesystem type of dir
+func fsType(dir string) (string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return "", err
+	}
+	if name, ok := fsMagics[int64(st.Type)]; ok {
+		return name, nil
+	}
+	return fmt.Sprintf("0x%x", st.Type), nil
+}
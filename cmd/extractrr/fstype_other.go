@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "errors"
+
+// fsType is not implemented on this platform
+func fsType(dir string) (string, error) {
+	return "", errors.ErrUnsupported
+}
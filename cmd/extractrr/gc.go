@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+// PartialSuffix marks files that are still being written in atomic mode
+const PartialSuffix = ".extractrr.partial"
+
+func CommandGC() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "gc",
+		Short: "Remove stale partial files left behind by interrupted extractions",
+		Long: `Remove stale partial files left behind by interrupted extractions
+
+Walks the given directories and removes *` + PartialSuffix + ` files older than --min-age,
+reporting how much space was reclaimed.`,
+		Example: `  extractrr gc /media
+  extractrr gc /media --min-age 1h --dry-run`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return fmt.Errorf("requires at least one arg")
+			}
+			return nil
+		},
+	}
+
+	var (
+		minAge = command.Flags().Duration("min-age", 24*time.Hour, "Only remove artifacts not modified for this long, so running extractions are left alone")
+		dryRun = command.Flags().Bool("dry-run", false, "Only report what would be removed")
+	)
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		var count int
+		var reclaimed int64
+
+		for _, root := range args {
+			err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() || !strings.HasSuffix(d.Name(), PartialSuffix) {
+					return nil
+				}
+
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				if time.Since(info.ModTime()) < *minAge {
+					return nil
+				}
+
+				slog.Info("Removing stale partial file", "file", path, "bytes", info.Size(), "dry_run", *dryRun)
+				if !*dryRun {
+					if err := os.Remove(path); err != nil {
+						return err
+					}
+				}
+
+				count++
+				reclaimed += info.Size()
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		slog.Info("Garbage collection completed", "files", count, "bytes", reclaimed, "reclaimed", humanize.IBytes(uint64(reclaimed)), "dry_run", *dryRun)
+
+		return nil
+	}
+
+	return command
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func CommandHash() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "hash",
+		Short: "Print checksums of the files in an iso or an extracted directory",
+		Long: `Print checksums of the files in an iso or an extracted directory
+
+The output uses the sha256sum format with paths relative to the image root,
+so it can be checked against an extracted directory with sha256sum -c
+(or b3sum -c for blake3).
+
+With --format hashdeep it is a hashdeep file with size, md5 and sha256 of every
+file instead, which hashdeep -a -k audits a tree against. Given a directory, the
+files below it are hashed the same way, so the image and its extraction can be
+compared with either tool.`,
+		Example: `  extractrr hash /path/to/file.iso > checksums.sha256
+  extractrr hash /path/to/file.iso --algorithm blake3 > checksums.b3
+  extractrr hash /path/to/file.iso --format hashdeep > known.txt
+  extractrr hash /path/to/extract --format hashdeep > extracted.txt`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("requires one arg")
+			}
+			return nil
+		},
+	}
+
+	var (
+		numWorkers   = command.Flags().Int("workers", runtime.NumCPU(), "Number of parallel workers")
+		bufferSize   = bufferFlag(command.Flags(), "Buffer size for file reading")
+		showProgress = command.Flags().Bool("progress", false, "Show progress bar")
+		algorithm    = command.Flags().String("algorithm", ChecksumSHA256, "Checksum algorithm: "+strings.Join(checksumAlgorithms, ", "))
+		format       = command.Flags().String("format", HashFormatSum, "Output format: "+strings.Join(hashFormats, ", ")+", hashdeep always uses md5 and sha256")
+	)
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		if err := validateChecksum(*algorithm); err != nil {
+			return err
+		}
+		if err := validateHashFormat(*format); err != nil {
+			return err
+		}
+
+		out := c.OutOrStdout()
+		if *format == HashFormatHashdeep {
+			writeHashdeepHeader(out, os.Args)
+		}
+
+		if info, err := os.Stat(args[0]); err == nil && info.IsDir() {
+			return hashDirectory(c, args[0], *numWorkers, *bufferSize, *format, *algorithm)
+		}
+
+		src := &imageSource{Path: args[0]}
+		logger := slog.With("job", newJobID(), "iso", args[0])
+
+		jobs, totalSize, err := scanImageJobs(src, 0, nil)
+		if err != nil {
+			return err
+		}
+
+		results, err := runPool(logger, src, jobs, totalSize, poolOptions{Workers: *numWorkers, BufferSize: *bufferSize, ShowProgress: *showProgress}, func(ctx context.Context, image SourceBackend, job Job, buffer []byte, progressChan chan<- int64) fileResult {
+			h := newDigest(*format, *algorithm)
+			_, err := readImageFile(image, job.SrcPath, buffer, func(chunk []byte) error {
+				h.Write(chunk)
+				progressChan <- int64(len(chunk))
+				return nil
+			})
+			return fileResult{Digest: digestSum(h), Err: err}
+		})
+		if err != nil {
+			return err
+		}
+
+		for i, result := range results {
+			if result.Err == nil {
+				writeDigestLine(out, *format, jobs[i].Size, result.Digest, strings.TrimPrefix(jobs[i].SrcPath, "/"))
+			}
+		}
+
+		if failed := reportFailures(logger, jobs, results); failed > 0 {
+			return fmt.Errorf("failed to hash %d of %d files", failed, len(jobs))
+		}
+
+		return nil
+	}
+
+	return command
+}
+
+// hashDirectory prints the checksums of an extracted tree
+func hashDirectory(c *cobra.Command, root string, workers, bufferSize int, format, algorithm string) error {
+	files, err := listTree(root)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", root, err)
+	}
+
+	results, sizes := hashTree(files, workers, bufferSize, format, algorithm)
+
+	failed := 0
+	for i, result := range results {
+		if result.Err != nil {
+			slog.Error("Failed to hash file", "file", files[i].Path, "error", result.Err)
+			failed++
+			continue
+		}
+		writeDigestLine(c.OutOrStdout(), format, sizes[i], result.Digest, files[i].Rel)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to hash %d of %d files", failed, len(files))
+	}
+
+	return nil
+}
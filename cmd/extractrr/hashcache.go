@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// hashCacheName is the cache file in the extractrr user cache directory
+const hashCacheName = "hashes.json"
+
+// hashCacheEntry is what is known about a destination file as long as it keeps its size and mtime
+type hashCacheEntry struct {
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+	CRC32 string    `json:"crc32,omitempty"`
+	// Digests are hex checksums keyed by algorithm
+	Digests map[string]string `json:"digests,omitempty"`
+	// Verified lists the image entries the file was found identical to
+	Verified []string `json:"verified,omitempty"`
+}
+
+// hashCache remembers hashes and verification results of destination files keyed by
+// absolute path, so repeated verify and resume runs over a library don't re-read
+// files that haven't changed. A nil cache remembers nothing.
+type hashCache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]hashCacheEntry
+	changed map[string]bool
+}
+
+// openHashCache loads the cache from the user cache directory
+func openHashCache() (*hashCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate cache directory: %w", err)
+	}
+
+	c := &hashCache{
+		path:    filepath.Join(dir, "extractrr", hashCacheName),
+		changed: make(map[string]bool),
+	}
+	if c.entries, err = readHashCache(c.path); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func readHashCache(path string) (map[string]hashCacheEntry, error) {
+	entries := make(map[string]hashCacheEntry)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid hash cache %s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+// lookup returns the entry for path if the file still has the cached size and mtime
+func (c *hashCache) lookup(path string) (hashCacheEntry, bool) {
+	if c == nil {
+		return hashCacheEntry{}, false
+	}
+
+	key, info, err := c.stat(path)
+	if err != nil {
+		return hashCacheEntry{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.Size != info.Size() || !entry.Mtime.Equal(info.ModTime()) {
+		return hashCacheEntry{}, false
+	}
+
+	return entry, true
+}
+
+// update applies fn to the entry for path, starting over if the file has changed
+func (c *hashCache) update(path string, fn func(entry *hashCacheEntry)) {
+	if c == nil {
+		return
+	}
+
+	key, info, err := c.stat(path)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.Size != info.Size() || !entry.Mtime.Equal(info.ModTime()) {
+		entry = hashCacheEntry{Size: info.Size(), Mtime: info.ModTime()}
+	}
+	fn(&entry)
+
+	c.entries[key] = entry
+	c.changed[key] = true
+}
+
+func (c *hashCache) stat(path string) (string, os.FileInfo, error) {
+	key, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	info, err := os.Stat(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, info, nil
+}
+
+// verified reports whether path was found identical to the image entry source
+func (c *hashCache) verified(path, source string) bool {
+	entry, ok := c.lookup(path)
+	if !ok {
+		return false
+	}
+
+	for _, v := range entry.Verified {
+		if v == source {
+			return true
+		}
+	}
+
+	return false
+}
+
+// markVerified records that path is identical to the image entry source
+func (c *hashCache) markVerified(path, source string) {
+	c.update(path, func(entry *hashCacheEntry) {
+		for _, v := range entry.Verified {
+			if v == source {
+				return
+			}
+		}
+		entry.Verified = append(entry.Verified, source)
+	})
+}
+
+// save merges the changed entries into the cache file, keeping what other runs wrote meanwhile
+func (c *hashCache) save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.changed) == 0 {
+		return nil
+	}
+
+	entries, err := readHashCache(c.path)
+	if err != nil {
+		// A broken cache is only a cache, start over
+		entries = make(map[string]hashCacheEntry)
+	}
+	for key := range c.changed {
+		entries[key] = c.entries[key]
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+
+	c.changed = make(map[string]bool)
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Output formats of the hash command
+const (
+	HashFormatSum      = "sum"
+	HashFormatHashdeep = "hashdeep"
+)
+
+var hashFormats = []string{HashFormatSum, HashFormatHashdeep}
+
+func validateHashFormat(format string) error {
+	if !slices.Contains(hashFormats, format) {
+		return fmt.Errorf("invalid hash format %q: must be one of %s", format, strings.Join(hashFormats, ", "))
+	}
+	return nil
+}
+
+// hashdeepHash computes the md5 and sha256 columns of hashdeep output in one pass
+type hashdeepHash struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+}
+
+func newHashdeepHash() *hashdeepHash {
+	return &hashdeepHash{md5: md5.New(), sha256: sha256.New()}
+}
+
+func (h *hashdeepHash) Write(p []byte) (int, error) {
+	h.md5.Write(p)
+	return h.sha256.Write(p)
+}
+
+// Sum returns the md5 digest followed by the sha256 digest, the layout hashdeepLine expects
+func (h *hashdeepHash) Sum() []byte {
+	return h.sha256.Sum(h.md5.Sum(nil))
+}
+
+// newDigest returns the hash for a format and, with the sum format, an algorithm
+func newDigest(format, algorithm string) io.Writer {
+	if format == HashFormatHashdeep {
+		return newHashdeepHash()
+	}
+	return newChecksum(algorithm)
+}
+
+// digestSum returns the digest of a hash from newDigest
+func digestSum(h io.Writer) []byte {
+	if h, ok := h.(*hashdeepHash); ok {
+		return h.Sum()
+	}
+	return h.(hash.Hash).Sum(nil)
+}
+
+// writeHashdeepHeader writes the header hashdeep reads known hashes from, e.g. for hashdeep -a -k
+func writeHashdeepHeader(w io.Writer, args []string) {
+	cwd, _ := os.Getwd()
+	fmt.Fprintln(w, "%%%% HASHDEEP-1.0")
+	fmt.Fprintln(w, "%%%% size,md5,sha256,filename")
+	fmt.Fprintf(w, "## Invoked from: %s\n", cwd)
+	fmt.Fprintf(w, "## $ %s\n", strings.Join(args, " "))
+	fmt.Fprintln(w, "##")
+}
+
+// writeDigestLine prints one file in the chosen format with a path relative to the root
+func writeDigestLine(w io.Writer, format string, size int64, digest []byte, path string) {
+	if format == HashFormatHashdeep {
+		fmt.Fprintf(w, "%d,%s,%s,%s\n", size, hex.EncodeToString(digest[:md5.Size]), hex.EncodeToString(digest[md5.Size:]), path)
+		return
+	}
+	fmt.Fprintf(w, "%s  %s\n", hex.EncodeToString(digest), path)
+}
+
+// treeFile is a file of an extracted tree to hash
+type treeFile struct {
+	// Rel is the slash separated path below the root
+	Rel  string
+	Path string
+}
+
+// listTree returns the files of an extracted tree sorted by path. extractrr's own
+// sidecar and partial files are left out, split files are listed by their original
+// name so the output matches that of the image.
+func listTree(root string) ([]treeFile, error) {
+	var files []treeFile
+	parts := make(map[string]bool)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		name := d.Name()
+		switch {
+		case name == SidecarName, name == ReportName, strings.HasSuffix(name, PartialSuffix):
+			return nil
+		case strings.HasSuffix(name, SplitManifestSuffix):
+			base := strings.TrimSuffix(path, SplitManifestSuffix)
+			manifest, err := readSplitManifest(base)
+			if err != nil {
+				return err
+			}
+			for _, part := range manifest.Parts {
+				parts[filepath.Join(filepath.Dir(path), part)] = true
+			}
+			path = base
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, treeFile{Rel: filepath.ToSlash(rel), Path: path})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	files = slices.DeleteFunc(files, func(f treeFile) bool { return parts[f.Path] })
+	sort.Slice(files, func(i, j int) bool { return files[i].Rel < files[j].Rel })
+
+	return files, nil
+}
+
+// hashTree hashes the files of an extracted tree with a pool of workers
+func hashTree(files []treeFile, workers, bufferSize int, format, algorithm string) ([]fileResult, []int64) {
+	results := make([]fileResult, len(files))
+	sizes := make([]int64, len(files))
+	jobChan := make(chan int, len(files))
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			buffer := make([]byte, bufferSize)
+			for idx := range jobChan {
+				f, err := openExtracted(files[idx].Path)
+				if err != nil {
+					results[idx].Err = err
+					continue
+				}
+
+				h := newDigest(format, algorithm)
+				sizes[idx], err = io.CopyBuffer(h, f, buffer)
+				f.Close()
+				results[idx] = fileResult{Digest: digestSum(h), Err: err}
+			}
+		}()
+	}
+
+	for i := range files {
+		jobChan <- i
+	}
+	close(jobChan)
+	wg.Wait()
+
+	return results, sizes
+}
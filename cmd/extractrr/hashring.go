@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+)
+
+// hashRingBuffers is how many buffers a ring lets the copy loop run ahead of the hash
+const hashRingBuffers = 4
+
+// hashRing moves hashing off the copy loop. Chunks that have been written are
+// handed to a hashing goroutine and their buffers recycled once hashed, so reads
+// and writes only wait for the hash when every buffer of the ring is in flight.
+// slots is shared by all rings of a run and bounds how many hash concurrently.
+type hashRing struct {
+	free  chan []byte
+	full  chan []byte
+	done  chan struct{}
+	slots chan struct{}
+}
+
+func newHashRing(bufferSize int, slots chan struct{}) *hashRing {
+	r := &hashRing{
+		free:  make(chan []byte, hashRingBuffers),
+		slots: slots,
+	}
+	for i := 0; i < hashRingBuffers; i++ {
+		r.free <- make([]byte, bufferSize)
+	}
+	return r
+}
+
+// begin starts hashing a new file into w
+func (r *hashRing) begin(w io.Writer) {
+	r.full = make(chan []byte, hashRingBuffers)
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+		for buf := range r.full {
+			r.slots <- struct{}{}
+			w.Write(buf)
+			<-r.slots
+
+			r.free <- buf[:cap(buf)]
+		}
+	}()
+}
+
+// buffer returns a free buffer, waiting for the hash to catch up if there is none
+func (r *hashRing) buffer() []byte {
+	return <-r.free
+}
+
+// hash queues a filled buffer, it must not be touched until buffer returns it again
+func (r *hashRing) hash(buf []byte) {
+	r.full <- buf
+}
+
+// release returns a buffer that turned out not to be needed
+func (r *hashRing) release(buf []byte) {
+	r.free <- buf[:cap(buf)]
+}
+
+// end waits until everything queued since begin is hashed
+func (r *hashRing) end() {
+	close(r.full)
+	<-r.done
+}
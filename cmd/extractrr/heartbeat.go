@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/dustin/go-humanize"
+)
+
+// batchStatus tracks a whole batch for the periodic status line and the shared progress
+// display. The total is the size of the images, which is only an estimate of what is
+// extracted from them.
+type batchStatus struct {
+	images int
+	total  int64
+	// bars gives every job a bar for the shared display instead of its own
+	bars bool
+
+	done atomic.Int64
+
+	mu      sync.Mutex
+	running []*batchJob
+	// finished is what the jobs that ended processed
+	finished int64
+}
+
+// batchJob is the extraction of one image of a batch, nested images are jobs of their own
+type batchJob struct {
+	name      string
+	total     int64
+	processed atomic.Int64
+	// bar is drawn by the shared display, nil without one
+	bar *pb.ProgressBar
+}
+
+func newBatchStatus(images []string) *batchStatus {
+	b := &batchStatus{images: len(images)}
+	for _, image := range images {
+		f, err := openSourceFile(image)
+		if err != nil {
+			continue
+		}
+		b.total += f.Size()
+		f.Close()
+	}
+	return b
+}
+
+// imageDone counts an image as finished, whether or not it succeeded
+func (b *batchStatus) imageDone() {
+	b.done.Add(1)
+}
+
+// start registers a running job that extracts total bytes from the image name
+func (b *batchStatus) start(name string, total int64) *batchJob {
+	job := &batchJob{name: name, total: total}
+	if b.bars {
+		job.bar = pb.Full.New(0).SetTotal(total)
+		job.bar.Set(pb.Bytes, true)
+		job.bar.Set(pb.Static, true)
+		job.bar.Set("prefix", filepath.Base(name))
+		job.bar.Start()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running = append(b.running, job)
+	return job
+}
+
+// finish unregisters a job once its pool is done
+func (b *batchStatus) finish(job *batchJob) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running = slices.DeleteFunc(b.running, func(j *batchJob) bool { return j == job })
+	b.finished += job.processed.Load()
+}
+
+// processed returns the bytes processed by the whole batch so far
+func (b *batchStatus) processed() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	total := b.finished
+	for _, job := range b.running {
+		total += job.processed.Load()
+	}
+	return total
+}
+
+// jobs returns the running jobs, in the order they started
+func (b *batchStatus) jobs() []*batchJob {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.running)
+}
+
+// breakdown describes the progress of every running job for the status line
+func (b *batchStatus) breakdown() []string {
+	var lines []string
+	for _, job := range b.jobs() {
+		percent := 100.0
+		if job.total > 0 {
+			percent = min(float64(job.processed.Load())/float64(job.total)*100, 100)
+		}
+		lines = append(lines, fmt.Sprintf("%s %.0f%%", job.name, percent))
+	}
+	return lines
+}
+
+// run logs a one-line status every interval until done is closed, independent of the
+// per-image progress, so whoever watches the log of a long batch gets a heartbeat
+func (b *batchStatus) run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, lastAt := int64(0), time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			processed := b.processed()
+			speed := float64(processed-last) / now.Sub(lastAt).Seconds()
+			last, lastAt = processed, now
+
+			eta := "unknown"
+			if remaining := b.total - processed; speed > 0 && remaining > 0 {
+				eta = time.Duration(float64(remaining) / speed * float64(time.Second)).Round(time.Second).String()
+			}
+			percent := 0.0
+			if b.total > 0 {
+				percent = min(float64(processed)/float64(b.total)*100, 100)
+			}
+
+			slog.Info("Batch status", "images_done", b.done.Load(), "images", b.images, "bytes", processed, "bytes_total", b.total,
+				"progress", fmt.Sprintf("%.0f%%", percent), "speed", humanize.IBytes(uint64(speed))+"/s", "eta", eta, "running", b.breakdown())
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Results of a job as hooks see them
+const (
+	HookResultSuccess = "success"
+	HookResultFailed  = "failed"
+	HookResultSkipped = "skipped"
+)
+
+// jobOutcome is what happened to one image, filled in as its extraction goes along and
+// handed to the hooks once it is over
+type jobOutcome struct {
+	JobID       string `json:"job_id"`
+	Source      string `json:"source"`
+	Dest        string `json:"dest"`
+	VolumeLabel string `json:"volume_label,omitempty"`
+	// Bytes is what the image had to extract, including nested images
+	Bytes int64 `json:"bytes"`
+	// Files is how many files the image had to extract
+	Files    int     `json:"files"`
+	Duration float64 `json:"duration_seconds"`
+	Result   string  `json:"result"`
+	// ErrorCount is how many files failed, or 1 if the image failed before any was written
+	ErrorCount int `json:"error_count"`
+	// Errors are the distinct kinds of the file errors with counts
+	Errors []string `json:"errors,omitempty"`
+	Error  string   `json:"error,omitempty"`
+
+	skipped bool
+}
+
+// finish records how the extraction ended
+func (o *jobOutcome) finish(err error, startTime time.Time) {
+	o.Duration = time.Since(startTime).Seconds()
+	switch {
+	case err != nil:
+		o.Result = HookResultFailed
+		o.Error = err.Error()
+		o.ErrorCount = max(o.ErrorCount, 1)
+	case o.ErrorCount > 0:
+		o.Result = HookResultFailed
+	case o.skipped:
+		o.Result = HookResultSkipped
+	default:
+		o.Result = HookResultSuccess
+	}
+}
+
+// env returns the variables hooks get, contextFile is the JSON of the whole outcome
+func (o *jobOutcome) env(contextFile string) []string {
+	return []string{
+		"EXTRACTRR_JOB_ID=" + o.JobID,
+		"EXTRACTRR_SOURCE=" + o.Source,
+		"EXTRACTRR_DEST=" + o.Dest,
+		"EXTRACTRR_BYTES=" + strconv.FormatInt(o.Bytes, 10),
+		"EXTRACTRR_DURATION=" + strconv.FormatFloat(o.Duration, 'f', 3, 64),
+		"EXTRACTRR_RESULT=" + o.Result,
+		"EXTRACTRR_ERROR_COUNT=" + strconv.Itoa(o.ErrorCount),
+		"EXTRACTRR_CONTEXT=" + contextFile,
+	}
+}
+
+// hookCommand runs a hook through the shell, like a line of a script
+func hookCommand(hook string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", hook)
+	}
+	return exec.Command("/bin/sh", "-c", hook)
+}
+
+// runHooks runs every hook in turn once an image is done. The context file only exists
+// while they run. Failing hooks are logged, they never fail the extraction.
+func runHooks(logger *slog.Logger, hooks []string, outcome *jobOutcome) {
+	data, err := json.MarshalIndent(outcome, "", "  ")
+	if err != nil {
+		logger.Warn("Failed to encode hook context", "error", err)
+		return
+	}
+
+	f, err := os.CreateTemp("", "extractrr-hook-*.json")
+	if err != nil {
+		logger.Warn("Failed to write hook context", "error", err)
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		logger.Warn("Failed to write hook context", "error", err)
+		return
+	}
+
+	env := append(os.Environ(), outcome.env(f.Name())...)
+	for _, hook := range hooks {
+		start := time.Now()
+		cmd := hookCommand(hook)
+		cmd.Env = env
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			logger.Warn("Hook failed", "hook", hook, "error", fmt.Errorf("%s: %w", cmd.Path, err))
+			continue
+		}
+		logger.Debug("Hook finished", "hook", hook, "duration", time.Since(start).Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreName is the ignore file read from the destination directory
+const IgnoreName = ".extractrrignore"
+
+// ignoreRule is one line of an ignore file
+type ignoreRule struct {
+	pattern string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules are gitignore-style rules matched against in-image paths, the last matching rule wins
+type ignoreRules []ignoreRule
+
+// ignoreFiles returns the ignore files that apply to an extraction into dest, lowest
+// precedence first: the user config directory, the destination and --ignore-file
+func ignoreFiles(dest, explicit string) []string {
+	var files []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(dir, "extractrr", "ignore"))
+	}
+	if dest != "" {
+		files = append(files, filepath.Join(dest, IgnoreName))
+	}
+	if explicit != "" {
+		files = append(files, explicit)
+	}
+	return files
+}
+
+// readIgnoreFiles returns the rules of the files in order. Only the explicitly given file has to exist.
+func readIgnoreFiles(files []string, explicit string) ([]string, error) {
+	var lines []string
+	for _, file := range files {
+		f, err := os.Open(file)
+		if errors.Is(err, os.ErrNotExist) && file != explicit {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ignore file: %w", err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), " \t\r")
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ignore file %s: %w", file, err)
+		}
+	}
+
+	return lines, nil
+}
+
+// parseIgnoreRules compiles gitignore-style patterns
+func parseIgnoreRules(lines []string) (ignoreRules, error) {
+	var rules ignoreRules
+	for _, line := range lines {
+		rule := ignoreRule{pattern: line}
+
+		p := line
+		if strings.HasPrefix(p, "!") {
+			rule.negate = true
+			p = p[1:]
+		} else if strings.HasPrefix(p, `\!`) || strings.HasPrefix(p, `\#`) {
+			p = p[1:]
+		}
+		if strings.HasSuffix(p, "/") {
+			rule.dirOnly = true
+			p = strings.TrimSuffix(p, "/")
+		}
+
+		// Patterns without a slash match at any depth, all others are relative to the image root
+		prefix := "(?:.*/)?"
+		if strings.Contains(p, "/") {
+			prefix = ""
+			p = strings.TrimPrefix(p, "/")
+		}
+		if p == "" {
+			return nil, fmt.Errorf("invalid ignore rule %q", line)
+		}
+
+		expr, err := ignoreRegexp(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore rule %q: %w", line, err)
+		}
+		if rule.re, err = regexp.Compile("^" + prefix + expr + "$"); err != nil {
+			return nil, fmt.Errorf("invalid ignore rule %q: %w", line, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// ignoreRegexp translates the glob part of a rule, where ** matches across directories
+func ignoreRegexp(p string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '*':
+			if strings.HasPrefix(p[i:], "**") {
+				switch {
+				case strings.HasPrefix(p[i:], "**/"):
+					b.WriteString("(?:.*/)?")
+					i += 2
+				default:
+					b.WriteString(".*")
+					i++
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(p[i+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated character class")
+			}
+			class := p[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(p) {
+				i++
+				b.WriteString(regexp.QuoteMeta(p[i : i+1]))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
+
+// ignored returns the rule that ignores the entry at p, if any
+func (r ignoreRules) ignored(p string, isDir bool) (string, bool) {
+	rel := strings.TrimPrefix(p, "/")
+
+	pattern, ignored := "", false
+	for _, rule := range r {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			pattern, ignored = rule.pattern, !rule.negate
+		}
+	}
+
+	return pattern, ignored
+}
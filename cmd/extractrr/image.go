@@ -0,0 +1,272 @@
+package main
+
+/*
+#include <stdlib.h>
+#include <udfread/udfread.h>
+#include <udfread/blockinput.h>
+
+// file_block_input exposes a file inside an opened image as block input,
+// so an image stored inside another image can be read without copying it out first.
+typedef struct {
+	udfread_block_input input;
+	UDFFILE *file;
+} file_block_input;
+
+static int file_block_input_close(udfread_block_input *p) {
+	file_block_input *in = (file_block_input *)p;
+	udfread_file_close(in->file);
+	free(in);
+	return 0;
+}
+
+static uint32_t file_block_input_size(udfread_block_input *p) {
+	file_block_input *in = (file_block_input *)p;
+	int64_t size = udfread_file_size(in->file);
+	return size < 0 ? 0 : (uint32_t)(size / UDF_BLOCK_SIZE);
+}
+
+static int file_block_input_read(udfread_block_input *p, uint32_t lba, void *buf, uint32_t nblocks, int flags) {
+	file_block_input *in = (file_block_input *)p;
+	return (int)udfread_file_read_blocks(in->file, buf, lba, nblocks, flags);
+}
+
+static udfread_block_input *file_block_input_new(UDFFILE *file) {
+	file_block_input *in = calloc(1, sizeof(*in));
+	if (!in) {
+		return NULL;
+	}
+	in->input.close = file_block_input_close;
+	in->input.size = file_block_input_size;
+	in->input.read = file_block_input_read;
+	in->file = file;
+	return &in->input;
+}
+
+// remote_block_input forwards reads to a Go remoteBlockInput through a cgo handle
+extern int goRemoteRead(uintptr_t handle, uint32_t lba, void *buf, uint32_t nblocks);
+extern uint32_t goRemoteSize(uintptr_t handle);
+extern void goRemoteClose(uintptr_t handle);
+
+typedef struct {
+	udfread_block_input input;
+	uintptr_t handle;
+} remote_block_input;
+
+static int remote_block_input_close(udfread_block_input *p) {
+	remote_block_input *in = (remote_block_input *)p;
+	goRemoteClose(in->handle);
+	free(in);
+	return 0;
+}
+
+static uint32_t remote_block_input_size(udfread_block_input *p) {
+	return goRemoteSize(((remote_block_input *)p)->handle);
+}
+
+static int remote_block_input_read(udfread_block_input *p, uint32_t lba, void *buf, uint32_t nblocks, int flags) {
+	return goRemoteRead(((remote_block_input *)p)->handle, lba, buf, nblocks);
+}
+
+static udfread_block_input *remote_block_input_new(uintptr_t handle) {
+	remote_block_input *in = calloc(1, sizeof(*in));
+	if (!in) {
+		return NULL;
+	}
+	in->input.close = remote_block_input_close;
+	in->input.size = remote_block_input_size;
+	in->input.read = remote_block_input_read;
+	in->handle = handle;
+	return &in->input;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime/cgo"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// imageSource describes where an image is read from: a file on disk or an
+// http(s) URL, or when Parent is set a file stored inside the parent image
+type imageSource struct {
+	Path   string
+	Parent *imageSource
+}
+
+func (s *imageSource) String() string {
+	if s.Parent == nil {
+		return s.Path
+	}
+	return s.Parent.String() + ":" + s.Path
+}
+
+// imageHandle is an opened image. Closing it also closes the parent
+// images it was read through.
+type imageHandle struct {
+	udf    *C.udfread
+	parent *imageHandle
+}
+
+// openImage opens src and, for inner images, every image it is nested in
+func openImage(src *imageSource) (*imageHandle, error) {
+	var parent *imageHandle
+	if src.Parent != nil {
+		var err error
+		parent, err = openImage(src.Parent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	udf := C.udfread_init()
+	if udf == nil {
+		parent.close()
+		return nil, fmt.Errorf("failed to initialize UDF reader")
+	}
+
+	cPath := C.CString(src.Path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	// Local images are read by libudfread itself unless block reads are traced. Devices
+	// are read through Go too, libudfread takes their size from stat which has none.
+	if parent == nil && (isRemotePath(src.Path) || ioTrace != nil || isDeviceSource(src.Path)) {
+		reader, err := openSourceFile(src.Path)
+		if err != nil {
+			C.udfread_close(udf)
+			return nil, err
+		}
+
+		handle := cgo.NewHandle(&remoteBlockInput{reader: reader, name: src.Path})
+		input := C.remote_block_input_new(C.uintptr_t(handle))
+		if input == nil {
+			handle.Delete()
+			C.udfread_close(udf)
+			return nil, fmt.Errorf("failed to allocate block input for %s", src)
+		}
+
+		if C.udfread_open_input(udf, input) != 0 {
+			C.remote_block_input_close(input)
+			C.udfread_close(udf)
+			return nil, fmt.Errorf("failed to open ISO file: %s", src.Path)
+		}
+
+		return &imageHandle{udf: udf}, nil
+	}
+
+	if parent == nil {
+		if C.udfread_open(udf, cPath) != 0 {
+			C.udfread_close(udf)
+			return nil, fmt.Errorf("failed to open ISO file: %s", src.Path)
+		}
+
+		return &imageHandle{udf: udf}, nil
+	}
+
+	file := C.udfread_file_open(parent.udf, cPath)
+	if file == nil {
+		C.udfread_close(udf)
+		parent.close()
+		return nil, fmt.Errorf("failed to open inner image: %s", src)
+	}
+
+	input := C.file_block_input_new(file)
+	if input == nil {
+		C.udfread_file_close(file)
+		C.udfread_close(udf)
+		parent.close()
+		return nil, fmt.Errorf("failed to allocate block input for %s", src)
+	}
+
+	// On success the reader owns the input and closes the inner file with it,
+	// on failure it is left to us like udfread_open does with its own input
+	if C.udfread_open_input(udf, input) != 0 {
+		C.file_block_input_close(input)
+		C.udfread_close(udf)
+		parent.close()
+		return nil, fmt.Errorf("failed to open inner image: %s", src)
+	}
+
+	return &imageHandle{udf: udf, parent: parent}, nil
+}
+
+func (h *imageHandle) close() {
+	if h == nil {
+		return
+	}
+
+	C.udfread_close(h.udf)
+	h.parent.close()
+}
+
+// isImageFile reports whether an in-image path looks like a nested image
+func isImageFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".iso")
+}
+
+// sourceFile is the raw image a top-level source is read from
+type sourceFile interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+	ModTime() time.Time
+}
+
+type localFile struct {
+	*os.File
+	info os.FileInfo
+	size int64
+}
+
+func (f *localFile) Size() int64        { return f.size }
+func (f *localFile) ModTime() time.Time { return f.info.ModTime() }
+
+// openSourceFile opens the raw image at path, which may be a URL, a block device or
+// the directory a disc is mounted at
+func openSourceFile(path string) (sourceFile, error) {
+	if isRemotePath(path) {
+		return openRemote(path)
+	}
+	if device, ok := sourceDevice(path); ok {
+		path = device
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Devices have no size in stat, it is where seeking to the end ends up
+	size := info.Size()
+	if info.Mode()&os.ModeDevice != 0 {
+		if size, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to get size of %s: %w", path, err)
+		}
+	}
+
+	return &localFile{File: f, info: info, size: size}, nil
+}
+
+// imageBaseName returns the file name of an image path, ignoring the query of URLs
+func imageBaseName(p string) string {
+	if isRemotePath(p) {
+		if u, err := url.Parse(p); err == nil {
+			return path.Base(u.Path)
+		}
+	}
+	return filepath.Base(p)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// jobsName is the job definitions file in the extractrr user config directory
+const jobsName = "jobs.json"
+
+// errNoJobs is returned when there is no jobs file
+var errNoJobs = errors.New("no jobs defined")
+
+// jobDefinition is a named extraction from the jobs file. Flags holds extract flags by
+// name without the dashes, lists are passed as a repeated flag.
+type jobDefinition struct {
+	Source      string         `json:"source"`
+	Destination string         `json:"destination"`
+	Flags       map[string]any `json:"flags,omitempty"`
+}
+
+// args returns the extract command line of the job
+func (j jobDefinition) args() ([]string, error) {
+	args := []string{j.Source, j.Destination}
+
+	names := make([]string, 0, len(j.Flags))
+	for name := range j.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		values, ok := j.Flags[name].([]any)
+		if !ok {
+			values = []any{j.Flags[name]}
+		}
+		for _, value := range values {
+			var s string
+			switch v := value.(type) {
+			case string:
+				s = v
+			case bool:
+				s = strconv.FormatBool(v)
+			case float64:
+				s = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				return nil, fmt.Errorf("flag %s: unsupported value %v", name, value)
+			}
+			args = append(args, "--"+name+"="+s)
+		}
+	}
+
+	return args, nil
+}
+
+// readJobs loads the job definitions from path, or from the user config directory when path is empty
+func readJobs(path string) (map[string]jobDefinition, error) {
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate config directory: %w", err)
+		}
+		path = filepath.Join(dir, "extractrr", jobsName)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w, %s does not exist", errNoJobs, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs map[string]jobDefinition
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	for name, job := range jobs {
+		if job.Source == "" || job.Destination == "" {
+			return nil, fmt.Errorf("invalid %s: job %s needs a source and a destination", path, name)
+		}
+	}
+
+	return jobs, nil
+}
+
+// jobNames returns the names of the defined jobs in order
+func jobNames(jobs map[string]jobDefinition) []string {
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func CommandRun() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "run",
+		Short: "Run an extraction defined in the jobs file by name",
+		Long: `Run an extraction defined in the jobs file by name
+
+Jobs are read from extractrr/` + jobsName + ` in the user config directory. Each has a source
+pattern, a destination and the extract flags to use, so recurring extractions don't need a
+shell script.`,
+		Example: `  extractrr run nightly-archive
+  extractrr run --list`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			list, _ := cmd.Flags().GetBool("list")
+			if len(args) != 1 && !list {
+				return fmt.Errorf("requires a job name")
+			}
+			return nil
+		},
+	}
+
+	var (
+		jobsPath = command.Flags().String("jobs", "", "Job definitions file (default: "+jobsName+" in the user config directory)")
+		list     = command.Flags().Bool("list", false, "List the defined jobs and exit")
+	)
+
+	command.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		jobs, err := readJobs(*jobsPath)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return jobNames(jobs), cobra.ShellCompDirectiveNoFileComp
+	}
+
+	command.RunE = func(cmd *cobra.Command, args []string) error {
+		jobs, err := readJobs(*jobsPath)
+		if err != nil {
+			return err
+		}
+
+		if *list {
+			for _, name := range jobNames(jobs) {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: %s -> %s\n", name, jobs[name].Source, jobs[name].Destination)
+			}
+			return nil
+		}
+
+		job, ok := jobs[args[0]]
+		if !ok {
+			return fmt.Errorf("unknown job %q", args[0])
+		}
+		extractArgs, err := job.args()
+		if err != nil {
+			return fmt.Errorf("invalid job %s: %w", args[0], err)
+		}
+
+		slog.Info("Running job", "name", args[0], "source", job.Source, "dest", job.Destination)
+
+		// The job runs as the extract command would, the root command reports its error
+		extract := CommandExtract()
+		extract.SetArgs(extractArgs)
+		extract.SilenceUsage = true
+		extract.SilenceErrors = true
+		return extract.Execute()
+	}
+
+	return command
+}
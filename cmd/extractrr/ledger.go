@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ledgerName is the ledger file in the extractrr user config directory
+const ledgerName = "processed.jsonl"
+
+// ledgerEntry records one successful extraction
+type ledgerEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Hash is the fingerprint describeSource computes, as in the sidecar
+	Hash        string    `json:"hash"`
+	Dest        string    `json:"dest"`
+	ExtractedAt time.Time `json:"extracted_at"`
+	// Bytes is what the extraction wrote, entries from before it was recorded have only Size
+	Bytes int64 `json:"bytes,omitempty"`
+}
+
+// written returns what the extraction wrote, the image size for older entries
+func (e ledgerEntry) written() int64 {
+	if e.Bytes > 0 {
+		return e.Bytes
+	}
+	return e.Size
+}
+
+// imageLedger remembers which images were extracted successfully. Images are recognized
+// by size and fingerprint, so a renamed image or a destination moved by downstream
+// tooling doesn't make it look new. The file is append only, so concurrent runs don't
+// lose each other's entries.
+type imageLedger struct {
+	path string
+
+	mu        sync.Mutex
+	processed map[string]ledgerEntry
+	// dests holds the latest extraction into every destination, keyed by its absolute path
+	dests map[string]ledgerEntry
+}
+
+// ledgerKey identifies an image independent of where it is stored
+func ledgerKey(size int64, hash string) string {
+	return fmt.Sprintf("%d:%s", size, hash)
+}
+
+// openLedger reads the ledger at path, or from the user config directory when path is empty
+func openLedger(path string) (*imageLedger, error) {
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate config directory: %w", err)
+		}
+		path = filepath.Join(dir, "extractrr", ledgerName)
+	}
+
+	l := &imageLedger{path: path, processed: make(map[string]ledgerEntry), dests: make(map[string]ledgerEntry)}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var entry ledgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid ledger %s line %d: %w", path, line, err)
+		}
+		l.add(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ledger %s: %w", path, err)
+	}
+
+	return l, nil
+}
+
+// add indexes an entry, later entries replace earlier ones of the same image or destination
+func (l *imageLedger) add(entry ledgerEntry) {
+	l.processed[ledgerKey(entry.Size, entry.Hash)] = entry
+	if dest, err := filepath.Abs(entry.Dest); err == nil {
+		l.dests[dest] = entry
+	}
+}
+
+// usedBytes returns what the recorded extractions wrote below root, counting only the
+// latest one into each destination since extracting there again replaces it
+func (l *imageLedger) usedBytes(root string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var used int64
+	for dest, entry := range l.dests {
+		if withinRoot(root, dest) {
+			used += entry.written()
+		}
+	}
+	return used
+}
+
+// destBytes returns what the latest recorded extraction into dest wrote
+func (l *imageLedger) destBytes(dest string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dests[dest].written()
+}
+
+// lookup returns the entry of an earlier successful extraction of source
+func (l *imageLedger) lookup(source SidecarSource) (ledgerEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.processed[ledgerKey(source.Size, source.Hash)]
+	return entry, ok
+}
+
+// record appends a successful extraction of source into dest that wrote bytes. The
+// destination is stored absolute, whoever loads the ledger may run from another directory.
+func (l *imageLedger) record(source SidecarSource, dest string, bytes int64) error {
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := ledgerEntry{Path: source.Path, Size: source.Size, Hash: source.Hash, Dest: dest, ExtractedAt: time.Now(), Bytes: bytes}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	l.add(entry)
+
+	return nil
+}
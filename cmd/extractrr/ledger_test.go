@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLedgerRecordsAbsoluteDestinations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ledgerName)
+	first, second := t.TempDir(), t.TempDir()
+
+	t.Chdir(first)
+	l, err := openLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := SidecarSource{Path: "/isos/a.iso", Size: 100, Hash: "sha256:aa"}
+	if err := l.record(source, "out", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading from another directory must not resolve the destination against it
+	t.Chdir(second)
+	l, err = openLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := filepath.Join(first, "out")
+	entry, ok := l.lookup(source)
+	if !ok || entry.Dest != want {
+		t.Fatalf("got dest %q, want %q", entry.Dest, want)
+	}
+	if got := l.destBytes(want); got != 42 {
+		t.Fatalf("got %d bytes for %s, want 42", got, want)
+	}
+	if got := l.usedBytes(second); got != 0 {
+		t.Fatalf("got %d bytes used below %s, want 0", got, second)
+	}
+}
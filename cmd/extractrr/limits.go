@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Defaults for the limits on nested images
+const (
+	defaultMaxImageDepth = 4
+	defaultMaxExpansion  = 2.0
+)
+
+// checkLimits refuses scans larger than --max-files or --max-total-size, protecting
+// automation from runaway inputs. With --force exceeding a limit is only logged.
+func checkLimits(opts ExtractOptions, files int, totalSize int64) error {
+	var err error
+	switch {
+	case opts.MaxFiles > 0 && files > opts.MaxFiles:
+		err = fmt.Errorf("image has %d files to extract, more than --max-files %d", files, opts.MaxFiles)
+	case opts.MaxTotalSize > 0 && totalSize > opts.MaxTotalSize:
+		err = fmt.Errorf("image has %s to extract, more than --max-total-size %s", humanize.IBytes(uint64(totalSize)), humanize.IBytes(uint64(opts.MaxTotalSize)))
+	}
+
+	if err != nil && opts.Force {
+		opts.log().Warn("Ignoring safety limit because of --force", "error", err)
+		return nil
+	}
+
+	return err
+}
+
+// checkInnerImage guards --recurse-images against crafted or broken images: too deep
+// nesting, files adding up to far more than the image holds (UDF allows several files
+// to share the same blocks) and nested output pushing the run past --max-total-size.
+func checkInnerImage(src *imageSource, imageSize, scanSize int64, opts ExtractOptions) error {
+	depth := 0
+	for p := src.Parent; p != nil; p = p.Parent {
+		depth++
+	}
+
+	var err error
+	switch {
+	case opts.MaxImageDepth > 0 && depth > opts.MaxImageDepth:
+		err = fmt.Errorf("image is nested %d levels deep, more than --max-image-depth %d", depth, opts.MaxImageDepth)
+	case opts.MaxExpansion > 0 && float64(scanSize) > opts.MaxExpansion*float64(imageSize):
+		err = fmt.Errorf("image of %s expands to %s, more than --max-expansion %g", humanize.IBytes(uint64(imageSize)), humanize.IBytes(uint64(scanSize)), opts.MaxExpansion)
+	case opts.planned != nil && opts.MaxTotalSize > 0 && *opts.planned+scanSize > opts.MaxTotalSize:
+		err = fmt.Errorf("nested images bring the extraction to %s, more than --max-total-size %s", humanize.IBytes(uint64(*opts.planned+scanSize)), humanize.IBytes(uint64(opts.MaxTotalSize)))
+	}
+
+	if err != nil && opts.Force {
+		opts.log().Warn("Ignoring safety limit because of --force", "image", src.String(), "error", err)
+		err = nil
+	}
+	if err == nil && opts.planned != nil {
+		*opts.planned += scanSize
+	}
+
+	return err
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"runtime"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+// Sort orders of the list command
+const (
+	ListSortNone = "none"
+	ListSortName = "name"
+	ListSortSize = "size"
+)
+
+// listEntry is an entry collected for sorted output
+type listEntry struct {
+	path  string
+	entry dirEntry
+	size  int64
+}
+
+func CommandList() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "list",
+		Short: "List the contents of an iso",
+		Long: `List the contents of an iso
+
+With --long every entry is printed with its type (d for directories, - for files,
+? for anything else), size in bytes and the first logical block it is stored at.
+libudfread exposes neither timestamps nor permissions, so these are not shown.
+
+With --dedupe every argument is an image or a glob of images. Files that appear in
+more than one of them, by size and content, are listed with every image they are in,
+followed by how much of each image is also found in the others.`,
+		Example: `  extractrr list /path/to/file.iso
+  extractrr list /path/to/file.iso /BDMV/STREAM
+  extractrr list -l /path/to/file.iso
+  extractrr list -l --sort size --reverse /path/to/file.iso | head -20
+  extractrr list --dedupe "/path/to/*.iso"`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return fmt.Errorf("requires at least one arg")
+			}
+			return nil
+		},
+		ValidArgsFunction: completeImagePathArgs,
+	}
+
+	var (
+		long         = command.Flags().BoolP("long", "l", false, "Show type, size and starting block of every entry")
+		sortBy       = command.Flags().String("sort", ListSortNone, "Sort entries by "+ListSortName+" or "+ListSortSize+" instead of image order")
+		reverse      = command.Flags().BoolP("reverse", "r", false, "Reverse the sort order")
+		include      = command.Flags().StringArray("include", nil, "Only list files matching this glob pattern (can be repeated)")
+		exclude      = command.Flags().StringArray("exclude", nil, "Skip files and directories matching this glob pattern (can be repeated)")
+		includeRegex = command.Flags().StringArray("include-regex", nil, "Only list files whose full path matches this regular expression (can be repeated)")
+		excludeRegex = command.Flags().StringArray("exclude-regex", nil, "Skip files and directories whose full path matches this regular expression (can be repeated)")
+		dedupe       = command.Flags().Bool("dedupe", false, "Treat every argument as an image and report the files found in more than one of them")
+		numWorkers   = command.Flags().Int("workers", runtime.NumCPU(), "With --dedupe, number of parallel workers hashing files")
+		bufferSize   = bufferFlag(command.Flags(), "With --dedupe, buffer size for file reading")
+	)
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		if *dedupe {
+			return listDuplicates(c.OutOrStdout(), args, *numWorkers, *bufferSize)
+		}
+
+		switch *sortBy {
+		case ListSortNone, ListSortName, ListSortSize:
+		default:
+			return fmt.Errorf("invalid sort %q: must be one of %s, %s or %s (libudfread exposes no timestamps)", *sortBy, ListSortNone, ListSortName, ListSortSize)
+		}
+
+		filter, err := newPathFilter(filterOptions{Include: *include, Exclude: *exclude, IncludeRegex: *includeRegex, ExcludeRegex: *excludeRegex})
+		if err != nil {
+			return err
+		}
+
+		image, err := openBackend(&imageSource{Path: args[0]})
+		if err != nil {
+			return err
+		}
+		defer image.Close()
+
+		roots := args[1:]
+		if len(roots) == 0 {
+			roots = []string{"/"}
+		}
+
+		w := bufio.NewWriter(c.OutOrStdout())
+		defer w.Flush()
+
+		print := func(p string, entry dirEntry) error {
+			if entry.IsDir {
+				p += "/"
+			}
+			if *long {
+				return printLongEntry(w, image, p, entry)
+			}
+			_, err := fmt.Fprintln(w, p)
+			return err
+		}
+
+		// Without sorting entries are printed while walking
+		var entries []listEntry
+		for _, root := range roots {
+			err := walkImage(image, cleanImagePath(root), func(p string, entry dirEntry) error {
+				if filter.skipReason(p, entry.IsDir) != "" {
+					if entry.IsDir {
+						return fs.SkipDir
+					}
+					return nil
+				}
+				// Directories only get in the way when looking for files by pattern
+				if entry.IsDir && (len(*include) > 0 || len(*includeRegex) > 0) {
+					return nil
+				}
+
+				if *sortBy == ListSortNone {
+					return print(p, entry)
+				}
+
+				e := listEntry{path: p, entry: entry}
+				if *sortBy == ListSortSize && entry.IsRegular {
+					if e.size, err = getFileSize(image, p); err != nil {
+						return err
+					}
+				}
+				entries = append(entries, e)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		sort.SliceStable(entries, func(i, j int) bool {
+			a, b := entries[i], entries[j]
+			if *reverse {
+				a, b = b, a
+			}
+			if *sortBy == ListSortSize && a.size != b.size {
+				return a.size < b.size
+			}
+			return a.path < b.path
+		})
+		for _, e := range entries {
+			if err := print(e.path, e.entry); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return command
+}
+
+func CommandCat() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "cat",
+		Short: "Write files from an iso to stdout",
+		Long: `Write files from an iso to stdout.
+
+Paths may be glob patterns, e.g. "/BDMV/STREAM/0000[1-3].m2ts", every match is written in order.`,
+		Example: `  extractrr cat /path/to/file.iso /BDMV/index.bdmv | xxd | head
+  extractrr cat /path/to/file.iso "/BDMV/PLAYLIST/*.mpls" > playlists.bin`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 2 {
+				return fmt.Errorf("requires at least two args")
+			}
+			return nil
+		},
+		ValidArgsFunction: completeImagePathArgs,
+	}
+
+	var (
+		bufferSize = bufferFlag(command.Flags(), "Buffer size for file reading")
+	)
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		image, err := openBackend(&imageSource{Path: args[0]})
+		if err != nil {
+			return err
+		}
+		defer image.Close()
+
+		out := c.OutOrStdout()
+		buffer := make([]byte, alignBufferSize(*bufferSize))
+
+		for _, arg := range args[1:] {
+			paths, err := globImage(image, arg)
+			if err != nil {
+				return err
+			}
+
+			for _, p := range paths {
+				_, err := readImageFile(image, p, buffer, func(chunk []byte) error {
+					_, err := out.Write(chunk)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return command
+}
+
+// globImage returns the files in the image matching pattern, or the path itself if it
+// contains no glob characters
+func globImage(image SourceBackend, pattern string) ([]string, error) {
+	pattern = cleanImagePath(pattern)
+	if !hasGlob(pattern) {
+		return []string{pattern}, nil
+	}
+
+	filter, err := newPathFilter(filterOptions{Paths: []string{pattern}})
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	err = walkImage(image, "/", func(p string, entry dirEntry) error {
+		if entry.IsDir {
+			if !filter.descend(p) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsRegular && filter.selected(p) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files in the image match %q", pattern)
+	}
+
+	return matches, nil
+}
+
+// walkImage calls fn for every entry below root in depth-first order.
+// Returning fs.SkipDir for a directory skips its contents.
+func walkImage(image SourceBackend, root string, fn func(p string, entry dirEntry) error) error {
+	entries, err := readDir(image, root)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		p := path.Join(root, entry.Name)
+		if err := fn(p, entry); err != nil {
+			if err == fs.SkipDir && entry.IsDir {
+				continue
+			}
+			return err
+		}
+
+		if entry.IsDir {
+			if err := walkImage(image, p, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// printLongEntry prints an entry like ls -l does, limited to what UDF metadata libudfread exposes
+func printLongEntry(w io.Writer, image SourceBackend, p string, entry dirEntry) error {
+	if !entry.IsRegular {
+		kind := "?"
+		if entry.IsDir {
+			kind = "d"
+		}
+		_, err := fmt.Fprintf(w, "%s %14s %10s  %s\n", kind, "-", "-", p)
+		return err
+	}
+
+	info, err := image.Stat(p)
+	if err != nil {
+		return err
+	}
+	lba := "-"
+	if info.Block >= 0 {
+		lba = fmt.Sprint(info.Block)
+	}
+
+	_, err = fmt.Fprintf(w, "- %14d %10s  %s\n", info.Size, lba, p)
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Log output formats
+const (
+	LogFormatConsole = "console"
+	LogFormatJSON    = "json"
+	LogFormatLogfmt  = "logfmt"
+)
+
+// setupLogging installs the default logger for the selected format. The console
+// format keeps the standard library log output used for interactive runs.
+func setupLogging(format string) error {
+	switch format {
+	case LogFormatConsole:
+		return nil
+	case LogFormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	case LogFormatLogfmt:
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	default:
+		return fmt.Errorf("invalid log format %q: must be one of %s, %s or %s", format, LogFormatConsole, LogFormatJSON, LogFormatLogfmt)
+	}
+
+	return nil
+}
+
+// newJobID returns a short random id used to correlate the log lines of one image
+func newJobID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/blang/semver"
-	"github.com/cheggaaa/pb/v3"
 	"github.com/creativeprojects/go-selfupdate"
 	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
@@ -23,7 +31,6 @@ import (
 #include <udfread/udfread.h>
 */
 import "C"
-import "unsafe"
 
 var (
 	version = "dev"
@@ -36,9 +43,155 @@ type Job struct {
 	SrcPath string
 	DstPath string
 	Size    int64
+	// Image is set for nested images that are extracted into DstPath instead of copied
+	Image bool
+	// Split is set for files written as numbered parts because the destination can't hold them
+	Split bool
+	// Block is where the file starts in the image, -1 if unknown
+	Block int64
+}
+
+// ExtractOptions holds the settings shared by every extraction in a run
+type ExtractOptions struct {
+	Workers        int                    `json:"workers"`
+	HashWorkers    int                    `json:"hash_workers,omitempty"`
+	BufferSize     int                    `json:"buffer_size"`
+	LargeFileSize  int64                  `json:"large_file_size,omitempty"`
+	SmallStrategy  *copyStrategy          `json:"small_strategy,omitempty"`
+	LargeStrategy  *copyStrategy          `json:"large_strategy,omitempty"`
+	ShowProgress   bool                   `json:"-"`
+	SFV            string                 `json:"sfv"`
+	Merge          string                 `json:"merge"`
+	Strip          int                    `json:"strip_components"`
+	Sidecar        bool                   `json:"sidecar"`
+	Checksum       string                 `json:"checksum,omitempty"`
+	Recurse        bool                   `json:"recurse_images"`
+	Paths          []string               `json:"paths,omitempty"`
+	Include        []string               `json:"include,omitempty"`
+	Exclude        []string               `json:"exclude,omitempty"`
+	IncludeRegex   []string               `json:"include_regex,omitempty"`
+	ExcludeRegex   []string               `json:"exclude_regex,omitempty"`
+	Ignore         []string               `json:"ignore,omitempty"`
+	Atomic         bool                   `json:"atomic"`
+	StagingDir     string                 `json:"staging_dir,omitempty"`
+	StallThreshold time.Duration          `json:"stall_threshold,omitempty"`
+	DirWriters     int                    `json:"dir_writers,omitempty"`
+	StuckAfter     time.Duration          `json:"stuck_after,omitempty"`
+	StuckAction    string                 `json:"stuck_action,omitempty"`
+	HungAfter      time.Duration          `json:"hung_after,omitempty"`
+	MinFree        int64                  `json:"min_free,omitempty"`
+	SpaceTimeout   time.Duration          `json:"space_timeout,omitempty"`
+	MaxFiles       int                    `json:"max_files,omitempty"`
+	MaxTotalSize   int64                  `json:"max_total_size,omitempty"`
+	MaxImageDepth  int                    `json:"max_image_depth,omitempty"`
+	MaxExpansion   float64                `json:"max_expansion,omitempty"`
+	FSCheck        string                 `json:"fs_check,omitempty"`
+	Duplicates     string                 `json:"duplicates,omitempty"`
+	SplitOver4G    bool                   `json:"split_over_4g,omitempty"`
+	PrecreateDirs  bool                   `json:"precreate_dirs,omitempty"`
+	Skeleton       int64                  `json:"skeleton,omitempty"`
+	ErrorPolicies  map[string]errorPolicy `json:"error_policies,omitempty"`
+	AutoWorkers    bool                   `json:"auto_workers,omitempty"`
+	Special        string                 `json:"special,omitempty"`
+	SSIF           string                 `json:"ssif,omitempty"`
+	DetectTypes    bool                   `json:"detect_types,omitempty"`
+	Force          bool                   `json:"-"`
+	SkipProcessed  bool                   `json:"-"`
+	DryRun         bool                   `json:"-"`
+	Tree           bool                   `json:"-"`
+	DestTemplate   *template.Template     `json:"-"`
+
+	logger *slog.Logger
+	// planned is the output size of the run so far, shared with nested images
+	planned *int64
+	// space watches free space of the destination
+	space *spaceGuard
+	// ledger records successfully extracted images, nil if it couldn't be opened
+	ledger *imageLedger
+	// quota limits what may be written below a destination root, nil without --quota
+	quota *destQuota
+	// root is the destination of the current image, workers create directories through it
+	root *destRoot
+	// batch counts the progress of a whole batch for --heartbeat and the shared progress
+	// display, nil for a single image
+	batch *batchStatus
+	// reportKey signs the report of every extraction, nil unless --report-key is given
+	reportKey []byte
+	// audit gets a line for every extracted file, nil unless --audit-log is given
+	audit *auditLog
+	// jobID identifies the current image in the log and the audit log
+	jobID string
+	// hooks run after every image with its outcome
+	hooks []string
+	// routes pick the destination of an image by its name, label and size, in order
+	routes []route
+}
+
+// log returns the logger for the current job
+func (o ExtractOptions) log() *slog.Logger {
+	if o.logger == nil {
+		return slog.Default()
+	}
+	return o.logger
+}
+
+// checksum returns the manifest checksum algorithm, sidecars from before it
+// was configurable always used crc32
+func (o ExtractOptions) checksum() string {
+	if o.Checksum == "" {
+		return ChecksumCRC32
+	}
+	return o.Checksum
+}
+
+// special returns how special entries are handled, sidecars from before it was
+// configurable skipped them
+func (o ExtractOptions) special() string {
+	if o.Special == "" {
+		return SpecialSkip
+	}
+	return o.Special
+}
+
+// ssif returns what happens to the interleaved 3D streams, sidecars from before it was
+// configurable kept them
+func (o ExtractOptions) ssif() string {
+	if o.SSIF == "" {
+		return SSIFKeep
+	}
+	return o.SSIF
+}
+
+// filterOptions returns the selection options of an extraction
+func (o ExtractOptions) filterOptions() filterOptions {
+	return filterOptions{
+		Paths:        o.Paths,
+		Include:      o.Include,
+		Exclude:      o.Exclude,
+		IncludeRegex: o.IncludeRegex,
+		ExcludeRegex: o.ExcludeRegex,
+		Ignore:       o.Ignore,
+		MaxSize:      o.Skeleton,
+	}
+}
+
+// fileResult is the outcome of extracting a single job
+type fileResult struct {
+	CRC32  uint32
+	HasCRC bool
+	Digest []byte
+	// Report is the entry of the file in the signed report, Path is left to the report
+	Report *ReportFile
+	// Duration is how long the job took
+	Duration time.Duration
+	// Type is the detected content type with --detect-types
+	Type string
+	Err  error
 }
 
 func main() {
+	setupConsole()
+
 	var rootCmd = &cobra.Command{
 		Use:   "extractrr",
 		Short: "extract iso to directory",
@@ -47,7 +200,53 @@ func main() {
 Documentation is available at https://github.com/autobrr/extractrr`,
 	}
 
+	var (
+		logFormat = rootCmd.PersistentFlags().String("log-format", LogFormatConsole, "Log output format: console, json or logfmt")
+		cacheDir  = rootCmd.PersistentFlags().String("cache-dir", "", "Block cache directory for http(s) sources (default: user cache dir)")
+		cacheSize = rootCmd.PersistentFlags().String("cache-size", "1GiB", "Maximum size of the block cache for http(s) sources, 0 disables it")
+		retries   = rootCmd.PersistentFlags().Int("http-retries", remoteRetries, "Retry a failed read of an http(s) source this often in a row, resuming where it stopped")
+		debugIO   = rootCmd.PersistentFlags().Bool("debug-io", false, "Log every image open and read with offsets, sizes, durations and return codes")
+		lang      = rootCmd.PersistentFlags().String("lang", "", "Language of notifications and messages: en, de or fr (default: from LANG)")
+		debugFile = rootCmd.PersistentFlags().String("debug-io-file", "", "Write the --debug-io trace as JSON lines to this file instead of the log")
+	)
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		size, err := humanize.ParseBytes(*cacheSize)
+		if err != nil {
+			return fmt.Errorf("invalid cache size: %w", err)
+		}
+		remoteCacheDir = *cacheDir
+		remoteCacheSize = int64(size)
+		if *retries < 0 {
+			return fmt.Errorf("--http-retries must not be negative")
+		}
+		remoteRetries = *retries
+
+		if err := setupLogging(*logFormat); err != nil {
+			return err
+		}
+
+		if err := setupMessages(*lang); err != nil {
+			return err
+		}
+
+		return setupIOTrace(*debugIO, *debugFile)
+	}
+
 	rootCmd.AddCommand(CommandExtract())
+	rootCmd.AddCommand(CommandResume())
+	rootCmd.AddCommand(CommandVerify())
+	rootCmd.AddCommand(CommandAudit())
+	rootCmd.AddCommand(CommandTest())
+	rootCmd.AddCommand(CommandHash())
+	rootCmd.AddCommand(CommandList())
+	rootCmd.AddCommand(CommandCat())
+	rootCmd.AddCommand(CommandScrub())
+	rootCmd.AddCommand(CommandGC())
+	rootCmd.AddCommand(CommandJoin())
+	rootCmd.AddCommand(CommandRun())
+	rootCmd.AddCommand(CommandConfig())
+	rootCmd.AddCommand(CommandCatalog())
+	rootCmd.AddCommand(CommandMkimage())
 	rootCmd.AddCommand(CommandVersion())
 	rootCmd.AddCommand(CommandUpdate())
 
@@ -72,356 +271,1469 @@ func CommandUpdate() *cobra.Command {
 	var command = &cobra.Command{
 		Use:   "update",
 		Short: "Update extractrr to the latest version",
-		Long:  "Update extractrr to the latest version from GitHub releases",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// If version is in dev mode, skip update
-			if version == "dev" {
-				fmt.Println("Cannot update development version")
-				return nil
-			}
+		Long:  "Update extractrr to the latest version from GitHub releases, or to the exact version given with --version",
+		Example: `  extractrr update
+  extractrr update --list
+  extractrr update --version v1.2.3`,
+	}
 
-			fmt.Printf("Current version: %s\n", version)
-			fmt.Println("Checking for updates...")
+	var (
+		target = command.Flags().String("version", "", "Install this release instead of the latest, also to downgrade")
+		list   = command.Flags().Bool("list", false, "List the available releases and exit")
+	)
 
-			// Parse current version with semver for comparison
-			_, err := semver.ParseTolerant(version)
-			if err != nil {
-				return fmt.Errorf("could not parse version: %w", err)
-			}
+	command.RunE = func(cmd *cobra.Command, args []string) error {
+		repository := selfupdate.ParseSlug("autobrr/extractrr")
 
-			latest, found, err := selfupdate.DetectLatest(cmd.Context(), selfupdate.ParseSlug("autobrr/extractrr"))
-			if err != nil {
-				return fmt.Errorf("error occurred while detecting version: %w", err)
-			}
-			if !found {
-				return fmt.Errorf("latest version for %s/%s could not be found from github repository", "autobrr/extractrr", version)
-			}
+		if *list {
+			return listReleases(cmd, repository)
+		}
 
-			if latest.LessOrEqual(version) {
-				fmt.Printf("Current binary is the latest version: %s\n", version)
-				return nil
-			}
+		// If version is in dev mode, skip update
+		if version == "dev" {
+			fmt.Println(msg(MsgUpdateDev, messageData{}))
+			return nil
+		}
 
-			exe, err := selfupdate.ExecutablePath()
-			if err != nil {
-				return fmt.Errorf("could not locate executable path: %w", err)
-			}
+		fmt.Println(msg(MsgUpdateCurrent, messageData{Version: version}))
+		fmt.Println(msg(MsgUpdateChecking, messageData{}))
 
-			if err := selfupdate.UpdateTo(cmd.Context(), latest.AssetURL, latest.AssetName, exe); err != nil {
-				return fmt.Errorf("error occurred while updating binary: %w", err)
-			}
+		// Parse current version with semver for comparison
+		_, err := semver.ParseTolerant(version)
+		if err != nil {
+			return fmt.Errorf("could not parse version: %w", err)
+		}
 
-			fmt.Printf("Successfully updated to version: %s\n", latest.Version())
+		var release *selfupdate.Release
+		var found bool
+		if *target != "" {
+			release, found, err = selfupdate.DetectVersion(cmd.Context(), repository, *target)
+		} else {
+			release, found, err = selfupdate.DetectLatest(cmd.Context(), repository)
+		}
+		if err != nil {
+			return fmt.Errorf("error occurred while detecting version: %w", err)
+		}
+		if !found && *target != "" {
+			return fmt.Errorf("version %s could not be found in github repository %s", *target, "autobrr/extractrr")
+		}
+		if !found {
+			return fmt.Errorf("latest version for %s/%s could not be found from github repository", "autobrr/extractrr", version)
+		}
 
+		// A pinned version is installed even if it is older, so fleets can be converged on it
+		if *target != "" && release.Equal(version) {
+			fmt.Println(msg(MsgUpdatePinned, messageData{Version: version}))
 			return nil
-		},
+		}
+		if *target == "" && release.LessOrEqual(version) {
+			fmt.Println(msg(MsgUpdateLatest, messageData{Version: version}))
+			return nil
+		}
+
+		exe, err := selfupdate.ExecutablePath()
+		if err != nil {
+			return fmt.Errorf("could not locate executable path: %w", err)
+		}
+
+		if err := selfupdate.UpdateTo(cmd.Context(), release.AssetURL, release.AssetName, exe); err != nil {
+			return fmt.Errorf("error occurred while updating binary: %w", err)
+		}
+
+		fmt.Println(msg(MsgUpdateDone, messageData{Version: release.Version()}))
+
+		return nil
 	}
 
 	return command
 }
 
+// listReleases prints the published releases of repository, newest first
+func listReleases(cmd *cobra.Command, repository selfupdate.Repository) error {
+	source, err := selfupdate.NewGitHubSource(selfupdate.GitHubConfig{})
+	if err != nil {
+		return fmt.Errorf("failed to create release source: %w", err)
+	}
+
+	releases, err := source.ListReleases(cmd.Context(), repository)
+	if err != nil {
+		return fmt.Errorf("failed to list releases: %w", err)
+	}
+
+	for _, release := range releases {
+		if release.GetDraft() {
+			continue
+		}
+
+		line := fmt.Sprintf("%-12s %s", release.GetTagName(), release.GetPublishedAt().Format(time.DateOnly))
+		if release.GetPrerelease() {
+			line += " (prerelease)"
+		}
+		if strings.TrimPrefix(release.GetTagName(), "v") == strings.TrimPrefix(version, "v") {
+			line += " (installed)"
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), line)
+	}
+
+	return nil
+}
+
 func CommandExtract() *cobra.Command {
 	var command = &cobra.Command{
 		Use:   "extract",
 		Short: "Extract iso to directory",
 		Example: `  extractrr extract /path/to/file.iso /path/to/export
-  extractrr extract "/path/to/*.iso" /path/to/export`,
+  extractrr extract "/path/to/*.iso" /path/to/export
+  extractrr extract "/path/to/*.iso" "/media/{{.DiscType}}/{{.VolumeLabel}}"
+  extractrr extract "/path/to/*.iso" /mnt/disk1 /mnt/disk2`,
 		Args: func(cmd *cobra.Command, args []string) error {
-			if len(args) != 2 {
-				return fmt.Errorf("requires two args")
+			if len(args) < 2 {
+				return fmt.Errorf("requires an image and at least one destination")
 			}
 			return nil
 		},
 	}
 
 	var (
+		preset       = command.Flags().String("preset", "", "Tune workers, buffers and copy strategies for the destination: "+strings.Join(presetNames, ", ")+", flags given explicitly win")
 		numWorkers   = command.Flags().Int("workers", runtime.NumCPU(), "Number of parallel workers")
-		bufferSize   = command.Flags().Int("buffer", 1024*1024, "Buffer size for file copying (bytes)")
+		autoWorkers  = command.Flags().Bool("auto-workers", false, "Measure the throughput as the extraction goes and adjust how many workers are active, up to --workers")
+		hashWorkers  = command.Flags().Int("hash-workers", runtime.NumCPU(), "Number of files hashed concurrently, independent of --workers")
+		bufferSize   = bufferFlag(command.Flags(), "Buffer size for file copying")
+		largeSize    = command.Flags().String("large-file-size", humanize.IBytes(defaultLargeFileSize), "Files of at least this size are written with --large-strategy, smaller ones with --small-strategy")
+		onError      = command.Flags().StringArray("on-error", nil, "Policy for files failing with a class of errors (read, write, permission, space): CLASS=SETTINGS with retry=N, backoff=DURATION, fail-fast (can be repeated)")
+		smallCopy    = command.Flags().String("small-strategy", "", "How small files are written, comma separated: buffer=SIZE, preallocate, fsync, direct")
+		largeCopy    = command.Flags().String("large-strategy", "", "How large files are written, comma separated: buffer=SIZE, preallocate, fsync, direct")
 		showProgress = command.Flags().Bool("progress", true, "Show progress bar")
+		sfvMode      = command.Flags().String("sfv", SFVNone, "Write SFV checksum files for extracted content: none, single or per-dir")
+		merge        = command.Flags().String("merge", MergeUnion, "How to handle a non-empty destination: abort, union or replace-dir")
+		strip        = command.Flags().Int("strip-components", 0, "Strip NUMBER leading components from in-image paths")
+		sidecar      = command.Flags().Bool("sidecar", true, "Write a "+SidecarName+" file with source, options and file manifest into the destination")
+		checksum     = command.Flags().String("checksum", ChecksumCRC32, "Checksum algorithm for the sidecar manifest: "+strings.Join(checksumAlgorithms, ", "))
+		recurse      = command.Flags().Bool("recurse-images", false, "Extract .iso files found inside the image in place instead of copying them")
+		paths        = command.Flags().StringArray("path", nil, "Only extract this in-image file or directory, globs are allowed (can be repeated)")
+		include      = command.Flags().StringArray("include", nil, "Only extract files matching this glob pattern (can be repeated)")
+		exclude      = command.Flags().StringArray("exclude", nil, "Skip files and directories matching this glob pattern (can be repeated)")
+		includeRegex = command.Flags().StringArray("include-regex", nil, "Only extract files whose full in-image path matches this regular expression (can be repeated)")
+		excludeRegex = command.Flags().StringArray("exclude-regex", nil, "Skip files and directories whose full in-image path matches this regular expression (can be repeated)")
+		ignoreFile   = command.Flags().String("ignore-file", "", "Read gitignore-style rules for in-image paths from this file, in addition to "+IgnoreName+" in the destination")
+		noIgnore     = command.Flags().Bool("no-ignore", false, "Don't read "+IgnoreName+" from the destination or the ignore file from the config directory")
+		atomic       = command.Flags().Bool("atomic", false, "Write files as *"+PartialSuffix+" and rename them once complete")
+		stagingDir   = command.Flags().String("staging-dir", "", "Write files in this directory, e.g. on a fast scratch disk, and move them to the destination once complete")
+		minFree      = command.Flags().String("min-free", "0", "Pause writing while the destination has less free space than this, e.g. 10GiB, 0 disables it")
+		spaceTimeout = command.Flags().Duration("space-timeout", time.Hour, "Fail if the destination stays below --min-free for longer than this, 0 waits forever")
+		stall        = command.Flags().Duration("stall-threshold", 2*time.Second, "Reduce concurrent writers while writes take longer than this, 0 disables it")
+		stuckAfter   = command.Flags().Duration("stuck-after", 5*time.Minute, "Report the extraction as stuck when no data moves for this long, 0 disables it")
+		stuckAction  = command.Flags().String("stuck-action", StuckWarn, "What to do when the extraction is stuck: "+strings.Join(stuckActions, ", "))
+		hungAfter    = command.Flags().Duration("worker-timeout", 10*time.Minute, "Replace a worker that made no progress on its file for this long and retry the file, 0 disables it")
+		dirWriters   = command.Flags().Int("dir-writers", 0, "Maximum number of workers writing into the same destination directory at once, 0 for no limit")
+		dryRun       = command.Flags().Bool("dry-run", false, "Scan and print what would be extracted without writing anything")
+		tree         = command.Flags().Bool("tree", false, "With --dry-run, print the planned destination layout as a tree")
+		notify       = command.Flags().Bool("notify-desktop", false, "Show a desktop notification when the extraction finishes")
+		maxFiles     = command.Flags().Int("max-files", 0, "Abort if an image has more files to extract than this, 0 for no limit")
+		maxTotalSize = command.Flags().String("max-total-size", "0", "Abort if an image has more data to extract than this, e.g. 200GiB, 0 for no limit")
+		maxDepth     = command.Flags().Int("max-image-depth", defaultMaxImageDepth, "With --recurse-images, refuse images nested deeper than this, 0 for no limit")
+		maxExpansion = command.Flags().Float64("max-expansion", defaultMaxExpansion, "With --recurse-images, refuse nested images whose files add up to more than this multiple of their size, 0 for no limit")
+		special      = command.Flags().String("special", SpecialSkip, "Entries that are neither a regular file nor a directory: skip them with a warning, fail, or write an empty placeholder")
+		ssif         = command.Flags().String("ssif", SSIFKeep, "Interleaved 3D Blu-ray streams in BDMV/STREAM/SSIF, which repeat the data of the 2D streams: keep or skip")
+		detectTypes  = command.Flags().Bool("detect-types", false, "Detect the content type of every file from its first bytes, record it in the sidecar and warn about files that look encrypted or mislabeled")
+		reportKey    = command.Flags().String("report-key", "", "Write a "+ReportName+" with the sha256, size and image offset of every file, signed with the HMAC key in this file")
+		routesPath   = command.Flags().String("routes", "", "Send images to the destination of the first matching rule in this JSON file, the destination argument takes the rest")
+		hooks        = command.Flags().StringArray("hook", nil, "Run this shell command after every image, with EXTRACTRR_* variables and a JSON context file describing it (can be repeated)")
+		auditPath    = command.Flags().String("audit-log", "", "Append a JSON line with path, size, checksum, duration and worker of every extracted file to this file")
+		skeleton     = command.Flags().String("skeleton", "", "Only extract files up to this size, e.g. 1MiB, along with every directory, for a lightweight copy of the disc structure")
+		precreate    = command.Flags().Bool("precreate-dirs", false, "Create all directories of the image before extracting, instead of as files are written into them")
+		splitOver4G  = command.Flags().Bool("split-over-4g", false, "Write files larger than the destination filesystem holds as .001, .002... parts")
+		fsCheck      = command.Flags().String("fs-check", FSCheckWarn, "Check the scanned entries against the destination filesystem: "+strings.Join(fsCheckModes, ", "))
+		duplicates   = command.Flags().String("duplicates", DuplicateWarn, "When the destination already holds a complete extraction of a disc with the same volume label: "+strings.Join(duplicateModes, ", "))
+		force        = command.Flags().Bool("force", false, "Extract even if a safety limit is exceeded")
+		skipDone     = command.Flags().Bool("skip-processed", false, "Skip images the ledger records as already extracted successfully")
+		ledgerPath   = command.Flags().String("ledger", "", "Ledger of extracted images (default: "+ledgerName+" in the user config directory)")
+		quota        = command.Flags().String("quota", "0", "Refuse images that would bring what the ledger records below --quota-root past this, e.g. 2TiB, 0 disables it")
+		quotaRoot    = command.Flags().String("quota-root", "", "Destination root --quota applies to (default: the destination)")
+		order        = command.Flags().String("order", OrderName, "Order of images matched by a glob: "+strings.Join(batchOrders, ", "))
+		heartbeat    = command.Flags().Duration("heartbeat", 0, "In a batch, log the status of the whole batch this often, 0 disables it")
+		parallel     = command.Flags().Int("parallel-images", 1, "Extract this many images of a batch at once, each with its own --workers")
+		batchLayout  = command.Flags().String("batch-layout", "", "Where images matched by a glob go: subdir (one per image), flat (all into the destination) or template (default: template for a destination template, subdir otherwise)")
+		priority     = command.Flags().StringArray("priority-pattern", nil, "Extract images whose name matches this glob pattern first (can be repeated, earlier patterns win)")
 	)
 
+	command.RegisterFlagCompletionFunc("path", completeImagePathFlag)
+
 	command.RunE = func(c *cobra.Command, args []string) error {
 		pattern := args[0]
 		extractBaseDir := args[1]
+		destinations := args[1:]
 
-		// Expand the glob pattern to get all matching files
-		matches, err := filepath.Glob(pattern)
+		if *preset != "" {
+			if err := validatePreset(*preset); err != nil {
+				return err
+			}
+			if err := applyPreset(c.Flags(), *preset, extractBaseDir); err != nil {
+				return err
+			}
+		}
+
+		if err := validateSFVMode(*sfvMode); err != nil {
+			return err
+		}
+		if err := validateMergeStrategy(*merge); err != nil {
+			return err
+		}
+		if err := validateChecksum(*checksum); err != nil {
+			return err
+		}
+		if err := validateOrder(*order); err != nil {
+			return err
+		}
+		if err := validateStuckAction(*stuckAction); err != nil {
+			return err
+		}
+		if err := validateFSCheck(*fsCheck); err != nil {
+			return err
+		}
+		if err := validateDuplicateMode(*duplicates); err != nil {
+			return err
+		}
+		if err := validateSpecialMode(*special); err != nil {
+			return err
+		}
+		if err := validateSSIFMode(*ssif); err != nil {
+			return err
+		}
+		maxSize, err := humanize.ParseBytes(*maxTotalSize)
 		if err != nil {
-			return fmt.Errorf("invalid glob pattern: %w", err)
+			return fmt.Errorf("invalid --max-total-size: %w", err)
+		}
+		freeSize, err := humanize.ParseBytes(*minFree)
+		if err != nil {
+			return fmt.Errorf("invalid --min-free: %w", err)
+		}
+		quotaSize, err := humanize.ParseBytes(*quota)
+		if err != nil {
+			return fmt.Errorf("invalid --quota: %w", err)
+		}
+		if *strip < 0 {
+			return fmt.Errorf("--strip-components must not be negative")
+		}
+		var skeletonSize uint64
+		if *skeleton != "" {
+			if skeletonSize, err = humanize.ParseBytes(*skeleton); err != nil || skeletonSize == 0 {
+				return fmt.Errorf("invalid --skeleton %q: must be a size like 1MiB", *skeleton)
+			}
+		}
+		largeFileSize, err := humanize.ParseBytes(*largeSize)
+		if err != nil {
+			return fmt.Errorf("invalid --large-file-size: %w", err)
+		}
+		smallStrategy, err := parseCopyStrategy(*smallCopy)
+		if err != nil {
+			return fmt.Errorf("invalid --small-strategy: %w", err)
+		}
+		largeStrategy, err := parseCopyStrategy(*largeCopy)
+		if err != nil {
+			return fmt.Errorf("invalid --large-strategy: %w", err)
 		}
 
-		if len(matches) == 0 {
-			return fmt.Errorf("no files found matching pattern: %s", pattern)
+		errorPolicies, err := parseErrorPolicies(*onError)
+		if err != nil {
+			return err
 		}
 
+		opts := ExtractOptions{
+			Workers:        *numWorkers,
+			HashWorkers:    *hashWorkers,
+			AutoWorkers:    *autoWorkers,
+			BufferSize:     *bufferSize,
+			LargeFileSize:  int64(largeFileSize),
+			SmallStrategy:  smallStrategy,
+			LargeStrategy:  largeStrategy,
+			ShowProgress:   *showProgress,
+			SFV:            *sfvMode,
+			Merge:          *merge,
+			Strip:          *strip,
+			Sidecar:        *sidecar,
+			Checksum:       *checksum,
+			Recurse:        *recurse,
+			Paths:          *paths,
+			Include:        *include,
+			Exclude:        *exclude,
+			IncludeRegex:   *includeRegex,
+			ExcludeRegex:   *excludeRegex,
+			Atomic:         *atomic,
+			StagingDir:     *stagingDir,
+			StallThreshold: *stall,
+			DirWriters:     *dirWriters,
+			StuckAfter:     *stuckAfter,
+			StuckAction:    *stuckAction,
+			HungAfter:      *hungAfter,
+			MinFree:        int64(freeSize),
+			SpaceTimeout:   *spaceTimeout,
+			MaxFiles:       *maxFiles,
+			MaxTotalSize:   int64(maxSize),
+			MaxImageDepth:  *maxDepth,
+			MaxExpansion:   *maxExpansion,
+			FSCheck:        *fsCheck,
+			Duplicates:     *duplicates,
+			SplitOver4G:    *splitOver4G,
+			PrecreateDirs:  *precreate,
+			Skeleton:       int64(skeletonSize),
+			ErrorPolicies:  errorPolicies,
+			Special:        *special,
+			SSIF:           *ssif,
+			DetectTypes:    *detectTypes,
+			Force:          *force,
+			SkipProcessed:  *skipDone,
+			DryRun:         *dryRun,
+			Tree:           *tree,
+			hooks:          *hooks,
+		}
+
+		if opts.StagingDir != "" {
+			if opts.StagingDir, err = filepath.Abs(opts.StagingDir); err != nil {
+				return fmt.Errorf("invalid --staging-dir: %w", err)
+			}
+		}
+
+		if *tree && !*dryRun {
+			return fmt.Errorf("--tree requires --dry-run")
+		}
+
+		if *reportKey != "" {
+			if opts.reportKey, err = readReportKey(*reportKey); err != nil {
+				return err
+			}
+		}
+		if *routesPath != "" {
+			if opts.routes, err = readRoutes(*routesPath); err != nil {
+				return err
+			}
+		}
+
+		if isDestinationTemplate(extractBaseDir) {
+			tmpl, err := parseDestinationTemplate(extractBaseDir)
+			if err != nil {
+				return err
+			}
+			opts.DestTemplate = tmpl
+		}
+		layout, err := resolveBatchLayout(*batchLayout, opts.DestTemplate != nil)
+		if err != nil {
+			return err
+		}
+		if len(destinations) > 1 && opts.DestTemplate != nil {
+			return fmt.Errorf("a destination template can't be combined with other destinations")
+		}
+		if *parallel < 1 {
+			return fmt.Errorf("--parallel-images must be at least 1")
+		}
+		if *parallel > 1 && layout == LayoutFlat {
+			return fmt.Errorf("--parallel-images can't be used with --batch-layout flat, the images would write into the same files at once")
+		}
+
+		// config check only validates the flags, everything from here on touches the disk
+		if checkOnly(c.Context()) {
+			return nil
+		}
+
+		if opts.StagingDir != "" {
+			if err := os.MkdirAll(opts.StagingDir, 0755); err != nil {
+				return fmt.Errorf("failed to create staging directory: %w", err)
+			}
+		}
+
+		if *auditPath != "" && !*dryRun {
+			if opts.audit, err = openAuditLog(*auditPath); err != nil {
+				return err
+			}
+			defer func() {
+				if err := opts.audit.Close(); err != nil {
+					slog.Warn("Failed to close audit log", "error", err)
+				}
+			}()
+		}
+
+		// Standing ignore rules come from the config directory and the destination
+		// given on the command line, a template has no destination to read them from yet
+		// and with several it isn't known yet which an image goes to
+		var files []string
+		if !*noIgnore {
+			dest := extractBaseDir
+			if opts.DestTemplate != nil || len(destinations) > 1 {
+				dest = ""
+			}
+			files = ignoreFiles(dest, *ignoreFile)
+		} else if *ignoreFile != "" {
+			files = []string{*ignoreFile}
+		}
+		if opts.Ignore, err = readIgnoreFiles(files, *ignoreFile); err != nil {
+			return err
+		}
+
+		if opts.ledger, err = openLedger(*ledgerPath); err != nil {
+			if *skipDone || quotaSize > 0 {
+				return err
+			}
+			slog.Warn("Not recording extracted images", "error", err)
+		}
+
+		if quotaSize > 0 {
+			root := *quotaRoot
+			if root == "" {
+				if opts.DestTemplate != nil || len(destinations) > 1 {
+					return fmt.Errorf("--quota needs --quota-root with a destination template or several destinations")
+				}
+				root = extractBaseDir
+			}
+			if opts.quota, err = newDestQuota(root, int64(quotaSize), opts.ledger); err != nil {
+				return err
+			}
+			slog.Info("Destination quota", "root", opts.quota.root, "used", humanize.IBytes(uint64(opts.ledger.usedBytes(opts.quota.root))), "quota", humanize.IBytes(quotaSize))
+		}
+
+		matches, err := expandImagePattern(pattern)
+		if err != nil {
+			return err
+		}
+		if len(matches) > 1 {
+			if matches, err = orderImages(matches, *order, *priority); err != nil {
+				return err
+			}
+		}
+
+		// With several destinations every image is assigned one up front
+		var plan []placement
+		if len(destinations) > 1 {
+			var spaces []destSpace
+			plan, spaces, err = planPlacement(matches, destinations, opts)
+			if err != nil {
+				return err
+			}
+			if opts.DryRun {
+				if err := printPlacement(os.Stdout, plan, spaces); err != nil {
+					return err
+				}
+			}
+			if err := checkPlacement(plan); err != nil {
+				return err
+			}
+		}
+
+		startTime := time.Now()
+
 		// If only one file matches, use the exact extractDir provided
 		if len(matches) == 1 {
-			return extractISO(matches[0], extractBaseDir, *numWorkers, *bufferSize, *showProgress)
+			if plan != nil {
+				extractBaseDir = plan[0].Dest
+			}
+			err := extractISO(matches[0], extractBaseDir, opts)
+			if *notify && !opts.DryRun {
+				failed := 0
+				if err != nil {
+					failed = 1
+				}
+				notifyExtraction(matches[0], 1, failed, startTime)
+			}
+			return err
 		}
 
 		// Multiple files matched the pattern
-		log.Printf("Found %d files matching the pattern", len(matches))
+		slog.Info("Found files matching the pattern", "images", len(matches))
+
+		// Images extracted at once share one progress display instead of drawing their own bars
+		var display *batchDisplay
+		if *heartbeat > 0 || *parallel > 1 {
+			opts.batch = newBatchStatus(matches)
+			if *parallel > 1 && opts.ShowProgress && !opts.DryRun {
+				display = newBatchDisplay(opts.batch)
+			}
+		}
+		done := make(chan struct{})
+		var displayDone sync.WaitGroup
+		if *heartbeat > 0 {
+			go opts.batch.run(*heartbeat, done)
+		}
+		if display != nil {
+			displayDone.Add(1)
+			go func() {
+				defer displayDone.Done()
+				display.run(done)
+			}()
+		}
 
-		// Process each file in sequence
-		for _, isoFile := range matches {
-			// For multiple files, create subdirectories based on filename
-			baseName := filepath.Base(isoFile)
-			fileNameWithoutExt := strings.TrimSuffix(baseName, filepath.Ext(baseName))
-			fileExtractDir := filepath.Join(extractBaseDir, fileNameWithoutExt)
+		succeeded, err := runBatch(len(matches), *parallel, func(i int) error {
+			isoFile := matches[i]
+			// For multiple files, create subdirectories based on filename unless
+			// the layout merges them or the destination template places each image
+			baseDir := extractBaseDir
+			if plan != nil {
+				baseDir = plan[i].Dest
+			}
+			fileExtractDir := batchDestination(layout, baseDir, isoFile)
 
-			log.Printf("Processing %s -> %s", isoFile, fileExtractDir)
-			if err := extractISO(isoFile, fileExtractDir, *numWorkers, *bufferSize, *showProgress); err != nil {
-				// Log error but continue with next file
-				log.Printf("Error extracting %s: %v", isoFile, err)
+			slog.Info("Processing image", "iso", isoFile, "dest", fileExtractDir)
+			err := extractISO(isoFile, fileExtractDir, opts)
+			if opts.batch != nil {
+				opts.batch.imageDone()
+			}
+			// Log error but continue with next file, a stuck one ends the batch
+			if err != nil && !errors.Is(err, errStuck) {
+				slog.Error("Failed to extract image", "iso", isoFile, "error", err)
 			}
+			return err
+		})
+		close(done)
+		displayDone.Wait()
+
+		if *notify && !opts.DryRun {
+			// After a stuck image the ones never started count as failed too
+			notifyExtraction(pattern, len(matches), len(matches)-succeeded, startTime)
 		}
 
-		return nil
+		return err
 	}
 
 	return command
 }
 
-// extractISO handles the extraction of a single ISO file to a target directory
-func extractISO(isoFile, extractDir string, numWorkers int, bufferSize int, showProgress bool) error {
+// extractISO extracts a single image and runs the hooks once it is done
+func extractISO(isoFile, extractDir string, opts ExtractOptions) error {
+	startTime := time.Now()
+	opts.jobID = newJobID()
+	opts.logger = slog.With("job", opts.jobID, "iso", isoFile)
+
+	outcome := &jobOutcome{JobID: opts.jobID, Source: isoFile, Dest: extractDir}
+	err := extractImage(isoFile, extractDir, opts, outcome)
+	if len(opts.hooks) > 0 && !opts.DryRun {
+		outcome.finish(err, startTime)
+		runHooks(opts.log(), opts.hooks, outcome)
+	}
+	return err
+}
+
+// extractImage handles the extraction of a single ISO file to a target directory,
+// recording how it went in outcome. If opts.DestTemplate is set, extractDir is
+// ignored and the destination is rendered from the scanned image metadata instead.
+func extractImage(isoFile, extractDir string, opts ExtractOptions, outcome *jobOutcome) error {
 	startTime := time.Now()
+	logger := opts.log()
+
+	var source *SidecarSource
+	if opts.ledger != nil {
+		described, err := describeSource(isoFile)
+		if err != nil {
+			return fmt.Errorf("failed to open source: %w", err)
+		}
+		source = &described
+
+		if entry, ok := opts.ledger.lookup(described); ok && opts.SkipProcessed {
+			logger.Info("Skipping already processed image", "dest", entry.Dest, "extracted_at", entry.ExtractedAt)
+			outcome.Dest, outcome.skipped = entry.Dest, true
+			return nil
+		}
+	}
+
+	logger.Info("Initializing UDF reader")
+	// Open UDF filesystem
+	src := &imageSource{Path: isoFile}
+	image, err := openBackend(src)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+
+	// First pass: scan the ISO structure to gather file info
+	// This helps with showing progress and planning extraction
+	logger.Info("Scanning ISO structure")
+	filter, err := newPathFilter(opts.filterOptions())
+	if err != nil {
+		return err
+	}
+
+	scan := &scanResult{}
+	if err := scanISOStructure(image, "/", "", opts.Strip, filter, scan); err != nil {
+		return fmt.Errorf("failed to scan ISO: %w", err)
+	}
+	applySSIFMode(scan, opts.ssif())
+	jobs, dirs, totalSize, fileCount := scan.Jobs, scan.Dirs, scan.TotalSize, scan.FileCount
+
+	skippedSize := scan.skippedSize()
+	counts := scan.counts()
+	logger.Info("Scan complete", append([]any{"files", fileCount, "bytes", totalSize, "size", humanize.IBytes(uint64(totalSize)), "skipped", len(scan.Skipped), "skipped_bytes", skippedSize}, counts.attrs()...)...)
+	logBlurayLayout(logger, image, scan)
+	warnUnsafeEntries(logger, scan)
+	if err := checkSpecialEntries(logger, scan, opts.special()); err != nil {
+		return err
+	}
+
+	if opts.DestTemplate != nil || opts.routes != nil {
+		meta, err := readImageMetadata(image, isoFile, totalSize, fileCount)
+		if err != nil {
+			return fmt.Errorf("failed to read image metadata: %w", err)
+		}
+
+		dest, rule, err := routeImage(opts.routes, meta)
+		switch {
+		case err != nil:
+			return err
+		case rule > 0:
+			extractDir = dest
+			logger.Info("Routed image", "rule", rule, "label", meta.VolumeLabel, "dest", extractDir)
+		case opts.DestTemplate != nil:
+			if extractDir, err = renderDestination(opts.DestTemplate, meta); err != nil {
+				return err
+			}
+			logger.Info("Rendered destination", "dest", extractDir)
+		}
+	}
+
+	label := image.VolumeLabel()
+	outcome.Dest, outcome.VolumeLabel, outcome.Files, outcome.Bytes = extractDir, label, fileCount, totalSize
+	if opts.Duplicates != DuplicateOff {
+		if existing, ok := findDuplicate(extractDir, label, scan); ok {
+			if opts.Duplicates == DuplicateSkip {
+				logger.Info("Skipping disc already extracted into destination", "dest", extractDir, "label", label, "source", existing.Source.Path)
+				outcome.skipped = true
+				return nil
+			}
+			logger.Warn("Destination already holds a complete extraction of this disc", "dest", extractDir, "label", label, "source", existing.Source.Path)
+		}
+	}
+
+	if opts.SplitOver4G {
+		if n := markSplitJobs(extractDir, jobs); n > 0 {
+			logger.Info("Splitting files the destination can't hold", "files", n)
+		}
+	}
+
+	var compat compatReport
+	if opts.FSCheck != FSCheckOff {
+		compat = checkCompatibility(extractDir, scan)
+	}
+
+	if opts.DryRun {
+		if err := printPlan(os.Stdout, extractDir, scan, opts.Tree); err != nil {
+			return err
+		}
+		if opts.FSCheck == FSCheckOff {
+			return nil
+		}
+		return printCompatibility(os.Stdout, compat)
+	}
+
+	if err := checkLimits(opts, len(jobs), totalSize); err != nil {
+		return err
+	}
+	release, err := opts.quota.reserve(opts, extractDir, totalSize)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := enforceCompatibility(logger, compat, opts.FSCheck); err != nil {
+		return err
+	}
+
+	if opts.Merge == MergeAbort {
+		if err := checkDestinationEmpty(extractDir); err != nil {
+			return err
+		}
+	}
 
 	// Ensure extract directory exists
 	if err := os.MkdirAll(extractDir, 0755); err != nil {
 		return fmt.Errorf("failed to create extract directory: %w", err)
 	}
 
-	log.Printf("Initializing UDF reader for %s...", isoFile)
-	// Open UDF filesystem
-	cIsoPath := C.CString(isoFile)
-	defer C.free(unsafe.Pointer(cIsoPath))
+	// Nested images are extracted into a directory named after them, which is what replace-dir
+	// has to replace rather than the image file
+	if opts.Recurse {
+		totalSize -= markImageJobs(jobs)
+	}
+	if opts.Merge == MergeReplaceDir {
+		if err := replaceConflictingEntries(extractDir, scan, logger); err != nil {
+			return fmt.Errorf("failed to replace existing entries: %w", err)
+		}
+	}
 
-	udf := C.udfread_init()
-	if udf == nil {
-		return fmt.Errorf("failed to initialize UDF reader")
+	opts.root, err = prepareDestination(extractDir, dirs, jobs, opts.PrecreateDirs)
+	if err != nil {
+		return err
+	}
+	if opts.special() == SpecialPlaceholder {
+		if err := createPlaceholders(opts.root, scan); err != nil {
+			return fmt.Errorf("failed to create placeholders: %w", err)
+		}
 	}
-	defer C.udfread_close(udf)
+	planned := totalSize
+	opts.planned = &planned
+	opts.space = newSpaceGuard(logger, extractDir, opts.MinFree, opts.SpaceTimeout)
 
-	if C.udfread_open(udf, cIsoPath) != 0 {
-		return fmt.Errorf("failed to open ISO file: %s", isoFile)
+	var manifest *Sidecar
+	if opts.Sidecar {
+		manifest, err = newSidecar(isoFile, extractDir, label, opts, jobs)
+		if err != nil {
+			return fmt.Errorf("failed to create sidecar: %w", err)
+		}
+		if err := writeSidecar(extractDir, manifest); err != nil {
+			return fmt.Errorf("failed to write sidecar: %w", err)
+		}
 	}
 
-	// First pass: scan the ISO structure to gather file info
-	// This helps with showing progress and planning extraction
-	log.Printf("Scanning ISO structure...")
-	var totalSize int64
-	var fileCount int
-	jobs := make([]Job, 0)
+	// An aborted run still records what was finished so it can be resumed
+	results, runErr := runJobs(src, jobs, totalSize, opts)
+	if runErr == nil {
+		runErr = extractInnerImages(src, jobs, opts, results)
+	}
+	if runErr == nil && !opts.PrecreateDirs {
+		runErr = createDirs(opts.root, dirs)
+	}
+
+	outcome.Bytes = planned
+	outcome.ErrorCount, outcome.Errors = errorKinds(results)
+	if err := finishExtraction(isoFile, extractDir, opts, manifest, results); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return runErr
+	}
 
-	err := scanISOStructure(udf, "/", extractDir, &jobs, &totalSize, &fileCount)
+	// A report vouches for the whole tree, one with files missing would prove nothing
+	if opts.reportKey != nil {
+		if allSucceeded(results) {
+			if err := signedReport(isoFile, extractDir, label, opts.reportKey, results); err != nil {
+				return fmt.Errorf("failed to write report: %w", err)
+			}
+		} else {
+			logger.Warn("Not writing a report, not every file was extracted")
+		}
+	}
+
+	// Only complete extractions count as processed, failed files are worth another try
+	if source != nil && allSucceeded(results) {
+		if err := opts.ledger.record(*source, extractDir, planned); err != nil {
+			logger.Warn("Failed to record image in ledger", "error", err)
+		}
+	}
+
+	summary := append([]any{"skipped_bytes", skippedSize}, counts.attrs()...)
+	if paths := specialPaths(scan); len(paths) > 0 {
+		summary = append(summary, "special_entries", paths)
+	}
+	if failed, kinds := errorKinds(results); failed > 0 {
+		summary = append(summary, "failed_files", failed, "errors", kinds)
+	}
+	if opts.DetectTypes {
+		warnContentTypes(logger, results)
+		summary = append(summary, "types", typeCounts(results))
+	}
+	logSummary(logger, startTime, totalSize, summary...)
+
+	return nil
+}
+
+// prepareDestination makes the job destinations absolute and refuses any that escape the
+// root. Directories are created by the workers as files need them, or all up front with
+// precreate. The returned root creates them.
+func prepareDestination(extractDir string, dirs []string, jobs []Job, precreate bool) (*destRoot, error) {
+	root, err := newDestRoot(extractDir)
 	if err != nil {
-		return fmt.Errorf("failed to scan ISO: %w", err)
+		return nil, err
+	}
+
+	// Scanned paths are relative to the destination
+	if precreate {
+		if err := createDirs(root, dirs); err != nil {
+			return nil, err
+		}
+	}
+	for i := range jobs {
+		path, err := root.join(jobs[i].DstPath)
+		if err != nil {
+			return nil, err
+		}
+		if precreate {
+			if err := root.mkdirAll(filepath.Dir(path)); err != nil {
+				return nil, err
+			}
+		}
+		if err := root.checkFile(path); err != nil {
+			return nil, err
+		}
+		jobs[i].DstPath = path
 	}
 
-	log.Printf("Found %d files with total size of %s", fileCount, humanize.IBytes(uint64(totalSize)))
+	return root, nil
+}
+
+// createDirs creates the scanned directories below root, with lazy creation that
+// leaves the ones no file was extracted into
+func createDirs(root *destRoot, dirs []string) error {
+	for _, dir := range dirs {
+		path, err := root.join(dir)
+		if err != nil {
+			return err
+		}
+		if err := root.mkdirAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// Create worker pool and job channel
-	jobChan := make(chan Job, fileCount)
-	var wg sync.WaitGroup
+// markImageJobs flags nested images so they are extracted in place into a directory
+// named after them, and returns their combined size
+func markImageJobs(jobs []Job) int64 {
+	var size int64
+	for i := range jobs {
+		if !isImageFile(jobs[i].SrcPath) {
+			continue
+		}
 
-	// Setup progress bar if enabled
-	var bar *pb.ProgressBar
-	if showProgress {
-		bar = pb.Full.Start64(totalSize)
-		bar.Set(pb.Bytes, true)
+		jobs[i].Image = true
+		jobs[i].DstPath = strings.TrimSuffix(jobs[i].DstPath, filepath.Ext(jobs[i].DstPath))
+		size += jobs[i].Size
 	}
 
-	// Progress tracking
-	progressChan := make(chan int64)
-	go func() {
-		var processedSize int64
-		for size := range progressChan {
-			processedSize += size
-			if bar != nil {
-				bar.SetCurrent(processedSize)
+	return size
+}
+
+// extractInnerImages extracts the nested image jobs of src and merges their results into results.
+// The result for the image itself is failed if any file inside it could not be extracted, the
+// error is only returned for an aborted run.
+func extractInnerImages(src *imageSource, jobs []Job, opts ExtractOptions, results map[string]fileResult) error {
+	for _, job := range jobs {
+		if !job.Image {
+			continue
+		}
+
+		inner := &imageSource{Path: job.SrcPath, Parent: src}
+		logger := opts.log().With("image", inner.String())
+		logger.Info("Extracting inner image", "dest", job.DstPath)
+
+		innerResults, err := extractInnerImage(inner, job.DstPath, job.Size, opts)
+		if err != nil {
+			logger.Error("Failed to extract inner image", "error", err)
+		}
+
+		for path, result := range innerResults {
+			results[path] = result
+			if result.Err != nil && err == nil {
+				err = fmt.Errorf("failed to extract %s", path)
 			}
 		}
-	}()
+		results[job.DstPath] = fileResult{Err: err}
+
+		// An aborted run ends the whole extraction, not just this image
+		if errors.Is(err, errStuck) || errors.Is(err, errFailFast) {
+			return err
+		}
+	}
+
+	return nil
+}
 
-	// Start worker goroutines
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func(id int) {
-			defer wg.Done()
+// extractInnerImage extracts a nested image of size bytes, and any images nested in it, into extractDir
+func extractInnerImage(src *imageSource, extractDir string, size int64, opts ExtractOptions) (map[string]fileResult, error) {
+	image, err := openBackend(src)
+	if err != nil {
+		return nil, err
+	}
+	defer image.Close()
 
-			// Each worker gets its own UDF handle to avoid concurrency issues
-			workerUdf := C.udfread_init()
-			if workerUdf == nil {
-				log.Printf("Worker %d: Failed to initialize UDF reader", id)
+	scan := &scanResult{}
+	if err := scanISOStructure(image, "/", "", 0, nil, scan); err != nil {
+		return nil, fmt.Errorf("failed to scan inner image: %w", err)
+	}
+	applySSIFMode(scan, opts.ssif())
+	jobs, dirs, totalSize, fileCount := scan.Jobs, scan.Dirs, scan.TotalSize, scan.FileCount
+
+	opts.log().Info("Scan complete", "image", src.String(), "files", fileCount, "bytes", totalSize, "size", humanize.IBytes(uint64(totalSize)))
+	warnUnsafeEntries(opts.log().With("image", src.String()), scan)
+	if err := checkSpecialEntries(opts.log().With("image", src.String()), scan, opts.special()); err != nil {
+		return nil, err
+	}
+
+	if err := checkInnerImage(src, size, totalSize, opts); err != nil {
+		return nil, err
+	}
+
+	opts.root, err = prepareDestination(extractDir, dirs, jobs, opts.PrecreateDirs)
+	if err != nil {
+		return nil, err
+	}
+	if opts.special() == SpecialPlaceholder {
+		if err := createPlaceholders(opts.root, scan); err != nil {
+			return nil, fmt.Errorf("failed to create placeholders: %w", err)
+		}
+	}
+	totalSize -= markImageJobs(jobs)
+
+	results, err := runJobs(src, jobs, totalSize, opts)
+	if err == nil {
+		err = extractInnerImages(src, jobs, opts, results)
+	}
+	if err == nil && !opts.PrecreateDirs {
+		err = createDirs(opts.root, dirs)
+	}
+
+	return results, err
+}
+
+// runJobs extracts the regular file jobs from src with a pool of workers and returns the results
+// keyed by destination path. The error is set if the pool was aborted.
+func runJobs(src *imageSource, jobs []Job, totalSize int64, opts ExtractOptions) (map[string]fileResult, error) {
+	files := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if !job.Image {
+			files = append(files, job)
+		}
+	}
+
+	hashWorkers := opts.HashWorkers
+	if hashWorkers <= 0 {
+		hashWorkers = runtime.NumCPU()
+	}
+	slots := make(chan struct{}, hashWorkers)
+	pools := newStrategyBuffers(opts, slots)
+	gate := newWriteGate(opts.log(), opts.Workers, opts.StallThreshold)
+	errs := newErrorThrottle(opts.log())
+
+	opts.log().Info("Starting extraction", "workers", opts.Workers, "hash_workers", hashWorkers)
+	if opts.SmallStrategy != nil || opts.LargeStrategy != nil {
+		opts.log().Info("Copy strategies", "large_file_size", opts.LargeFileSize, "small", opts.strategy(0).String(), "large", opts.strategy(max(opts.LargeFileSize, 1)).String())
+	}
+	pool := poolOptions{
+		Workers:      opts.Workers,
+		AutoScale:    opts.AutoWorkers,
+		BufferSize:   opts.BufferSize,
+		ShowProgress: opts.ShowProgress,
+		DirWriters:   opts.DirWriters,
+		HungAfter:    opts.HungAfter,
+		Stuck:        stuckWatch{After: opts.StuckAfter, Action: opts.StuckAction, Source: src.Path},
+	}
+	if opts.audit != nil {
+		pool.Done = func(worker int, job Job, result fileResult) {
+			if result.Err != nil {
 				return
 			}
-			defer C.udfread_close(workerUdf)
+			if err := opts.audit.record(newAuditRecord(opts, src, worker, job, result)); err != nil {
+				opts.log().Warn("Failed to write audit log", "file", job.SrcPath, "error", err)
+			}
+		}
+	}
+	if opts.ErrorPolicies != nil {
+		pool.Abort = func(result fileResult) error {
+			if result.Err == nil || !opts.errorPolicy(result.Err).FailFast {
+				return nil
+			}
+			return fmt.Errorf("%w: %s error: %w", errFailFast, errorClass(result.Err), result.Err)
+		}
+	}
+	if opts.batch != nil {
+		job := opts.batch.start(src.String(), totalSize)
+		defer opts.batch.finish(job)
+		pool.Processed = &job.processed
+		pool.Bar = job.bar
+	}
+	poolResults, poolErr := runPool(opts.log(), src, files, totalSize, pool, func(ctx context.Context, image SourceBackend, job Job, buffer []byte, progressChan chan<- int64) fileResult {
+		// SFV files always need crc32, the manifest uses whatever was chosen
+		// The audit log records the checksum of the manifest, with or without one
+		checksummed := opts.Sidecar || opts.audit != nil
+		var crc hash.Hash32
+		if opts.SFV != SFVNone || (checksummed && opts.checksum() == ChecksumCRC32) {
+			crc = crc32.NewIEEE()
+		}
+		var digest hash.Hash
+		if checksummed && opts.checksum() != ChecksumCRC32 {
+			digest = newChecksum(opts.checksum())
+		}
+		var audit hash.Hash
+		if opts.reportKey != nil {
+			audit = sha256.New()
+		}
+
+		// The worker's buffer is sized for --buffer, a strategy may want another one
+		strategy := opts.strategy(job.Size)
+		buffers := pools[alignBufferSize(strategy.BufferSize)]
+		if len(buffer) != alignBufferSize(strategy.BufferSize) {
+			buffer = buffers.buffers.Get().([]byte)
+			defer buffers.buffers.Put(buffer)
+		}
 
-			cWorkerIsoPath := C.CString(isoFile)
-			defer C.free(unsafe.Pointer(cWorkerIsoPath))
+		var sniffer *typeSniffer
+		w := hashWriter(crc, digest, audit)
+		if opts.DetectTypes {
+			sniffer = newTypeSniffer()
+			w = withSniffer(w, sniffer)
+		}
 
-			if C.udfread_open(workerUdf, cWorkerIsoPath) != 0 {
-				log.Printf("Worker %d: Failed to open ISO file", id)
-				return
+		var ring *hashRing
+		if w != nil {
+			ring = buffers.rings.Get().(*hashRing)
+			defer buffers.rings.Put(ring)
+		}
+		// Without hashing a second buffer per file lets the next read overlap with the write
+		var spare []byte
+		if ring == nil {
+			spare = buffers.spares.Get().([]byte)
+			defer buffers.spares.Put(spare)
+		}
+
+		start := time.Now()
+		var result fileResult
+		var err error
+		for attempt := 1; ; attempt++ {
+			if ring != nil {
+				ring.begin(w)
+			}
+			err = extractFile(ctx, image, job.SrcPath, job.DstPath, buffer, writeOptions{ring: ring, gate: gate, space: opts.space, atomic: opts.Atomic, split: job.Split, staging: opts.StagingDir, spare: spare, root: opts.root, strategy: strategy}, progressChan)
+			if ring != nil {
+				ring.end()
+			}
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+			delay, retry := opts.retryDelay(err, attempt)
+			if !retry {
+				break
 			}
 
-			buffer := make([]byte, bufferSize)
+			opts.log().Warn("Retrying file", "file", job.SrcPath, "class", errorClass(err), "attempt", attempt, "delay", delay, "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			// The next attempt starts over, so do the checksums
+			for _, h := range []hash.Hash{crc, digest, audit} {
+				if h != nil {
+					h.Reset()
+				}
+			}
+			if sniffer != nil {
+				sniffer.reset()
+			}
+		}
 
-			for job := range jobChan {
-				err := extractFile(workerUdf, job.SrcPath, job.DstPath, buffer, progressChan)
-				if err != nil {
-					log.Printf("Error extracting %s: %v", job.SrcPath, err)
+		if err != nil {
+			errs.error("Failed to extract file", err, job.SrcPath, "bytes", job.Size)
+			result.Err = err
+		} else {
+			if crc != nil {
+				result.CRC32 = crc.Sum32()
+				result.HasCRC = true
+			}
+			if digest != nil {
+				result.Digest = digest.Sum(nil)
+			}
+			if sniffer != nil {
+				result.Type = detectType(sniffer.head)
+			}
+			if audit != nil {
+				result.Report = &ReportFile{Images: imageChain(src), SrcPath: job.SrcPath, Size: job.Size, SHA256: hex.EncodeToString(audit.Sum(nil))}
+				if job.Block >= 0 {
+					result.Report.Offset = job.Block * udfBlockSize
 				}
 			}
-		}(i)
+			result.Duration = time.Since(start)
+		}
+
+		return result
+	})
+	errs.flush()
+
+	// Collected results keyed by destination path
+	results := make(map[string]fileResult, len(files))
+	for i, job := range files {
+		results[job.DstPath] = poolResults[i]
 	}
 
-	// Submit jobs to the pool
-	log.Printf("Starting extraction with %d workers...", numWorkers)
-	for _, job := range jobs {
-		jobChan <- job
+	return results, poolErr
+}
+
+// finishExtraction writes the SFV files and final sidecar for a completed run
+func finishExtraction(isoFile, extractDir string, opts ExtractOptions, manifest *Sidecar, results map[string]fileResult) error {
+	if opts.SFV != SFVNone {
+		if err := writeSFV(isoFile, extractDir, opts.SFV, results); err != nil {
+			return fmt.Errorf("failed to write sfv: %w", err)
+		}
 	}
-	close(jobChan)
 
-	// Wait for all workers to complete
-	wg.Wait()
-	close(progressChan)
+	if manifest != nil {
+		manifest.finish(extractDir, results)
+		if err := writeSidecar(extractDir, manifest); err != nil {
+			return fmt.Errorf("failed to write sidecar: %w", err)
+		}
+	}
 
-	if bar != nil {
-		bar.SetCurrent(totalSize)
-		bar.Finish()
+	return nil
+}
+
+// allSucceeded reports whether every file of an extraction succeeded
+func allSucceeded(results map[string]fileResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return false
+		}
 	}
+	return true
+}
 
+// logSummary logs the duration and average speed of an extraction
+func logSummary(logger *slog.Logger, startTime time.Time, totalSize int64, attrs ...any) {
 	duration := time.Since(startTime)
 
-	log.Printf("Extraction completed in %v", duration)
+	speed := "N/A (extraction too fast)"
 	if totalSize > 0 && duration.Seconds() > 0 {
 		speedBytesPerSec := float64(totalSize) / duration.Seconds()
-		log.Printf("Average speed: %s/s", humanize.IBytes(uint64(speedBytesPerSec)))
-	} else if totalSize > 0 {
-		log.Printf("Average speed: N/A (extraction too fast)")
+		speed = humanize.IBytes(uint64(speedBytesPerSec)) + "/s"
 	}
 
-	return nil
+	logger.Info("Extraction completed", append([]any{"duration", duration.String(), "bytes", totalSize, "speed", speed}, attrs...)...)
 }
 
-// scanISOStructure recursively scans the ISO structure and builds a list of files to extract
-func scanISOStructure(udf *C.udfread, path, destPath string, jobs *[]Job, totalSize *int64, fileCount *int) error {
-	// Create the destination directory
-	if err := os.MkdirAll(destPath, 0755); err != nil {
-		return err
+// scanResult collects what scanISOStructure found
+type scanResult struct {
+	Jobs      []Job
+	Dirs      []string
+	Skipped   []skippedEntry
+	TotalSize int64
+	FileCount int
+}
+
+// skippedSize returns the bytes left out by filters, --path and --strip-components
+func (s *scanResult) skippedSize() int64 {
+	var size int64
+	for _, skipped := range s.Skipped {
+		size += skipped.Size
 	}
+	return size
+}
 
-	// Convert path to C string
-	cPath := C.CString(path)
-	defer C.free(unsafe.Pointer(cPath))
+// scanCounts counts the entries of a scan that carry no data, they are easy to lose
+// without noticing when comparing an extracted tree with the image
+type scanCounts struct {
+	EmptyFiles int
+	EmptyDirs  int
+	Special    int
+}
 
-	// Open directory
-	dir := C.udfread_opendir(udf, cPath)
-	if dir == nil {
-		return fmt.Errorf("failed to open directory: %s", path)
+func (s *scanResult) counts() scanCounts {
+	var c scanCounts
+	parents := make(map[string]bool)
+	for _, job := range s.Jobs {
+		if job.Size == 0 {
+			c.EmptyFiles++
+		}
+		parents[filepath.Dir(job.DstPath)] = true
+	}
+	for _, dir := range s.Dirs {
+		parents[filepath.Dir(dir)] = true
+	}
+	// With --strip-components several directories can share a destination
+	empty := make(map[string]bool)
+	for _, dir := range s.Dirs {
+		if dir := filepath.Clean(dir); dir != "." && !parents[dir] {
+			empty[dir] = true
+		}
+	}
+	c.EmptyDirs = len(empty)
+	for _, skipped := range s.Skipped {
+		if skipped.Special {
+			c.Special++
+		}
+	}
+	return c
+}
+
+// attrs returns the counts as log attributes
+func (c scanCounts) attrs() []any {
+	return []any{"empty_files", c.EmptyFiles, "empty_dirs", c.EmptyDirs, "special", c.Special}
+}
+
+// skippedEntry is a file or directory left out of the extraction
+type skippedEntry struct {
+	SrcPath string
+	DstPath string
+	Size    int64
+	IsDir   bool
+	Reason  string
+	// Unsafe is set for entries whose name could point outside the destination
+	Unsafe bool
+	// Special is set for entries that are neither a regular file nor a directory
+	Special bool
+}
+
+// specialSkipReason is recorded for entries that have no content to extract
+const specialSkipReason = "special entry, neither a regular file nor a directory"
+
+// stripSkipReason is recorded for files above the --strip-components depth, which have no destination
+const stripSkipReason = "above --strip-components depth"
+
+// scanISOStructure recursively scans the ISO structure and builds a list of files to extract
+// and directories to create, with destination paths relative to the extraction root.
+// While strip is greater than zero the current path component is dropped from the destination
+// and files at that level are skipped, like tar --strip-components.
+func scanISOStructure(image SourceBackend, path, destPath string, strip int, filter *pathFilter, scan *scanResult) error {
+	// Record the destination directory
+	if filter.selected(path) {
+		scan.Dirs = append(scan.Dirs, destPath)
 	}
-	defer C.udfread_closedir(dir)
 
 	// Read directory entries
-	for {
-		var dirent C.struct_udfread_dirent
-		result := C.udfread_readdir(dir, &dirent)
-		if result == nil {
-			break
-		}
+	entries, err := image.ReadDir(path)
+	if err != nil {
+		return err
+	}
 
-		// Convert entry name to Go string
-		name := C.GoString(dirent.d_name)
+	for _, entry := range entries {
+		name := entry.Name
 
-		// Skip "." and ".."
-		if name == "." || name == ".." {
+		// Never let a crafted name turn into a path outside its directory
+		if err := checkEntryName(name); err != nil {
+			scan.Skipped = append(scan.Skipped, skippedEntry{SrcPath: strings.TrimSuffix(path, "/") + "/" + name, DstPath: destPath, IsDir: entry.IsDir, Reason: "unsafe entry: " + err.Error(), Unsafe: true})
 			continue
 		}
 
 		// Create full paths
 		srcPath := filepath.Join(path, name)
 		fileDestPath := filepath.Join(destPath, name)
+		if strip > 0 {
+			fileDestPath = destPath
+		}
 
 		// Handle based on entry type
-		if dirent.d_type == C.UDF_DT_DIR {
+		if entry.IsDir {
+			reason := filter.skipReason(srcPath, true)
+			if !filter.descend(srcPath) {
+				reason = "not selected by --path"
+			}
+			if reason != "" {
+				// The skipped bytes are reported too, so the size of the whole tree is needed
+				size, err := dirSize(image, srcPath)
+				if err != nil {
+					return err
+				}
+				scan.Skipped = append(scan.Skipped, skippedEntry{SrcPath: srcPath, DstPath: filepath.Join(destPath, name), Size: size, IsDir: true, Reason: reason})
+				continue
+			}
+
 			// Recursively scan subdirectory
-			if err := scanISOStructure(udf, srcPath, fileDestPath, jobs, totalSize, fileCount); err != nil {
+			if err := scanISOStructure(image, srcPath, fileDestPath, max(strip-1, 0), filter, scan); err != nil {
 				return err
 			}
-		} else if dirent.d_type == C.UDF_DT_REG {
-			// Get file size
-			size, err := getFileSize(udf, srcPath)
+		} else if entry.IsRegular {
+			// Get file size and location
+			info, err := image.Stat(srcPath)
 			if err != nil {
 				return err
 			}
+			size := info.Size
+
+			// Files above the stripped depth have no destination
+			reason := filter.skipReason(srcPath, false)
+			if reason == "" {
+				reason = filter.sizeSkipReason(size)
+			}
+			if strip > 0 {
+				reason = stripSkipReason
+			}
+			if reason != "" {
+				scan.Skipped = append(scan.Skipped, skippedEntry{SrcPath: srcPath, DstPath: filepath.Join(destPath, name), Size: size, Reason: reason})
+				continue
+			}
 
-			*jobs = append(*jobs, Job{
+			scan.Jobs = append(scan.Jobs, Job{
 				SrcPath: srcPath,
 				DstPath: fileDestPath,
 				Size:    size,
+				Block:   info.Block,
 			})
 
-			*totalSize += size
-			*fileCount++
+			scan.TotalSize += size
+			scan.FileCount++
+		} else {
+			// libudfread reports symlinks, devices and other metadata-only entries as unknown
+			reason := filter.skipReason(srcPath, false)
+			if strip > 0 {
+				reason = stripSkipReason
+			}
+			if reason != "" {
+				scan.Skipped = append(scan.Skipped, skippedEntry{SrcPath: srcPath, DstPath: filepath.Join(destPath, name), Reason: reason})
+				continue
+			}
+			scan.Skipped = append(scan.Skipped, skippedEntry{SrcPath: srcPath, DstPath: fileDestPath, Reason: specialSkipReason, Special: true})
 		}
 	}
 
 	return nil
 }
 
-// getFileSize returns the size of a file
-func getFileSize(udf *C.udfread, path string) (int64, error) {
-	cPath := C.CString(path)
-	defer C.free(unsafe.Pointer(cPath))
-
-	file := C.udfread_file_open(udf, cPath)
-	if file == nil {
-		return 0, fmt.Errorf("failed to open file: %s", path)
+// dirSize returns the size of all files below the directory at path
+func dirSize(image SourceBackend, path string) (int64, error) {
+	entries, err := readDir(image, path)
+	if err != nil {
+		return 0, err
 	}
-	defer C.udfread_file_close(file)
 
-	size := C.udfread_file_size(file)
-	if size < 0 {
-		return 0, fmt.Errorf("failed to get file size: %s", path)
+	var total int64
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name)
+		var size int64
+		switch {
+		case entry.IsDir:
+			size, err = dirSize(image, entryPath)
+		case entry.IsRegular:
+			size, err = getFileSize(image, entryPath)
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += size
 	}
 
-	return int64(size), nil
+	return total, nil
+}
+
+// writeOptions controls how extractFile writes a destination file
+type writeOptions struct {
+	// ring, if set, provides the read buffers and hashes what has been written
+	ring *hashRing
+	// gate may hold writes back while the destination stalls
+	gate *writeGate
+	// space pauses writes while the destination is low on space
+	space *spaceGuard
+	// atomic writes next to the destination with PartialSuffix and renames once complete
+	atomic bool
+	// split writes the file as numbered parts with a manifest
+	split bool
+	// staging is the directory files are written in before being moved to the destination
+	staging string
+	// spare is the second buffer reads go to while the first is written, used without ring
+	spare []byte
+	// root creates the parent directories of the destination
+	root *destRoot
+	// strategy is how the file is written, split files only take its buffer size
+	strategy copyStrategy
 }
 
-// extractFile extracts a single file using the provided buffer
-func extractFile(udf *C.udfread, srcPath, destPath string, buffer []byte, progressChan chan<- int64) error {
-	// Convert source path to C string
-	cSrcPath := C.CString(srcPath)
-	defer C.free(unsafe.Pointer(cSrcPath))
+// write writes p through the gate. If the destination fills up anyway the rest of p
+// is written once space has been freed.
+func (w writeOptions) write(f io.Writer, p []byte) (int, error) {
+	written := 0
+	for {
+		w.gate.acquire()
+		writeStart := time.Now()
+		n, err := f.Write(p[written:])
+		w.gate.release(time.Since(writeStart))
+
+		written += n
+		if err == nil || w.space == nil || !errors.Is(err, syscall.ENOSPC) {
+			return written, err
+		}
+		if err := w.space.waitForSpace(int64(len(p) - written)); err != nil {
+			return written, err
+		}
+	}
+}
 
+// extractFile extracts a single file using the provided buffer, or the buffers of
+// w.ring when hashing so the hash runs alongside the copy
+func extractFile(ctx context.Context, image SourceBackend, srcPath, destPath string, buffer []byte, w writeOptions, progressChan chan<- int64) (err error) {
 	// Create parent directories if needed
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+	if err := w.root.mkdirAll(filepath.Dir(destPath)); err != nil {
 		return err
 	}
 
 	// Open source file
-	file := C.udfread_file_open(udf, cSrcPath)
-	if file == nil {
-		return fmt.Errorf("failed to open file: %s", srcPath)
+	file, err := image.OpenFile(srcPath)
+	if err != nil {
+		return &readError{err}
+	}
+	defer file.Close()
+
+	size := file.Size()
+
+	// Staged files are written elsewhere and moved into place, which makes them atomic too.
+	// Split files are always written in place, the staging disk may not hold all parts.
+	writePath := destPath
+	partial := w.atomic || w.staging != ""
+	switch {
+	case w.staging != "" && !w.split:
+		writePath = stagingPath(w.staging, destPath)
+	case partial:
+		writePath = destPath + PartialSuffix
 	}
-	defer C.udfread_file_close(file)
 
 	// Create destination file
-	destFile, err := os.Create(destPath)
-	if err != nil {
-		return err
+	var destFile io.WriteCloser
+	var parts *partWriter
+	if w.split {
+		parts = &partWriter{base: destPath, partial: partial}
+		destFile = parts
+	} else {
+		f, err := createFile(writePath, partial)
+		if err != nil {
+			return err
+		}
+		destFile = newStrategyFile(f, size, w.strategy)
 	}
 	defer destFile.Close()
 
-	// Copy file contents in chunks using the provided buffer
+	if partial {
+		defer func() {
+			// A cancelled worker was replaced, the partial file may already be its successor's
+			if err != nil && ctx.Err() == nil {
+				if parts != nil {
+					parts.remove()
+				} else {
+					os.Remove(writePath)
+				}
+			}
+		}()
+	}
+
+	// Copy file contents in chunks, reading the next one while the current one is written
+	var buffers readBuffers = w.ring
+	if w.ring == nil {
+		spare := w.spare
+		if spare == nil {
+			spare = make([]byte, len(buffer))
+		}
+		buffers = newBufferPair(buffer, spare)
+	}
+	prefetch := startPrefetch(file, buffers)
+	defer prefetch.close()
+
+	var written int64
 	for {
-		bytesRead := C.udfread_file_read(file, unsafe.Pointer(&buffer[0]), C.size_t(len(buffer)))
+		chunk := prefetch.next()
+		buf, bytesRead := chunk.buf, chunk.n
+		// The read may have hung long enough for the file to be handed to another worker
+		if err := ctx.Err(); err != nil {
+			if buf != nil {
+				buffers.release(buf)
+			}
+			return err
+		}
 		if bytesRead <= 0 {
+			if buf != nil {
+				buffers.release(buf)
+			}
 			break
 		}
 
-		n, err := destFile.Write(buffer[:bytesRead])
+		if err := w.space.reserve(bytesRead); err != nil {
+			buffers.release(buf)
+			return err
+		}
+
+		n, err := w.write(destFile, buf[:bytesRead])
+		if w.ring != nil {
+			w.ring.hash(buf[:n])
+		} else {
+			buffers.release(buf)
+		}
+		written += int64(n)
 		if err != nil {
 			return err
 		}
@@ -430,5 +1742,24 @@ func extractFile(udf *C.udfread, srcPath, destPath string, buffer []byte, progre
 		progressChan <- int64(n)
 	}
 
-	return nil
+	// A failed read looks like the end of the file, don't let it pass for a complete one
+	if written != size {
+		return &readError{fmt.Errorf("short read on %s: got %d of %d bytes", srcPath, written, size)}
+	}
+
+	if parts != nil {
+		return parts.commit(ctx, size)
+	}
+
+	if partial {
+		if err := destFile.Close(); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return moveFile(writePath, destPath)
+	}
+
+	return destFile.Close()
 }
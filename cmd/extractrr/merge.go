@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Merge strategies for extracting into an existing destination
+const (
+	MergeAbort      = "abort"
+	MergeUnion      = "union"
+	MergeReplaceDir = "replace-dir"
+)
+
+func validateMergeStrategy(strategy string) error {
+	switch strategy {
+	case MergeAbort, MergeUnion, MergeReplaceDir:
+		return nil
+	default:
+		return fmt.Errorf("invalid merge strategy %q: must be one of %s, %s or %s", strategy, MergeAbort, MergeUnion, MergeReplaceDir)
+	}
+}
+
+// checkDestinationEmpty returns an error if extractDir exists and is not empty,
+// an ignore file prepared for the extraction doesn't count
+func checkDestinationEmpty(extractDir string) error {
+	entries, err := os.ReadDir(extractDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if len(entries) > 1 || len(entries) == 1 && entries[0].Name() != IgnoreName {
+		return fmt.Errorf("destination %s is not empty (use --merge=union or --merge=replace-dir to extract anyway)", extractDir)
+	}
+
+	return nil
+}
+
+// replaceConflictingEntries removes the existing entries in extractDir that the scanned
+// extraction replaces, so its subtrees replace them instead of being merged into them
+func replaceConflictingEntries(extractDir string, scan *scanResult, logger *slog.Logger) error {
+	root, err := resolvePath(extractDir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range replaceTargets(scan) {
+		target := filepath.Join(extractDir, name)
+		if _, err := os.Lstat(target); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		// A symlinked directory in the destination must not lead the removal elsewhere
+		parent, err := resolvePath(filepath.Dir(target))
+		if err != nil {
+			return err
+		}
+		if !withinRoot(root, parent) {
+			return fmt.Errorf("refusing to remove %s: it is below a symlink pointing outside the destination", target)
+		}
+
+		logger.Info("Replacing existing entry", "path", target)
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", target, err)
+		}
+	}
+
+	return nil
+}
+
+// replaceTargets returns the destination paths replace-dir removes: the largest subtrees the
+// scan extracts completely. A directory that the filters only partly extract is not removed as
+// a whole, only the completely extracted entries inside it, so files that are filtered out and
+// never written again are kept.
+func replaceTargets(scan *scanResult) []string {
+	// Directories with anything filtered out below them
+	partial := make(map[string]bool)
+	for _, skipped := range scan.Skipped {
+		if skipped.Reason == stripSkipReason {
+			continue
+		}
+		// Unsafe entries are recorded with the directory they were found in
+		p := filepath.Clean(skipped.DstPath)
+		if !skipped.Unsafe {
+			p = filepath.Dir(p)
+		}
+		for ; p != "." && !partial[p]; p = filepath.Dir(p) {
+			partial[p] = true
+		}
+		partial["."] = true
+	}
+
+	// The extracted destination tree
+	children := make(map[string][]string)
+	isDir := make(map[string]bool)
+	var add func(p string, dir bool)
+	add = func(p string, dir bool) {
+		p = filepath.Clean(p)
+		if p == "." {
+			return
+		}
+		if _, ok := isDir[p]; ok {
+			isDir[p] = isDir[p] || dir
+			return
+		}
+		isDir[p] = dir
+		parent := filepath.Dir(p)
+		children[parent] = append(children[parent], p)
+		add(parent, true)
+	}
+	for _, dir := range scan.Dirs {
+		add(dir, true)
+	}
+	for _, job := range scan.Jobs {
+		add(job.DstPath, false)
+	}
+
+	var targets []string
+	var walk func(dir string)
+	walk = func(dir string) {
+		names := children[dir]
+		sort.Strings(names)
+		for _, p := range names {
+			if isDir[p] && partial[p] {
+				walk(p)
+				continue
+			}
+			targets = append(targets, p)
+		}
+	}
+	walk(".")
+
+	return targets
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// IDs of the user-facing messages. Log messages stay English, they are meant
+// for searching and ingestion rather than for reading.
+const (
+	MsgNotifyCompleteTitle = "notify.complete.title"
+	MsgNotifyFailedTitle   = "notify.failed.title"
+	MsgNotifyStuckTitle    = "notify.stuck.title"
+	MsgNotifyFinished      = "notify.finished"
+	MsgNotifyFailed        = "notify.failed"
+	MsgNotifyBatch         = "notify.batch"
+	MsgNotifyStuck         = "notify.stuck"
+	MsgUpdateDev           = "update.dev"
+	MsgUpdateCurrent       = "update.current"
+	MsgUpdateChecking      = "update.checking"
+	MsgUpdatePinned        = "update.pinned"
+	MsgUpdateLatest        = "update.latest"
+	MsgUpdateDone          = "update.done"
+)
+
+// messageCatalog holds the message templates of every locale. Templates get a
+// messageData. English is complete, other locales fall back to it per message.
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		MsgNotifyCompleteTitle: "extractrr: extraction complete",
+		MsgNotifyFailedTitle:   "extractrr: extraction failed",
+		MsgNotifyStuckTitle:    "extractrr: extraction stuck",
+		MsgNotifyFinished:      "{{.Image}} finished in {{.Duration}}",
+		MsgNotifyFailed:        "{{.Image}} failed after {{.Duration}}",
+		MsgNotifyBatch:         "{{.Done}} of {{.Total}} images extracted in {{.Duration}}",
+		MsgNotifyStuck:         "{{.Image}} made no progress for {{.Duration}}",
+		MsgUpdateDev:           "Cannot update development version",
+		MsgUpdateCurrent:       "Current version: {{.Version}}",
+		MsgUpdateChecking:      "Checking for updates...",
+		MsgUpdatePinned:        "Current binary is already version: {{.Version}}",
+		MsgUpdateLatest:        "Current binary is the latest version: {{.Version}}",
+		MsgUpdateDone:          "Successfully updated to version: {{.Version}}",
+	},
+	"de": {
+		MsgNotifyCompleteTitle: "extractrr: Entpacken abgeschlossen",
+		MsgNotifyFailedTitle:   "extractrr: Entpacken fehlgeschlagen",
+		MsgNotifyStuckTitle:    "extractrr: Entpacken hängt",
+		MsgNotifyFinished:      "{{.Image}} fertig nach {{.Duration}}",
+		MsgNotifyFailed:        "{{.Image}} nach {{.Duration}} fehlgeschlagen",
+		MsgNotifyBatch:         "{{.Done}} von {{.Total}} Images in {{.Duration}} entpackt",
+		MsgNotifyStuck:         "{{.Image}} seit {{.Duration}} ohne Fortschritt",
+		MsgUpdateDev:           "Eine Entwicklungsversion kann nicht aktualisiert werden",
+		MsgUpdateCurrent:       "Aktuelle Version: {{.Version}}",
+		MsgUpdateChecking:      "Suche nach Updates...",
+		MsgUpdatePinned:        "Version {{.Version}} ist bereits installiert",
+		MsgUpdateLatest:        "Die neueste Version ist bereits installiert: {{.Version}}",
+		MsgUpdateDone:          "Erfolgreich auf Version {{.Version}} aktualisiert",
+	},
+	"fr": {
+		MsgNotifyCompleteTitle: "extractrr : extraction terminée",
+		MsgNotifyFailedTitle:   "extractrr : échec de l'extraction",
+		MsgNotifyStuckTitle:    "extractrr : extraction bloquée",
+		MsgNotifyFinished:      "{{.Image}} terminé en {{.Duration}}",
+		MsgNotifyFailed:        "{{.Image}} a échoué après {{.Duration}}",
+		MsgNotifyBatch:         "{{.Done}} images sur {{.Total}} extraites en {{.Duration}}",
+		MsgNotifyStuck:         "{{.Image}} n'avance plus depuis {{.Duration}}",
+		MsgUpdateDev:           "Impossible de mettre à jour une version de développement",
+		MsgUpdateCurrent:       "Version actuelle : {{.Version}}",
+		MsgUpdateChecking:      "Recherche de mises à jour...",
+		MsgUpdatePinned:        "La version {{.Version}} est déjà installée",
+		MsgUpdateLatest:        "La dernière version est déjà installée : {{.Version}}",
+		MsgUpdateDone:          "Mise à jour vers la version {{.Version}} réussie",
+	},
+}
+
+// messageData is what message templates can refer to
+type messageData struct {
+	Image    string
+	Duration string
+	Done     int
+	Total    int
+	Version  string
+}
+
+// messages are the templates of the selected locale with the user's overrides applied
+var messages, _ = parseMessages(messageCatalog["en"])
+
+// setupMessages selects the locale, "" picks it from the environment, and reads
+// custom templates from extractrr/messages.json in the user config directory
+func setupMessages(locale string) error {
+	if locale == "" {
+		locale = environmentLocale()
+	}
+	if _, ok := messageCatalog[locale]; !ok {
+		locale = "en"
+	}
+
+	templates := make(map[string]string)
+	for id, text := range messageCatalog["en"] {
+		templates[id] = text
+	}
+	for id, text := range messageCatalog[locale] {
+		templates[id] = text
+	}
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		data, err := os.ReadFile(filepath.Join(dir, "extractrr", "messages.json"))
+		switch {
+		case err == nil:
+			var custom map[string]string
+			if err := json.Unmarshal(data, &custom); err != nil {
+				return fmt.Errorf("invalid messages.json: %w", err)
+			}
+			for id, text := range custom {
+				if _, ok := templates[id]; !ok {
+					return fmt.Errorf("invalid messages.json: unknown message %q", id)
+				}
+				templates[id] = text
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return fmt.Errorf("failed to read messages.json: %w", err)
+		}
+	}
+
+	parsed, err := parseMessages(templates)
+	if err != nil {
+		return err
+	}
+	messages = parsed
+
+	return nil
+}
+
+// environmentLocale returns the language of LC_ALL, LC_MESSAGES or LANG, e.g. de for de_DE.UTF-8
+func environmentLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			lang, _, _ := strings.Cut(value, "_")
+			lang, _, _ = strings.Cut(lang, ".")
+			return strings.ToLower(lang)
+		}
+	}
+	return ""
+}
+
+func parseMessages(templates map[string]string) (map[string]*template.Template, error) {
+	parsed := make(map[string]*template.Template, len(templates))
+	for id, text := range templates {
+		t, err := template.New(id).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for message %s: %w", id, err)
+		}
+		parsed[id] = t
+	}
+	return parsed, nil
+}
+
+// msg renders the message id with data
+func msg(id string, data messageData) string {
+	var b strings.Builder
+	if err := messages[id].Execute(&b, data); err != nil {
+		return id
+	}
+	return b.String()
+}
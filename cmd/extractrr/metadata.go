@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Disc types detected from the image layout
+const (
+	DiscTypeBluray = "BD"
+	DiscTypeDVD    = "DVD"
+	DiscTypeData   = "data"
+)
+
+// ImageMetadata describes a scanned image and is exposed as placeholders
+// in destination templates, e.g. /media/bluray/{{.VolumeLabel}}
+type ImageMetadata struct {
+	ImagePath   string
+	ImageName   string
+	ImageMtime  time.Time
+	VolumeLabel string
+	DiscType    string
+	// UHD and Stereo3D are set for Ultra HD and 3D Blu-rays
+	UHD       bool
+	Stereo3D  bool
+	TotalSize int64
+	FileCount int
+}
+
+// readImageMetadata collects the metadata of an opened image. totalSize and
+// fileCount come from the scan since libudfread has no cheap way to get them.
+func readImageMetadata(image SourceBackend, isoFile string, totalSize int64, fileCount int) (ImageMetadata, error) {
+	f, err := openSourceFile(isoFile)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	f.Close()
+
+	baseName := imageBaseName(isoFile)
+	meta := ImageMetadata{
+		ImagePath:  isoFile,
+		ImageName:  strings.TrimSuffix(baseName, filepath.Ext(baseName)),
+		ImageMtime: f.ModTime(),
+		TotalSize:  totalSize,
+		FileCount:  fileCount,
+	}
+
+	meta.VolumeLabel = image.VolumeLabel()
+	// Labels end up in paths, so never let them introduce separators or an empty component
+	meta.VolumeLabel = strings.ReplaceAll(meta.VolumeLabel, "/", "_")
+	if meta.VolumeLabel == "" || meta.VolumeLabel == "." || meta.VolumeLabel == ".." {
+		meta.VolumeLabel = meta.ImageName
+	}
+
+	meta.DiscType, err = detectDiscType(image)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	if meta.DiscType == DiscTypeBluray {
+		layout := readBlurayLayout(image)
+		meta.UHD, meta.Stereo3D = layout.UHD, layout.Stereo3D
+	}
+
+	return meta, nil
+}
+
+// detectDiscType looks at the top-level directories of the image
+func detectDiscType(image SourceBackend) (string, error) {
+	entries, err := readDir(image, "/")
+	if err != nil {
+		return "", err
+	}
+
+	discType := DiscTypeData
+	for _, entry := range entries {
+		if !entry.IsDir {
+			continue
+		}
+
+		switch strings.ToUpper(entry.Name) {
+		case "BDMV":
+			return DiscTypeBluray, nil
+		case "VIDEO_TS":
+			discType = DiscTypeDVD
+		}
+	}
+
+	return discType, nil
+}
+
+// isDestinationTemplate reports whether the destination contains placeholders
+func isDestinationTemplate(dest string) bool {
+	return strings.Contains(dest, "{{")
+}
+
+// parseDestinationTemplate parses a destination template so errors surface before any image is opened
+func parseDestinationTemplate(dest string) (*template.Template, error) {
+	tmpl, err := template.New("destination").Option("missingkey=error").Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination template: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// renderDestination executes a destination template for an image
+func renderDestination(tmpl *template.Template, meta ImageMetadata) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, meta); err != nil {
+		return "", fmt.Errorf("failed to render destination: %w", err)
+	}
+
+	dest := filepath.Clean(buf.String())
+	if dest == "." {
+		return "", fmt.Errorf("destination template rendered to an empty path")
+	}
+
+	return dest, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+// How mkimage arranges the files of an image
+const (
+	// ShapeFlat puts every file in the root directory
+	ShapeFlat = "flat"
+	// ShapeTree fills directories with --fanout files and --fanout subdirectories each
+	ShapeTree = "tree"
+	// ShapeDeep nests every directory in the previous one with --fanout files each
+	ShapeDeep = "deep"
+)
+
+var imageShapes = []string{ShapeFlat, ShapeTree, ShapeDeep}
+
+func validateImageShape(shape string) error {
+	if !slices.Contains(imageShapes, shape) {
+		return fmt.Errorf("invalid shape %q: must be one of %s", shape, strings.Join(imageShapes, ", "))
+	}
+	return nil
+}
+
+// How mkimage divides --size between the files
+const (
+	SizesEqual = "equal"
+	SizesMixed = "mixed"
+)
+
+var imageSizes = []string{SizesEqual, SizesMixed}
+
+func validateImageSizes(sizes string) error {
+	if !slices.Contains(imageSizes, sizes) {
+		return fmt.Errorf("invalid sizes %q: must be one of %s", sizes, strings.Join(imageSizes, ", "))
+	}
+	return nil
+}
+
+// What mkimage fills the files with
+const (
+	// ContentPattern records the number in the file name and the offset every 16 bytes,
+	// so every block of the image is unique and a misplaced one can be told from the data
+	ContentPattern = "pattern"
+	// ContentRandom is incompressible data from --seed
+	ContentRandom = "random"
+	// ContentZero is the fastest to write and compresses to nothing
+	ContentZero = "zero"
+)
+
+var imageContents = []string{ContentPattern, ContentRandom, ContentZero}
+
+func validateImageContent(content string) error {
+	if !slices.Contains(imageContents, content) {
+		return fmt.Errorf("invalid content %q: must be one of %s", content, strings.Join(imageContents, ", "))
+	}
+	return nil
+}
+
+// imageSpec describes a synthetic image
+type imageSpec struct {
+	Files   int
+	Size    int64
+	Shape   string
+	Fanout  int
+	Sizes   string
+	Content string
+	Seed    uint64
+}
+
+// planImage returns the tree of a synthetic image, the root first. Directories are
+// numbered within their parent and files across the image, so every file name is unique.
+func planImage(spec imageSpec) []udfNode {
+	perDir := spec.Fanout
+	if spec.Shape == ShapeFlat {
+		perDir = max(spec.Files, 1)
+	}
+	dirs := max((spec.Files+perDir-1)/perDir, 1)
+
+	nodes := []udfNode{{Dir: true}}
+	dirNodes := []int{0}
+	childDirs := make(map[int]int)
+	for i := 1; i < dirs; i++ {
+		parent := i - 1
+		if spec.Shape == ShapeTree {
+			parent = (i - 1) / spec.Fanout
+		}
+		parentNode := dirNodes[parent]
+		nodes = append(nodes, udfNode{Name: fmt.Sprintf("dir%02d", childDirs[parentNode]), Dir: true, Parent: parentNode})
+		childDirs[parentNode]++
+		dirNodes = append(dirNodes, len(nodes)-1)
+	}
+
+	sizes := fileSizes(spec)
+	for i := range spec.Files {
+		nodes = append(nodes, udfNode{Name: fmt.Sprintf("file%06d.bin", i), Parent: dirNodes[i/perDir], Size: sizes[i]})
+	}
+
+	return nodes
+}
+
+// fileSizes divides the total size between the files, whole blocks each where possible
+func fileSizes(spec imageSpec) []int64 {
+	sizes := make([]int64, spec.Files)
+	if spec.Files == 0 {
+		return sizes
+	}
+
+	weights := make([]float64, spec.Files)
+	var sum float64
+	r := rand.New(rand.NewPCG(spec.Seed, 0))
+	for i := range weights {
+		weights[i] = 1
+		if spec.Sizes == SizesMixed {
+			// Mostly small files with a few large ones, like the discs this is for
+			weights[i] = r.ExpFloat64()
+		}
+		sum += weights[i]
+	}
+
+	var assigned int64
+	for i, weight := range weights {
+		size := int64(float64(spec.Size) * weight / sum)
+		if size > udfBlockSize {
+			size -= size % udfBlockSize
+		}
+		sizes[i] = size
+		assigned += size
+	}
+	sizes[len(sizes)-1] += spec.Size - assigned
+
+	return sizes
+}
+
+// imageFill returns what fills the files of spec, first is the node of the first file
+func imageFill(spec imageSpec, first int) udfFillFunc {
+	switch spec.Content {
+	case ContentZero:
+		return func(node int, offset int64, buf []byte) {
+			clear(buf)
+		}
+	case ContentRandom:
+		var r *rand.ChaCha8
+		current := -1
+		return func(node int, offset int64, buf []byte) {
+			// Files are filled in order from their start, so one stream per file is enough
+			if node != current {
+				var seed [32]byte
+				binary.LittleEndian.PutUint64(seed[:], spec.Seed)
+				binary.LittleEndian.PutUint64(seed[8:], uint64(node-first))
+				r, current = rand.NewChaCha8(seed), node
+			}
+			r.Read(buf)
+		}
+	default:
+		return func(node int, offset int64, buf []byte) {
+			var record [16]byte
+			binary.LittleEndian.PutUint64(record[:], uint64(node-first))
+			for i := 0; i < len(buf); {
+				pos := offset + int64(i)
+				binary.LittleEndian.PutUint64(record[8:], uint64(pos-pos%16))
+				i += copy(buf[i:], record[pos%16:])
+			}
+		}
+	}
+}
+
+func CommandMkimage() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "mkimage",
+		Short: "Write a synthetic UDF image for testing and benchmarking",
+		Long: `Write a synthetic UDF image for testing and benchmarking
+
+Generates a UDF 1.02 image with the given number of files and total size, without needing
+a disc or mastering tools. The tree shape, how the size is divided and the file content
+are configurable, and the same options and seed always give the same files, so the result
+of an extraction can be checked against a second image or the recorded pattern.
+
+Only UDF is written, there is no ISO9660 bridge: readers that only understand ISO9660
+see an empty disc. extractrr itself reads the images through UDF.`,
+		Example: `  extractrr mkimage --files 1000 --size 10G out.iso
+  extractrr mkimage --files 50000 --size 2GiB --shape deep --sizes mixed small-files.iso
+  extractrr mkimage --files 4 --size 40G --content random --shape flat large-files.iso`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("requires an output path")
+			}
+			return nil
+		},
+	}
+
+	var (
+		files        = command.Flags().Int("files", 100, "Number of files")
+		size         = command.Flags().String("size", "1GiB", "Total size of the files, e.g. 10G")
+		shape        = command.Flags().String("shape", ShapeTree, "Directory layout: "+strings.Join(imageShapes, ", "))
+		fanout       = command.Flags().Int("fanout", 10, "Files per directory, and subdirectories per directory for the tree shape")
+		sizes        = command.Flags().String("sizes", SizesEqual, "How the size is divided between the files: "+strings.Join(imageSizes, ", "))
+		content      = command.Flags().String("content", ContentPattern, "File content: "+strings.Join(imageContents, ", "))
+		seed         = command.Flags().Uint64("seed", 1, "Seed for mixed sizes and random content")
+		label        = command.Flags().String("label", "EXTRACTRR", "Volume label")
+		force        = command.Flags().Bool("force", false, "Overwrite the output if it exists")
+		showProgress = command.Flags().Bool("progress", true, "Show progress bar")
+	)
+
+	command.RunE = func(cmd *cobra.Command, args []string) error {
+		totalSize, err := humanize.ParseBytes(*size)
+		if err != nil {
+			return fmt.Errorf("invalid --size: %w", err)
+		}
+		if *files < 0 {
+			return fmt.Errorf("--files must not be negative")
+		}
+		if *fanout < 1 {
+			return fmt.Errorf("--fanout must be at least 1")
+		}
+		if err := validateImageShape(*shape); err != nil {
+			return err
+		}
+		if err := validateImageSizes(*sizes); err != nil {
+			return err
+		}
+		if err := validateImageContent(*content); err != nil {
+			return err
+		}
+		if *files == 0 && totalSize > 0 {
+			return fmt.Errorf("--size needs at least one file")
+		}
+
+		spec := imageSpec{Files: *files, Size: int64(totalSize), Shape: *shape, Fanout: *fanout, Sizes: *sizes, Content: *content, Seed: *seed}
+		nodes := planImage(spec)
+		layout, err := layoutUDF(nodes)
+		if err != nil {
+			return err
+		}
+
+		flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+		if *force {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(args[0], flags, 0666)
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Writing image", "file", args[0], "files", layout.files, "dirs", layout.dirs, "bytes", layout.size())
+		startTime := time.Now()
+
+		var out io.Writer = f
+		var bar *pb.ProgressBar
+		if *showProgress {
+			bar = pb.Full.Start64(layout.size())
+			bar.Set(pb.Bytes, true)
+			out = bar.NewProxyWriter(f)
+		}
+
+		err = writeUDF(out, layout, *label, startTime, imageFill(spec, len(nodes)-spec.Files))
+		if bar != nil {
+			bar.Finish()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(args[0])
+			return fmt.Errorf("failed to write %s: %w", args[0], err)
+		}
+
+		slog.Info("Image written", "file", args[0], "bytes", layout.size(), "duration", time.Since(startTime).Round(time.Millisecond))
+
+		return nil
+	}
+
+	return command
+}
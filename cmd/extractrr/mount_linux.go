@@ -0,0 +1,59 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// discFilesystems are the mount types a disc image can be mounted as
+var discFilesystems = map[string]bool{"udf": true, "iso9660": true}
+
+// mountDevice returns the source device of the disc mounted at dir, from /proc/self/mountinfo
+func mountDevice(dir string) (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// Later mounts hide earlier ones on the same directory, so the last match wins
+	device := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// id parent major:minor root mountpoint options [optional...] - fstype source superoptions
+		before, after, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+		fields, tail := strings.Fields(before), strings.Fields(after)
+		if len(fields) < 5 || len(tail) < 2 {
+			continue
+		}
+		if unescapeMountPath(fields[4]) != dir {
+			continue
+		}
+
+		device = ""
+		if discFilesystems[tail[0]] {
+			device = unescapeMountPath(tail[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if device == "" {
+		return "", fmt.Errorf("no disc mounted at %s", dir)
+	}
+
+	return device, nil
+}
+
+// unescapeMountPath decodes the octal escapes mountinfo uses for spaces, tabs, newlines and backslashes
+func unescapeMountPath(s string) string {
+	r := strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+	return r.Replace(s)
+}
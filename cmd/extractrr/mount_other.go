@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// mountDevice is not implemented on this platform, mounted discs have to be given by their device
+func mountDevice(dir string) (string, error) {
+	return "", errors.ErrUnsupported
+}
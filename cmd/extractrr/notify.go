@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// notifyExtraction shows a desktop notification summarizing a finished run.
+// Failing to notify is only logged, it never fails the extraction.
+func notifyExtraction(source string, images, failed int, startTime time.Time) {
+	title := msg(MsgNotifyCompleteTitle, messageData{})
+	if failed > 0 {
+		title = msg(MsgNotifyFailedTitle, messageData{})
+	}
+
+	data := messageData{Image: imageBaseName(source), Duration: time.Since(startTime).Round(time.Second).String(), Done: images - failed, Total: images}
+	message := msg(MsgNotifyFinished, data)
+	switch {
+	case images > 1:
+		message = msg(MsgNotifyBatch, data)
+	case failed > 0:
+		message = msg(MsgNotifyFailed, data)
+	}
+
+	if err := notifyDesktop(title, message); err != nil {
+		slog.Warn("Failed to show desktop notification", "error", err)
+	}
+}
+
+// notifyDesktop uses notify-send on Linux and the BSDs and osascript on macOS
+func notifyDesktop(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := "display notification " + strconv.Quote(message) + " with title " + strconv.Quote(title)
+		cmd = exec.Command("osascript", "-e", script)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=extractrr", title, message)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd.Path, err, out)
+	}
+
+	return nil
+}
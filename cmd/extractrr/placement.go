@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/dustin/go-humanize"
+)
+
+// placement is the destination planned for an image of a batch
+type placement struct {
+	Image string
+	Size  int64
+	// Dest is empty if the image fits none of the destinations
+	Dest string
+}
+
+// destSpace is the free space of a destination and what the plan puts there
+type destSpace struct {
+	Dir     string
+	Free    int64
+	Planned int64
+}
+
+// room returns what the destination can still take while keeping --min-free
+func (d destSpace) room(minFree int64) int64 {
+	return d.Free - d.Planned - minFree
+}
+
+// planPlacement scans the images of a batch and assigns each, in batch order, to the
+// destination with the most room left, so a batch spread over several disks doesn't fail
+// halfway through on the first one that fills up. Nested images are counted by their size,
+// not by what --recurse-images extracts from them.
+func planPlacement(images, destinations []string, opts ExtractOptions) ([]placement, []destSpace, error) {
+	spaces := make([]destSpace, len(destinations))
+	for i, dest := range destinations {
+		dir, err := existingParent(dest)
+		if err != nil {
+			return nil, nil, err
+		}
+		free, err := diskFree(dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get free space of %s: %w", dest, err)
+		}
+		spaces[i] = destSpace{Dir: dest, Free: free}
+	}
+
+	filter, err := newPathFilter(opts.filterOptions())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan := make([]placement, len(images))
+	for i, image := range images {
+		_, size, err := scanImageJobs(&imageSource{Path: image}, opts.Strip, filter)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan %s: %w", image, err)
+		}
+		plan[i] = placement{Image: image, Size: size}
+
+		best := -1
+		for j, space := range spaces {
+			if space.room(opts.MinFree) >= size && (best < 0 || space.room(opts.MinFree) > spaces[best].room(opts.MinFree)) {
+				best = j
+			}
+		}
+		if best < 0 {
+			continue
+		}
+		plan[i].Dest = spaces[best].Dir
+		spaces[best].Planned += size
+	}
+
+	return plan, spaces, nil
+}
+
+// unplaced returns the images of a plan that fit none of the destinations
+func unplaced(plan []placement) []placement {
+	var missing []placement
+	for _, p := range plan {
+		if p.Dest == "" {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// checkPlacement logs the plan and fails if an image doesn't fit anywhere
+func checkPlacement(plan []placement) error {
+	missing := unplaced(plan)
+	for _, p := range missing {
+		slog.Error("Image fits none of the destinations", "iso", p.Image, "bytes", p.Size)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d of %d images fit none of the destinations", len(missing), len(plan))
+	}
+
+	for _, p := range plan {
+		slog.Info("Planned destination", "iso", p.Image, "dest", p.Dest, "bytes", p.Size)
+	}
+	return nil
+}
+
+// printPlacement prints which image goes where and how full each destination gets
+func printPlacement(w io.Writer, plan []placement, spaces []destSpace) error {
+	bw := bufio.NewWriter(w)
+
+	for _, p := range plan {
+		dest := p.Dest
+		if dest == "" {
+			dest = "(does not fit)"
+		}
+		fmt.Fprintf(bw, "%s -> %s (%s)\n", p.Image, dest, humanize.IBytes(uint64(p.Size)))
+	}
+	for _, space := range spaces {
+		fmt.Fprintf(bw, "%s: %s planned, %s free\n", space.Dir, humanize.IBytes(uint64(space.Planned)), humanize.IBytes(uint64(space.Free)))
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,460 @@
+package main
+
+/*
+#include <stdlib.h>
+#include <udfread/udfread.h>
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/pflag"
+)
+
+// defaultBufferSize is the per-worker read buffer used unless --buffer is given
+const defaultBufferSize = 1024 * 1024
+
+// byteSize is an int flag value that also accepts sizes with units like 16MiB
+type byteSize int
+
+func (b *byteSize) String() string { return strconv.Itoa(int(*b)) }
+func (b *byteSize) Type() string   { return "size" }
+
+func (b *byteSize) Set(s string) error {
+	n, err := humanize.ParseBytes(s)
+	if err != nil {
+		return err
+	}
+	if n == 0 || n > 1<<30 {
+		return fmt.Errorf("must be between 1 byte and 1GiB")
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+// bufferFlag registers the --buffer flag on flags
+func bufferFlag(flags *pflag.FlagSet, usage string) *int {
+	size := defaultBufferSize
+	flags.Var((*byteSize)(&size), "buffer", usage+", e.g. 16MiB (rounded up to the UDF block size)")
+	return &size
+}
+
+// alignBufferSize rounds size up to whole UDF blocks. Block aligned requests let
+// libudfread read straight into the buffer, and large ones are served as few big
+// reads from the underlying source, which matters most on network mounts.
+func alignBufferSize(size int) int {
+	blocks := max((size+C.UDF_BLOCK_SIZE-1)/C.UDF_BLOCK_SIZE, 1)
+	return blocks * C.UDF_BLOCK_SIZE
+}
+
+// jobFunc processes a single job using the worker's own image handle and buffer. ctx is
+// cancelled once the worker was given up on, the job should then stop as soon as it can.
+type jobFunc func(ctx context.Context, image SourceBackend, job Job, buffer []byte, progressChan chan<- int64) fileResult
+
+// poolOptions configure a worker pool
+type poolOptions struct {
+	Workers      int
+	BufferSize   int
+	ShowProgress bool
+	// DirWriters limits how many jobs with the same destination directory run at once
+	DirWriters int
+	Stuck      stuckWatch
+	// HungAfter is how long a worker may go without progress on its file before it is
+	// replaced and the file requeued, 0 disables the watchdog
+	HungAfter time.Duration
+	// AutoScale adjusts how many of the workers are active to the throughput, see autoscale.go
+	AutoScale bool
+	// Processed, if set, also counts the processed bytes, e.g. for a whole batch
+	Processed *atomic.Int64
+	// Bar, if set, is drawn by a shared display and used instead of a bar of its own
+	Bar *pb.ProgressBar
+	// Done, if set, is called by the worker with every result the pool keeps
+	Done func(worker int, job Job, result fileResult)
+	// Abort, if set, is asked about every result the pool keeps and ends the run with the
+	// error it returns
+	Abort func(result fileResult) error
+}
+
+// runPool runs fn for every job with a pool of workers sharing a progress bar over totalSize.
+// Each worker opens its own handle for src since libudfread handles are not safe for
+// concurrent use. Results are returned in job order, the error is only set if the run
+// was aborted before every job got its turn.
+func runPool(logger *slog.Logger, src *imageSource, jobs []Job, totalSize int64, opts poolOptions, fn jobFunc) ([]fileResult, error) {
+	// Setup progress bar if enabled
+	bar := opts.Bar
+	if bar == nil && opts.ShowProgress {
+		bar = pb.Full.Start64(totalSize)
+		bar.Set(pb.Bytes, true)
+	}
+
+	state := newPoolState(logger, jobs, bar, opts.DirWriters)
+	state.counter = opts.Processed
+	scaling := opts.AutoScale && opts.Workers > 1
+	if scaling {
+		state.activeLimit = initialWorkers(opts.Workers)
+	}
+
+	state.run = func(id int, ctx context.Context) {
+		defer state.exit(id)
+
+		// Each worker gets its own UDF handle to avoid concurrency issues
+		workerImage, err := openBackend(src)
+		if err != nil {
+			logger.Error("Worker failed to open image", "worker", id, "error", err)
+			return
+		}
+		defer workerImage.Close()
+
+		buffer := make([]byte, alignBufferSize(opts.BufferSize))
+
+		// Progress goes through the worker's own channel so it can be attributed to it
+		progressChan := make(chan int64)
+		defer close(progressChan)
+		go state.forward(id, progressChan)
+
+		for {
+			idx, ok := state.next(id)
+			if !ok {
+				return
+			}
+			result := fn(ctx, workerImage, jobs[idx], buffer, progressChan)
+			if !state.finish(id, result) {
+				continue
+			}
+			if opts.Done != nil {
+				opts.Done(id, jobs[idx], result)
+			}
+			if opts.Abort != nil {
+				if err := opts.Abort(result); err != nil {
+					state.abort(err)
+				}
+			}
+		}
+	}
+
+	// Start worker goroutines
+	state.mu.Lock()
+	for i := 0; i < opts.Workers; i++ {
+		state.spawnLocked()
+	}
+	state.mu.Unlock()
+
+	done := make(chan struct{})
+	go state.watch(opts.Stuck, opts.HungAfter, done)
+	go state.stats(done)
+	if scaling {
+		go state.scale(opts.Workers, done)
+	}
+
+	// Wait for all workers to complete
+	state.wg.Wait()
+	close(done)
+
+	if bar != nil {
+		bar.SetCurrent(totalSize)
+		bar.Finish()
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if len(state.incidents) > 0 {
+		logger.Warn("Replaced hung workers during the run", "incidents", len(state.incidents), "files", state.incidents)
+	}
+	if scaling {
+		logger.Info("Finished with active workers", "workers", state.activeLimit)
+	}
+
+	return state.results, state.aborted
+}
+
+// workerState is what a pool worker is currently doing
+type workerState struct {
+	job          int
+	file         string
+	lastProgress time.Time
+	busy         bool
+	cancel       context.CancelFunc
+	// bytes and size are how far the worker is into its file, of how much
+	bytes int64
+	size  int64
+	// rate is the throughput between the last two samples, once measured
+	rate      float64
+	measured  bool
+	sampled   int64
+	sampledAt time.Time
+	// abandoned workers are stuck in a read or write that can't be interrupted,
+	// they are no longer waited for and whatever they do later is discarded
+	abandoned bool
+}
+
+// poolState hands out jobs to workers and tracks their progress and results
+type poolState struct {
+	logger *slog.Logger
+	jobs   []Job
+	bar    *pb.ProgressBar
+	// counter is poolOptions.Processed
+	counter *atomic.Int64
+
+	// dirLimit caps the running jobs per destination directory, 0 for no limit
+	dirLimit int
+	// activeLimit caps the busy workers while autoscaling, 0 for no limit
+	activeLimit int
+
+	// run is the body of a worker goroutine
+	run func(id int, ctx context.Context)
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	nextWorker   int
+	queue        []int
+	attempts     []int
+	incidents    []string
+	dirActive    map[string]int
+	workers      map[int]*workerState
+	results      []fileResult
+	processed    int64
+	lastProgress time.Time
+	stuck        bool
+	aborted      error
+	wg           sync.WaitGroup
+}
+
+func newPoolState(logger *slog.Logger, jobs []Job, bar *pb.ProgressBar, dirLimit int) *poolState {
+	s := &poolState{
+		logger:       logger,
+		jobs:         jobs,
+		bar:          bar,
+		dirLimit:     dirLimit,
+		queue:        make([]int, len(jobs)),
+		attempts:     make([]int, len(jobs)),
+		dirActive:    make(map[string]int),
+		workers:      make(map[int]*workerState),
+		results:      make([]fileResult, len(jobs)),
+		lastProgress: time.Now(),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	// Results start out failed so jobs no worker got to are reported
+	for i := range jobs {
+		s.queue[i] = i
+		s.results[i].Err = fmt.Errorf("not processed")
+	}
+
+	return s
+}
+
+// spawnLocked starts a worker with a new id, it calls exit when it stops
+func (s *poolState) spawnLocked() {
+	id := s.nextWorker
+	s.nextWorker++
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.workers[id] = &workerState{cancel: cancel}
+	s.wg.Add(1)
+
+	go s.run(id, ctx)
+}
+
+// abandonLocked gives up on a busy worker: it is no longer waited for, its directory
+// slot is freed and its job is told to stop whenever the stuck call returns. What it
+// processed of the file no longer counts, the file is extracted again or fails.
+func (s *poolState) abandonLocked(w *workerState) {
+	w.abandoned = true
+	w.cancel()
+	s.doneLocked(w)
+	s.wg.Done()
+
+	s.processed -= w.bytes
+	if s.counter != nil {
+		s.counter.Add(-w.bytes)
+	}
+	if s.bar != nil {
+		s.bar.SetCurrent(s.processed)
+	}
+}
+
+// liveLocked returns how many workers are running, abandoned ones stay registered until
+// their stuck call returns but don't count
+func (s *poolState) liveLocked() int {
+	live := 0
+	for _, w := range s.workers {
+		if !w.abandoned {
+			live++
+		}
+	}
+	return live
+}
+
+// exit unregisters a worker. Abandoned workers were already let go of.
+func (s *poolState) exit(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.workers[id]
+	if w == nil {
+		return
+	}
+	delete(s.workers, id)
+	if !w.abandoned {
+		w.cancel()
+		s.wg.Done()
+	}
+}
+
+// next returns the next job for the worker, false once there are none left. Jobs whose
+// destination directory already has dirLimit jobs running are passed over, the worker
+// only waits if every queued job is held back that way.
+func (s *poolState) next(id int) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.workers[id]
+	for {
+		if len(s.queue) == 0 || s.aborted != nil || w.abandoned {
+			return 0, false
+		}
+		if s.activeLimit > 0 && s.busyLocked() >= s.activeLimit {
+			s.cond.Wait()
+			continue
+		}
+
+		for i, idx := range s.queue {
+			dir := filepath.Dir(s.jobs[idx].DstPath)
+			if s.dirLimit > 0 && s.dirActive[dir] >= s.dirLimit {
+				continue
+			}
+
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			s.dirActive[dir]++
+			w.job, w.file, w.busy, w.lastProgress = idx, s.jobs[idx].SrcPath, true, time.Now()
+			w.bytes, w.size, w.sampled, w.sampledAt, w.measured = 0, s.jobs[idx].Size, 0, w.lastProgress, false
+			return idx, true
+		}
+
+		s.cond.Wait()
+	}
+}
+
+// doneLocked frees the directory slot of the worker's current job
+func (s *poolState) doneLocked(w *workerState) {
+	w.busy = false
+	dir := filepath.Dir(s.jobs[w.job].DstPath)
+	if s.dirActive[dir]--; s.dirActive[dir] <= 0 {
+		delete(s.dirActive, dir)
+	}
+	s.cond.Broadcast()
+}
+
+// finish records the result of the worker's current job, it is dropped if the worker was
+// abandoned in the meantime
+func (s *poolState) finish(id int, result fileResult) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.workers[id]
+	if w.abandoned {
+		return false
+	}
+	s.results[w.job] = result
+	s.doneLocked(w)
+	return true
+}
+
+// progress adds n processed bytes for the worker, updates of abandoned workers are dropped
+func (s *poolState) progress(id int, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := s.workers[id]
+	if w == nil || w.abandoned {
+		return
+	}
+
+	now := time.Now()
+	w.lastProgress, s.lastProgress = now, now
+	w.bytes += n
+	s.processed += n
+	if s.counter != nil {
+		s.counter.Add(n)
+	}
+	if s.stuck {
+		s.stuck = false
+		s.logger.Info("Throughput recovered")
+	}
+	if s.bar != nil {
+		s.bar.SetCurrent(s.processed)
+	}
+}
+
+// forward passes a worker's progress on to the pool until the worker closes the channel.
+// It keeps draining for abandoned workers so that once their stuck read or write returns
+// they can run to the end of the file and release what they hold.
+func (s *poolState) forward(id int, progressChan <-chan int64) {
+	for n := range progressChan {
+		s.progress(id, n)
+	}
+}
+
+// scanImageJobs opens src and scans it for the read-only commands, returning jobs with
+// destination paths relative to the extraction root
+func scanImageJobs(src *imageSource, strip int, filter *pathFilter) ([]Job, int64, error) {
+	image, err := openBackend(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer image.Close()
+
+	scan := &scanResult{}
+	if err := scanISOStructure(image, "/", "", strip, filter, scan); err != nil {
+		return nil, 0, fmt.Errorf("failed to scan ISO: %w", err)
+	}
+
+	return scan.Jobs, scan.TotalSize, nil
+}
+
+// readImageFile reads a file from the image in chunks of the buffer size, passing each to fn.
+// It fails if fewer bytes than the recorded file size could be read.
+func readImageFile(image SourceBackend, srcPath string, buffer []byte, fn func(chunk []byte) error) (int64, error) {
+	file, err := image.OpenFile(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	size := file.Size()
+
+	var total int64
+	for {
+		bytesRead, err := file.Read(buffer)
+		if bytesRead > 0 {
+			if err := fn(buffer[:bytesRead]); err != nil {
+				return total, err
+			}
+			total += int64(bytesRead)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+
+	if total != size {
+		return total, fmt.Errorf("short read on %s: got %d of %d bytes", srcPath, total, size)
+	}
+
+	return total, nil
+}
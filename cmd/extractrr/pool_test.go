@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// startWorkers registers n workers whose jobs the test hands out itself
+func startWorkers(s *poolState, n int) {
+	s.run = func(int, context.Context) {}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range n {
+		s.spawnLocked()
+	}
+}
+
+func TestPoolStateSkipsFullDirectories(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	jobs := []Job{
+		{SrcPath: "/BDMV/STREAM/00001.m2ts", DstPath: "BDMV/STREAM/00001.m2ts"},
+		{SrcPath: "/BDMV/STREAM/00002.m2ts", DstPath: "BDMV/STREAM/00002.m2ts"},
+		{SrcPath: "/BDMV/index.bdmv", DstPath: "BDMV/index.bdmv"},
+	}
+	s := newPoolState(logger, jobs, nil, 1)
+
+	startWorkers(s, 2)
+	first, _ := s.next(0)
+	second, ok := s.next(1)
+	if !ok || first != 0 || second != 2 {
+		t.Fatalf("got jobs %d and %d, want 0 and 2 since BDMV/STREAM is full", first, second)
+	}
+
+	// Finishing the first stream frees its directory for the second
+	s.finish(0, fileResult{})
+	if idx, ok := s.next(0); !ok || idx != 1 {
+		t.Fatalf("got job %d, want 1", idx)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+// readBuffers hands out the buffers a file is read into and takes them back
+type readBuffers interface {
+	buffer() []byte
+	release(buf []byte)
+}
+
+// bufferPair alternates between two buffers when there is no hash ring to provide them
+type bufferPair struct {
+	free chan []byte
+}
+
+func newBufferPair(a, b []byte) *bufferPair {
+	p := &bufferPair{free: make(chan []byte, 2)}
+	p.free <- a
+	p.free <- b
+	return p
+}
+
+func (p *bufferPair) buffer() []byte {
+	return <-p.free
+}
+
+func (p *bufferPair) release(buf []byte) {
+	p.free <- buf[:cap(buf)]
+}
+
+// readChunk is a buffer filled by the prefetcher, n is what the read returned
+type readChunk struct {
+	buf []byte
+	n   int64
+}
+
+// prefetcher reads a file ahead of the copy position in its own goroutine, so reading the
+// next chunk overlaps with writing the current one instead of the two alternating. It reads
+// into whatever buffers it gets, so it can only be as far ahead as there are buffers.
+type prefetcher struct {
+	buffers readBuffers
+	chunks  chan readChunk
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func startPrefetch(file entryFile, buffers readBuffers) *prefetcher {
+	p := &prefetcher{
+		buffers: buffers,
+		chunks:  make(chan readChunk),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.done)
+		defer close(p.chunks)
+
+		for {
+			buf := buffers.buffer()
+			select {
+			case <-p.stop:
+				buffers.release(buf)
+				return
+			default:
+			}
+
+			// A failed read ends the file like its end does, the copy then sees it came up short
+			read, err := file.Read(buf)
+			n := int64(read)
+			if err != nil && n == 0 {
+				n = -1
+			}
+			select {
+			case p.chunks <- readChunk{buf: buf, n: n}:
+			case <-p.stop:
+				buffers.release(buf)
+				return
+			}
+			if n <= 0 {
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+// next returns the next chunk, one with n <= 0 ends the file
+func (p *prefetcher) next() readChunk {
+	c, ok := <-p.chunks
+	if !ok {
+		return readChunk{}
+	}
+	return c
+}
+
+// close stops reading ahead and waits for the read in flight, so the file can be closed
+func (p *prefetcher) close() {
+	close(p.stop)
+	for c := range p.chunks {
+		p.buffers.release(c.buf)
+	}
+	<-p.done
+}
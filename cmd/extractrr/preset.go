@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// Presets bundle the tuning flags for a kind of destination
+const (
+	PresetAuto    = "auto"
+	PresetHDD     = "hdd"
+	PresetSSD     = "ssd"
+	PresetNVMe    = "nvme"
+	PresetNetwork = "network"
+)
+
+var presetNames = []string{PresetAuto, PresetHDD, PresetSSD, PresetNVMe, PresetNetwork}
+
+func validatePreset(preset string) error {
+	if !slices.Contains(presetNames, preset) {
+		return fmt.Errorf("invalid preset %q: must be one of %s", preset, strings.Join(presetNames, ", "))
+	}
+	return nil
+}
+
+// presets holds the extract flags of every preset by name, like the flags of a job
+var presets = map[string]map[string]string{
+	// Few writers with large buffers keep the heads from seeking between files
+	PresetHDD: {
+		"workers":        "4",
+		"buffer":         "4MiB",
+		"large-strategy": "buffer=16MiB,preallocate",
+	},
+	PresetSSD: {
+		"workers":        "8",
+		"buffer":         "1MiB",
+		"large-strategy": "buffer=8MiB,preallocate",
+	},
+	// Enough writers to fill the queues, large streams skip the page cache
+	PresetNVMe: {
+		"workers":        "16",
+		"buffer":         "512KiB",
+		"large-strategy": "buffer=8MiB,direct",
+	},
+	// Big requests make up for the round trips, fsync makes the server commit large files
+	// before they count as extracted, and latency spikes are normal
+	PresetNetwork: {
+		"workers":         "4",
+		"buffer":          "32MiB",
+		"large-strategy":  "buffer=32MiB,fsync",
+		"stall-threshold": "10s",
+	},
+}
+
+// presetDir returns the directory auto detects the destination from, the static part of a
+// destination template
+func presetDir(dest string) string {
+	if i := strings.Index(dest, "{{"); i >= 0 {
+		return dest[:strings.LastIndexAny(dest[:i], `/\`)+1]
+	}
+	return dest
+}
+
+// detectPreset picks the preset for the destination: network for network filesystems,
+// hdd or ssd and nvme by the disk behind it where the platform tells
+func detectPreset(dest string) (string, error) {
+	dir, err := existingParent(presetDir(dest))
+	if err != nil {
+		return "", err
+	}
+
+	if fs, err := fsType(dir); err == nil && slices.Contains([]string{"smb", "nfs", "fuse"}, fs) {
+		return PresetNetwork, nil
+	}
+
+	return diskKind(dir)
+}
+
+// applyPreset sets the flags of preset that weren't given on the command line, auto first
+// detects the preset from dest
+func applyPreset(flags *pflag.FlagSet, preset, dest string) error {
+	if preset == PresetAuto {
+		detected, err := detectPreset(dest)
+		if err != nil {
+			slog.Warn("Failed to detect the destination type, not using a preset", "dest", dest, "error", err)
+			return nil
+		}
+		slog.Info("Detected destination type", "dest", dest, "preset", detected)
+		preset = detected
+	}
+
+	names := make([]string, 0, len(presets[preset]))
+	for name := range presets[preset] {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var applied []string
+	for _, name := range names {
+		if flags.Changed(name) {
+			continue
+		}
+		value := presets[preset][name]
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("preset %s: --%s: %w", preset, name, err)
+		}
+		applied = append(applied, name+"="+value)
+	}
+	slog.Info("Using preset", "preset", preset, "flags", applied)
+
+	return nil
+}
@@ -0,0 +1,43 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// diskKind tells the preset of the block device dir is on from sysfs: hdd for rotating
+// disks, nvme for NVMe drives and ssd for everything else
+func diskKind(dir string) (string, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(dir, &st); err != nil {
+		return "", err
+	}
+
+	dev, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(st.Dev), unix.Minor(st.Dev)))
+	if err != nil {
+		return "", fmt.Errorf("%s is not on a block device", dir)
+	}
+	// The queue belongs to the disk, not the partition
+	if _, err := os.Stat(filepath.Join(dev, "partition")); err == nil {
+		dev = filepath.Dir(dev)
+	}
+
+	rotational, err := os.ReadFile(filepath.Join(dev, "queue", "rotational"))
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.TrimSpace(string(rotational)) == "1":
+		return PresetHDD, nil
+	case strings.HasPrefix(filepath.Base(dev), "nvme"):
+		return PresetNVMe, nil
+	default:
+		return PresetSSD, nil
+	}
+}
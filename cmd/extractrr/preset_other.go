@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// diskKind is not implemented on this platform, only network filesystems are recognized
+func diskKind(dir string) (string, error) {
+	return "", errors.ErrUnsupported
+}
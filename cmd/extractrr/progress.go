@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/cheggaaa/pb/v3/termutil"
+)
+
+// displayInterval is how often the shared progress display is redrawn
+const displayInterval = 200 * time.Millisecond
+
+// consoleEscapes is whether the terminal moves the cursor on escape sequences, without them
+// the display only redraws the line of the whole batch
+var consoleEscapes = true
+
+// batchDisplay draws the progress of images extracted at once as one block of lines: the
+// whole batch first, then a bar for every running job. The jobs' bars are static, only the
+// display writes to the terminal, so they don't draw over each other.
+type batchDisplay struct {
+	status  *batchStatus
+	overall *pb.ProgressBar
+	out     io.Writer
+	// lines is how many lines the last draw took, they are overwritten by the next
+	lines int
+}
+
+func newBatchDisplay(status *batchStatus) *batchDisplay {
+	status.bars = true
+
+	overall := pb.Full.New(0).SetTotal(status.total)
+	overall.Set(pb.Bytes, true)
+	overall.Set(pb.Static, true)
+	overall.Start()
+
+	return &batchDisplay{status: status, overall: overall, out: os.Stderr}
+}
+
+// run redraws the display until done is closed, then draws it a last time
+func (d *batchDisplay) run(done <-chan struct{}) {
+	ticker := time.NewTicker(displayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			d.overall.Finish()
+			d.draw()
+			if !consoleEscapes {
+				fmt.Fprintln(d.out)
+			}
+			return
+		case <-ticker.C:
+			d.draw()
+		}
+	}
+}
+
+func (d *batchDisplay) draw() {
+	width, err := termutil.TerminalWidth()
+	if err != nil || width <= 0 {
+		width = 100
+	}
+
+	d.overall.SetCurrent(d.status.processed())
+	d.overall.Set("prefix", fmt.Sprintf("batch %d/%d", d.status.done.Load(), d.status.images))
+	if !consoleEscapes {
+		d.overall.SetWidth(width)
+		fmt.Fprintf(d.out, "\r%s", d.overall.String())
+		return
+	}
+
+	bars := []*pb.ProgressBar{d.overall}
+	for _, job := range d.status.jobs() {
+		if job.bar != nil {
+			bars = append(bars, job.bar)
+		}
+	}
+
+	var b strings.Builder
+	if d.lines > 0 {
+		fmt.Fprintf(&b, "\033[%dA", d.lines)
+	}
+	for _, bar := range bars {
+		bar.SetWidth(width)
+		fmt.Fprintf(&b, "\r%s\033[K\n", bar.String())
+	}
+	// Jobs that ended since the last draw leave lines behind
+	b.WriteString("\033[J")
+	d.lines = len(bars)
+
+	io.WriteString(d.out, b.String())
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+)
+
+// destQuota is the soft quota of --quota on what may be written below a destination root.
+// Usage comes from the ledger, so it only counts completed extractions and doesn't notice
+// files removed since, plus what the running images of the batch are about to write.
+type destQuota struct {
+	root   string
+	limit  int64
+	ledger *imageLedger
+
+	mu sync.Mutex
+	// reserved is what running extractions below the root will write
+	reserved int64
+}
+
+func newDestQuota(root string, limit int64, ledger *imageLedger) (*destQuota, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --quota-root: %w", err)
+	}
+	return &destQuota{root: root, limit: limit, ledger: ledger}, nil
+}
+
+// used returns what is written below the root, leaving out the latest extraction into dest
+// that extracting there again replaces
+func (q *destQuota) used(dest string) int64 {
+	return q.ledger.usedBytes(q.root) - q.ledger.destBytes(dest) + q.reserved
+}
+
+// reserve counts size bytes about to be extracted into dest against the quota, refusing
+// them if it would be exceeded. With --force exceeding it is only logged. The returned
+// func gives the reservation back once the extraction is over and in the ledger.
+func (q *destQuota) reserve(opts ExtractOptions, dest string, size int64) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return nil, err
+	}
+	if !withinRoot(q.root, dest) {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if used := q.used(dest); used+size > q.limit {
+		err := fmt.Errorf("image has %s to extract, which brings %s to %s, more than --quota %s",
+			humanize.IBytes(uint64(size)), q.root, humanize.IBytes(uint64(used+size)), humanize.IBytes(uint64(q.limit)))
+		if !opts.Force {
+			return nil, err
+		}
+		opts.log().Warn("Ignoring quota because of --force", "error", err)
+	}
+
+	q.reserved += size
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.reserved -= size
+	}, nil
+}
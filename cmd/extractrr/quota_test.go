@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDestQuotaUsed(t *testing.T) {
+	dir := t.TempDir()
+	l, err := openLedger(filepath.Join(dir, ledgerName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dir, "media")
+	entries := []struct {
+		dest  string
+		bytes int64
+	}{
+		{filepath.Join(root, "a"), 100},
+		{filepath.Join(root, "b", "c"), 200},
+		// Outside the root, including a sibling sharing its prefix
+		{filepath.Join(dir, "other"), 400},
+		{filepath.Join(dir, "media2"), 800},
+	}
+	for i, e := range entries {
+		source := SidecarSource{Path: e.dest + ".iso", Size: int64(i + 1), Hash: "sha256:" + e.dest}
+		if err := l.record(source, e.dest, e.bytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Extracting into a relative root and destination still ends up below the same paths
+	t.Chdir(dir)
+	q, err := newDestQuota("media", 1000, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		dest string
+		want int64
+	}{
+		{"new destination", filepath.Join(root, "d"), 300},
+		{"replaced destination", filepath.Join(root, "a"), 200},
+		{"nested replaced destination", filepath.Join(root, "b", "c"), 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := q.used(tt.dest); got != tt.want {
+				t.Fatalf("got %d bytes used, want %d", got, tt.want)
+			}
+		})
+	}
+
+	release, err := q.reserve(ExtractOptions{}, "media/d", 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := q.used(filepath.Join(root, "e")); got != 900 {
+		t.Fatalf("got %d bytes used with a reservation, want 900", got)
+	}
+	if _, err := q.reserve(ExtractOptions{}, filepath.Join(root, "e"), 200); err == nil {
+		t.Fatal("reserving past the quota succeeded")
+	}
+	if _, err := q.reserve(ExtractOptions{}, filepath.Join(dir, "other"), 5000); err != nil {
+		t.Fatalf("reserving outside the root failed: %v", err)
+	}
+	release()
+	if got := q.used(filepath.Join(root, "e")); got != 300 {
+		t.Fatalf("got %d bytes used after release, want 300", got)
+	}
+}
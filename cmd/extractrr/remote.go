@@ -0,0 +1,514 @@
+package main
+
+/*
+#include <stdint.h>
+#include <udfread/udfread.h>
+*/
+import "C"
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/cgo"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// remoteChunkSize is the granularity remote sources are fetched and cached in
+const remoteChunkSize = 1024 * 1024
+
+// remoteCacheDir and remoteCacheSize configure the on-disk block cache shared by all remote sources
+var (
+	remoteCacheDir  string
+	remoteCacheSize int64
+)
+
+// remoteRetries is how often a range request is retried without getting any further
+var remoteRetries = 5
+
+// Backoff between retries of a range request, doubling from the first up to the last
+const (
+	remoteRetryDelay    = time.Second
+	remoteRetryDelayMax = 30 * time.Second
+)
+
+// Timeouts of remote connections. A server that accepts the connection and then stalls
+// fails the request instead of hanging the worker, and the read is retried.
+const (
+	remoteDialTimeout           = 30 * time.Second
+	remoteTLSHandshakeTimeout   = 30 * time.Second
+	remoteResponseHeaderTimeout = time.Minute
+)
+
+// remoteRangeTimeout is the deadline of a single range request including its body, what
+// arrived before it ran out is kept and the rest is requested again
+var remoteRangeTimeout = 5 * time.Minute
+
+// errSourceChanged is returned when the remote image changed since it was opened
+var errSourceChanged = errors.New("remote image changed since it was opened")
+
+// isRemotePath reports whether an image path is an http(s) URL
+func isRemotePath(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// remoteReader reads an image over HTTP range requests, backed by the block cache
+type remoteReader struct {
+	url      string
+	size     int64
+	modTime  time.Time
+	client   *http.Client
+	cache    *blockCache
+	cacheKey string
+	// validator is the ETag, or else the Last-Modified date, resumed requests send as
+	// If-Range so they never mix the data of two versions of the image
+	validator string
+}
+
+var (
+	remoteReadersMu sync.Mutex
+	remoteReaders   = make(map[string]*remoteReader)
+)
+
+// openRemote returns the reader for url, shared between all handles of the process
+func openRemote(url string) (*remoteReader, error) {
+	remoteReadersMu.Lock()
+	defer remoteReadersMu.Unlock()
+
+	if r, ok := remoteReaders[url]; ok {
+		return r, nil
+	}
+
+	r := &remoteReader{
+		url:    url,
+		client: newRemoteClient(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteRangeTimeout)
+	defer cancel()
+
+	// Probe with a one byte range request rather than HEAD, presigned S3 URLs are only valid for GET
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", url, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("server does not support range requests for %s", url)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("failed to reach %s: %s", url, resp.Status)
+	}
+
+	// Content-Range looks like "bytes 0-0/12345"
+	contentRange := resp.Header.Get("Content-Range")
+	_, total, ok := strings.Cut(contentRange, "/")
+	if !ok {
+		return nil, fmt.Errorf("server did not report a size for %s", url)
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("server did not report a size for %s", url)
+	}
+
+	r.size = size
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		r.modTime = lastModified
+	}
+	// Weak ETags aren't allowed in If-Range
+	r.validator = resp.Header.Get("Last-Modified")
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		r.validator = etag
+	}
+
+	if remoteCacheSize > 0 {
+		cache, err := openBlockCache(remoteCacheDir, remoteCacheSize)
+		if err != nil {
+			return nil, err
+		}
+
+		// Key the cache on the URL without its query, so freshly presigned URLs still hit it,
+		// and on what the server reports so a changed file isn't served stale
+		base, _, _ := strings.Cut(url, "?")
+		sum := sha256.Sum256([]byte(base + "\x00" + strconv.FormatInt(r.size, 10) + "\x00" + resp.Header.Get("Last-Modified") + "\x00" + resp.Header.Get("ETag")))
+		r.cache = cache
+		r.cacheKey = hex.EncodeToString(sum[:])
+	}
+
+	remoteReaders[url] = r
+
+	return r, nil
+}
+
+// newRemoteClient returns a client that gives up on unresponsive servers
+func newRemoteClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: remoteDialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = remoteTLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = remoteResponseHeaderTimeout
+	return &http.Client{Transport: transport}
+}
+
+// ReadAt implements io.ReaderAt, serving whole chunks from the cache where possible
+func (r *remoteReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.cache == nil {
+		end := min(off+int64(len(p)), r.size)
+		if err := r.fetch(p[:end-off], off); err != nil {
+			return 0, err
+		}
+		if end-off < int64(len(p)) {
+			return int(end - off), io.EOF
+		}
+		return len(p), nil
+	}
+
+	n := 0
+	for n < len(p) && off+int64(n) < r.size {
+		pos := off + int64(n)
+		idx := pos / remoteChunkSize
+
+		chunk, err := r.chunk(idx)
+		if err != nil {
+			return n, err
+		}
+
+		n += copy(p[n:], chunk[pos-idx*remoteChunkSize:])
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Size returns the size of the remote image
+func (r *remoteReader) Size() int64 { return r.size }
+
+// ModTime returns the Last-Modified time reported by the server
+func (r *remoteReader) ModTime() time.Time { return r.modTime }
+
+// Close is a no-op, readers are shared for the lifetime of the process
+func (r *remoteReader) Close() error { return nil }
+
+// chunk returns chunk idx from the cache, fetching and storing it on a miss
+func (r *remoteReader) chunk(idx int64) ([]byte, error) {
+	if data, ok := r.cache.get(r.cacheKey, idx); ok {
+		return data, nil
+	}
+
+	start := idx * remoteChunkSize
+	data := make([]byte, min(remoteChunkSize, r.size-start))
+	if err := r.fetch(data, start); err != nil {
+		return nil, err
+	}
+
+	if err := r.cache.put(r.cacheKey, idx, data); err != nil {
+		slog.Warn("Failed to write block cache", "error", err)
+	}
+
+	return data, nil
+}
+
+// fetch reads exactly len(p) bytes at off with range requests. When the connection drops
+// or the server fails temporarily the request is repeated for what is still missing, up to
+// remoteRetries times in a row without getting any further.
+func (r *remoteReader) fetch(p []byte, off int64) error {
+	got, failures := 0, 0
+	delay := remoteRetryDelay
+	for {
+		n, err := r.fetchRange(p[got:], off+int64(got), got > 0)
+		if n > 0 {
+			got += n
+			failures, delay = 0, remoteRetryDelay
+		}
+		if err == nil {
+			return nil
+		}
+
+		var permanent permanentError
+		if errors.As(err, &permanent) || failures >= remoteRetries {
+			return err
+		}
+		failures++
+		slog.Warn("Remote read failed, retrying", "url", redactURL(r.url), "offset", off+int64(got), "missing", len(p)-got, "retry", failures, "delay", delay, "error", err)
+		time.Sleep(delay)
+		delay = min(delay*2, remoteRetryDelayMax)
+	}
+}
+
+// permanentError is a failed range request that retrying won't help
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// fetchRange reads p at off with a single range request, returning how much arrived before
+// it failed. Resumed requests check that the image is still the one that was opened.
+func (r *remoteReader) fetchRange(p []byte, off int64, resumed bool) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteRangeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return 0, permanentError{err}
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+	if resumed && r.validator != "" {
+		req.Header.Set("If-Range", r.validator)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && resumed && r.validator != "":
+		// If-Range didn't match, the server sends the whole new version instead
+		return 0, permanentError{errSourceChanged}
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return 0, fmt.Errorf("unexpected response for range request: %s", resp.Status)
+	default:
+		return 0, permanentError{fmt.Errorf("unexpected response for range request: %s", resp.Status)}
+	}
+
+	return io.ReadFull(resp.Body, p)
+}
+
+// redactURL drops the query of a URL for the log, presigned URLs carry credentials in it
+func redactURL(url string) string {
+	base, _, _ := strings.Cut(url, "?")
+	return base
+}
+
+// remoteBlockInput is what a libudfread block input handle points to. Besides http(s)
+// sources it serves local images while --debug-io traces block reads.
+type remoteBlockInput struct {
+	reader sourceFile
+	name   string
+}
+
+//export goRemoteRead
+func goRemoteRead(handle C.uintptr_t, lba C.uint32_t, buf unsafe.Pointer, nblocks C.uint32_t) C.int {
+	in := cgo.Handle(handle).Value().(*remoteBlockInput)
+
+	start := time.Now()
+	p := unsafe.Slice((*byte)(buf), int(nblocks)*C.UDF_BLOCK_SIZE)
+	n, err := in.reader.ReadAt(p, int64(lba)*C.UDF_BLOCK_SIZE)
+	if err != nil && !errors.Is(err, io.EOF) {
+		if ioTrace != nil {
+			traceIO("read_blocks", start, -1, "iso", in.name, "lba", int64(lba), "nblocks", int64(nblocks), "error", err)
+		}
+		slog.Error("Failed to read image", "iso", in.name, "lba", int64(lba), "error", err)
+		return -1
+	}
+
+	if ioTrace != nil {
+		traceIO("read_blocks", start, int64(n/C.UDF_BLOCK_SIZE), "iso", in.name, "lba", int64(lba), "nblocks", int64(nblocks))
+	}
+
+	return C.int(n / C.UDF_BLOCK_SIZE)
+}
+
+//export goRemoteSize
+func goRemoteSize(handle C.uintptr_t) C.uint32_t {
+	in := cgo.Handle(handle).Value().(*remoteBlockInput)
+	return C.uint32_t(in.reader.Size() / C.UDF_BLOCK_SIZE)
+}
+
+//export goRemoteClose
+func goRemoteClose(handle C.uintptr_t) {
+	h := cgo.Handle(handle)
+	h.Value().(*remoteBlockInput).reader.Close()
+	h.Delete()
+}
+
+// blockCache stores fixed size chunks of remote images on disk and evicts the
+// least recently used ones once it grows beyond maxSize
+type blockCache struct {
+	dir     string
+	maxSize int64
+
+	mu   sync.Mutex
+	size int64
+}
+
+var (
+	blockCachesMu sync.Mutex
+	blockCaches   = make(map[string]*blockCache)
+)
+
+// openBlockCache returns the cache rooted at dir, defaulting to the user cache directory
+func openBlockCache(dir string, maxSize int64) (*blockCache, error) {
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate cache directory: %w", err)
+		}
+		dir = filepath.Join(userCache, "extractrr", "blocks")
+	}
+
+	blockCachesMu.Lock()
+	defer blockCachesMu.Unlock()
+
+	if c, ok := blockCaches[dir]; ok {
+		return c, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &blockCache{dir: dir, maxSize: maxSize}
+	entries, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		c.size += entry.size
+	}
+
+	blockCaches[dir] = c
+
+	return c, nil
+}
+
+func (c *blockCache) path(key string, idx int64) string {
+	return filepath.Join(c.dir, key, strconv.FormatInt(idx, 10))
+}
+
+func (c *blockCache) get(key string, idx int64) ([]byte, bool) {
+	path := c.path(key, idx)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	// The modification time doubles as access time for eviction
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return data, true
+}
+
+func (c *blockCache) put(key string, idx int64, data []byte) error {
+	path := c.path(key, idx)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Workers missing the same chunk at once each write their own copy, the last rename wins
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A chunk that is already there is replaced, it only counts once
+	if info, err := os.Stat(path); err == nil {
+		c.size -= info.Size()
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.size += int64(len(data))
+	if c.size > c.maxSize {
+		return c.evict()
+	}
+
+	return nil
+}
+
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *blockCache) entries() ([]cacheEntry, error) {
+	var entries []cacheEntry
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, cacheEntry{path: path, size: info.Size(), modTime: info.ModTime()})
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// evict removes the least recently used chunks until the cache is back at 90% of its size
+func (c *blockCache) evict() error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+
+	c.size = 0
+	for _, entry := range entries {
+		c.size += entry.size
+	}
+
+	target := c.maxSize / 10 * 9
+	for _, entry := range entries {
+		if c.size <= target {
+			break
+		}
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		c.size -= entry.size
+	}
+
+	return nil
+}
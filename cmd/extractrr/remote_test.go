@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBlockCacheConcurrentPut(t *testing.T) {
+	dir := t.TempDir()
+	c := &blockCache{dir: dir, maxSize: 1 << 30}
+	data := bytes.Repeat([]byte{7}, 4096)
+
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.put("key", 3, data); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if c.size != int64(len(data)) {
+		t.Errorf("cache counts %d bytes for a single %d byte chunk", c.size, len(data))
+	}
+	if got, ok := c.get("key", 3); !ok || !bytes.Equal(got, data) {
+		t.Errorf("chunk doesn't read back")
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Errorf("temporary file %s was left behind", entry.Name())
+		}
+	}
+}
+
+func TestRemoteFetchResumesStalledRange(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+	// The first request sends half of the range and then stalls until the client gives up
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+
+		body := data[start : end+1]
+		if requests.Add(1) == 1 {
+			w.Write(body[:len(body)/2])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	defer func(timeout time.Duration) { remoteRangeTimeout = timeout }(remoteRangeTimeout)
+	remoteRangeTimeout = 200 * time.Millisecond
+
+	r := &remoteReader{url: server.URL, size: int64(len(data)), client: newRemoteClient()}
+	got := make([]byte, len(data))
+	done := make(chan error, 1)
+	go func() { done <- r.fetch(got, 0) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("fetch is still waiting on the stalled request")
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("resumed range reads back different data")
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("made %d requests, want the stalled one and one resuming it", n)
+	}
+}
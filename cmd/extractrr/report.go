@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// ReportName is the signed extraction report written into the root of an extraction with --report-key
+const ReportName = ".extractrr-report.json"
+
+// reportSignaturePrefix names the algorithm of Report.HMAC
+const reportSignaturePrefix = "hmac-sha256:"
+
+// Report records exactly what an extraction wrote, signed with a key only the archive
+// holds. Unlike the sidecar it has nothing that changes between runs, like timestamps or
+// the source path, so the same image extracted with the same options and tool version
+// always gives the same report.
+type Report struct {
+	ToolVersion string       `json:"tool_version"`
+	Source      ReportSource `json:"source"`
+	Files       []ReportFile `json:"files"`
+	// HMAC is over the compact JSON of the report without it
+	HMAC string `json:"hmac,omitempty"`
+}
+
+// ReportSource identifies the image by its content
+type ReportSource struct {
+	Size        int64  `json:"size"`
+	Hash        string `json:"hash"`
+	VolumeLabel string `json:"volume_label,omitempty"`
+}
+
+// ReportFile is a single extracted file
+type ReportFile struct {
+	Path string `json:"path"`
+	// Images is the chain of nested images the file was read from, empty for the image itself
+	Images  []string `json:"images,omitempty"`
+	SrcPath string   `json:"src_path"`
+	Size    int64    `json:"size"`
+	// Offset is where the file starts in its image, not recorded for empty files or
+	// if the image doesn't tell. Fragmented files only start there.
+	Offset int64  `json:"offset,omitempty"`
+	SHA256 string `json:"sha256"`
+}
+
+// readReportKey loads the HMAC key from path, surrounding whitespace is not part of it
+func readReportKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report key: %w", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("report key %s is empty", path)
+	}
+	return key, nil
+}
+
+// imageChain returns the paths of the nested images src is read through, outermost first
+func imageChain(src *imageSource) []string {
+	var chain []string
+	for s := src; s.Parent != nil; s = s.Parent {
+		chain = append([]string{s.Path}, chain...)
+	}
+	return chain
+}
+
+// newReport builds the report of a completed extraction from its results
+func newReport(isoFile, extractDir, label string, results map[string]fileResult) (*Report, error) {
+	source, err := describeSource(isoFile)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		ToolVersion: version,
+		Source:      ReportSource{Size: source.Size, Hash: source.Hash, VolumeLabel: label},
+		Files:       make([]ReportFile, 0, len(results)),
+	}
+	for dstPath, result := range results {
+		if result.Report == nil {
+			continue
+		}
+		rel, err := filepath.Rel(extractDir, dstPath)
+		if err != nil {
+			return nil, err
+		}
+		file := *result.Report
+		file.Path = filepath.ToSlash(rel)
+		report.Files = append(report.Files, file)
+	}
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].Path < report.Files[j].Path })
+
+	return report, nil
+}
+
+// signedReport writes the report of a completed extraction signed with key
+func signedReport(isoFile, extractDir, label string, key []byte, results map[string]fileResult) error {
+	report, err := newReport(isoFile, extractDir, label, results)
+	if err != nil {
+		return err
+	}
+	if err := report.sign(key); err != nil {
+		return err
+	}
+	return writeReport(extractDir, report)
+}
+
+// signature returns the HMAC of the report as recorded in Report.HMAC
+func (r Report) signature(key []byte) (string, error) {
+	r.HMAC = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return reportSignaturePrefix + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// sign records the signature of the report
+func (r *Report) sign(key []byte) error {
+	signature, err := r.signature(key)
+	if err != nil {
+		return err
+	}
+	r.HMAC = signature
+	return nil
+}
+
+// checkSignature fails unless the report was signed with key and not changed since
+func (r *Report) checkSignature(key []byte) error {
+	if !strings.HasPrefix(r.HMAC, reportSignaturePrefix) {
+		return fmt.Errorf("report is not signed")
+	}
+	signature, err := r.signature(key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(signature), []byte(r.HMAC)) {
+		return fmt.Errorf("report signature does not match, it was altered or signed with another key")
+	}
+	return nil
+}
+
+func writeReport(extractDir string, report *Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(extractDir, ReportName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func readReport(extractDir string) (*Report, error) {
+	data, err := os.ReadFile(filepath.Join(extractDir, ReportName))
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid report in %s: %w", extractDir, err)
+	}
+	return &report, nil
+}
+
+// sha256Hex returns the hex sha256 of everything read from r
+func sha256Hex(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// auditTree compares the files below extractDir with the report, returning how many differ
+func auditTree(extractDir string, report *Report) int {
+	mismatches := 0
+	for _, file := range report.Files {
+		f, err := openExtracted(filepath.Join(extractDir, filepath.FromSlash(file.Path)))
+		if err != nil {
+			slog.Error("Reported file is missing", "file", file.Path, "error", err)
+			mismatches++
+			continue
+		}
+		sum, n, err := sha256Hex(f)
+		f.Close()
+
+		switch {
+		case err != nil:
+			slog.Error("Failed to read file", "file", file.Path, "error", err)
+		case n != file.Size:
+			slog.Error("File size differs from the report", "file", file.Path, "bytes", n, "reported", file.Size)
+		case sum != file.SHA256:
+			slog.Error("File content differs from the report", "file", file.Path)
+		default:
+			continue
+		}
+		mismatches++
+	}
+	return mismatches
+}
+
+// auditImage compares the image at isoFile with the report, re-reading every reported file
+// from it, and returns how many files differ
+func auditImage(isoFile string, report *Report) (int, error) {
+	source, err := describeSource(isoFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open source: %w", err)
+	}
+	if source.Size != report.Source.Size || source.Hash != report.Source.Hash {
+		return 0, fmt.Errorf("%s is not the image of the report", isoFile)
+	}
+
+	// Each chain of nested images is opened once for all of its files
+	byImage := make(map[string][]ReportFile)
+	var keys []string
+	for _, file := range report.Files {
+		key := strings.Join(file.Images, "\x00")
+		if _, ok := byImage[key]; !ok {
+			keys = append(keys, key)
+		}
+		byImage[key] = append(byImage[key], file)
+	}
+
+	buffer := make([]byte, alignBufferSize(defaultBufferSize))
+	mismatches := 0
+	for _, key := range keys {
+		files := byImage[key]
+		src := &imageSource{Path: isoFile}
+		for _, inner := range files[0].Images {
+			src = &imageSource{Path: inner, Parent: src}
+		}
+
+		image, err := openBackend(src)
+		if err != nil {
+			return mismatches, fmt.Errorf("failed to open %s: %w", src, err)
+		}
+		for _, file := range files {
+			h := sha256.New()
+			_, err := readImageFile(image, file.SrcPath, buffer, func(chunk []byte) error {
+				h.Write(chunk)
+				return nil
+			})
+			if err != nil {
+				slog.Error("Failed to read file from image", "file", file.Path, "src", file.SrcPath, "error", err)
+				mismatches++
+			} else if hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+				slog.Error("Image content differs from the report", "file", file.Path, "src", file.SrcPath)
+				mismatches++
+			}
+		}
+		image.Close()
+	}
+
+	return mismatches, nil
+}
+
+func CommandAudit() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "audit",
+		Short: "Check an extraction against its signed report",
+		Long: `Check an extraction against its signed report
+
+Checks the signature of the ` + ReportName + ` written by extract --report-key, then re-hashes
+every reported file in the destination. Given the image too, it is checked to be the one the
+report was made from and every file is read from it again, which shows the tree corresponds
+bit for bit to the image.`,
+		Example: `  extractrr audit --report-key /etc/extractrr/report.key /archive/disc
+  extractrr audit --report-key /etc/extractrr/report.key /archive/disc /path/to/disc.iso`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return fmt.Errorf("requires a destination and optionally the image")
+			}
+			return nil
+		},
+	}
+
+	var (
+		keyFile = command.Flags().String("report-key", "", "File with the HMAC key the report was signed with")
+	)
+
+	command.RunE = func(cmd *cobra.Command, args []string) error {
+		if *keyFile == "" {
+			return fmt.Errorf("--report-key is required")
+		}
+		key, err := readReportKey(*keyFile)
+		if err != nil {
+			return err
+		}
+		report, err := readReport(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read report: %w", err)
+		}
+		if err := report.checkSignature(key); err != nil {
+			return err
+		}
+
+		mismatches := auditTree(args[0], report)
+		if len(args) == 2 {
+			n, err := auditImage(args[1], report)
+			if err != nil {
+				return err
+			}
+			mismatches += n
+		}
+		if mismatches > 0 {
+			return fmt.Errorf("%d of %d reported files do not match", mismatches, len(report.Files))
+		}
+
+		slog.Info("Extraction matches its report", "dest", args[0], "files", len(report.Files))
+
+		return nil
+	}
+
+	return command
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+func CommandResume() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "resume",
+		Short: "Resume an interrupted extraction",
+		Long: `Resume an interrupted extraction
+
+Reads the ` + SidecarName + ` file from the destination, re-opens the recorded source
+and extracts only the files that are missing or incomplete, using the original options.`,
+		Example: `  extractrr resume /path/to/export
+  extractrr resume /path/to/export --source /new/path/to/file.iso`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("requires one arg")
+			}
+			return nil
+		},
+	}
+
+	var (
+		source       = command.Flags().String("source", "", "Use this image instead of the recorded source path")
+		showProgress = command.Flags().Bool("progress", true, "Show progress bar")
+		useCache     = command.Flags().Bool("hash-cache", true, "Reuse cached hashes of existing files while their size and mtime are unchanged")
+	)
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		return resumeExtraction(args[0], *source, *showProgress, *useCache)
+	}
+
+	return command
+}
+
+// resumeExtraction completes the extraction recorded in the sidecar of extractDir
+func resumeExtraction(extractDir, source string, showProgress, useCache bool) error {
+	startTime := time.Now()
+	logger := slog.With("job", newJobID(), "dest", extractDir)
+
+	manifest, err := readSidecar(extractDir)
+	if err != nil {
+		return fmt.Errorf("failed to read sidecar: %w", err)
+	}
+
+	if manifest.Status == StatusComplete {
+		logger.Info("Extraction is already complete")
+		return nil
+	}
+
+	if source == "" {
+		source = manifest.Source.Path
+	}
+
+	// Make sure we are resuming from the same image the destination was started from
+	current, err := describeSource(source)
+	if err != nil {
+		return fmt.Errorf("failed to open source: %w", err)
+	}
+	if current.Hash != manifest.Source.Hash {
+		return fmt.Errorf("source %s does not match the image recorded in %s", source, SidecarName)
+	}
+	manifest.Source = current
+
+	opts := manifest.Options
+	opts.ShowProgress = showProgress
+	opts.logger = logger.With("iso", source)
+
+	results := make(map[string]fileResult)
+	jobs := make([]Job, 0)
+	var totalSize int64
+
+	// Manifest paths are resolved like scanned ones, so an edited sidecar can't escape the destination either
+	root, err := newDestRoot(extractDir)
+	if err != nil {
+		return err
+	}
+	opts.root = root
+
+	var cache *hashCache
+	if useCache {
+		if cache, err = openHashCache(); err != nil {
+			logger.Warn("Not using hash cache", "error", err)
+		}
+	}
+
+	src := &imageSource{Path: source}
+	for _, file := range manifest.Files {
+		dstPath, err := root.join(filepath.FromSlash(file.Path))
+		if err != nil {
+			return err
+		}
+
+		// Nested images can't be checked by size, so unfinished ones are extracted again
+		if file.Image {
+			if file.Status == StatusComplete {
+				results[dstPath] = fileResult{}
+				continue
+			}
+
+			jobs = append(jobs, Job{
+				SrcPath: file.SrcPath,
+				DstPath: dstPath,
+				Size:    file.Size,
+				Image:   true,
+			})
+			continue
+		}
+
+		if file.Status != StatusFailed {
+			result, done, err := existingResult(dstPath, file, opts, cache)
+			if err != nil {
+				return err
+			}
+			if done {
+				results[dstPath] = result
+				continue
+			}
+		}
+
+		if err := root.mkdirAll(filepath.Dir(dstPath)); err != nil {
+			return err
+		}
+		if err := root.checkFile(dstPath); err != nil {
+			return err
+		}
+
+		jobs = append(jobs, Job{
+			SrcPath: file.SrcPath,
+			DstPath: dstPath,
+			Size:    file.Size,
+		})
+		totalSize += file.Size
+	}
+
+	if opts.SplitOver4G {
+		markSplitJobs(extractDir, jobs)
+	}
+
+	planned := totalSize
+	opts.planned = &planned
+	opts.space = newSpaceGuard(opts.log(), extractDir, opts.MinFree, opts.SpaceTimeout)
+
+	if err := cache.save(); err != nil {
+		logger.Warn("Failed to save hash cache", "error", err)
+	}
+
+	opts.log().Info("Resuming extraction", "files", len(jobs), "total_files", len(manifest.Files), "bytes", totalSize, "size", humanize.IBytes(uint64(totalSize)))
+
+	jobResults, runErr := runJobs(src, jobs, totalSize, opts)
+	for dstPath, result := range jobResults {
+		results[dstPath] = result
+	}
+	if runErr == nil {
+		runErr = extractInnerImages(src, jobs, opts, results)
+	}
+
+	if err := finishExtraction(source, extractDir, opts, manifest, results); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return runErr
+	}
+
+	logSummary(opts.log(), startTime, totalSize)
+
+	return nil
+}
+
+// existingResult checks whether a manifest file is already fully extracted. Files recorded
+// as complete keep their checksums, files from an interrupted run that have the expected
+// size are hashed from disk, or taken from cache, so SFV and sidecar output stay complete.
+func existingResult(dstPath string, file SidecarFile, opts ExtractOptions, cache *hashCache) (fileResult, bool, error) {
+	size, err := extractedSize(dstPath)
+	if err != nil || size != file.Size {
+		return fileResult{}, false, nil
+	}
+
+	needCRC := opts.SFV != SFVNone || opts.checksum() == ChecksumCRC32
+	needDigest := opts.checksum() != ChecksumCRC32
+
+	var result fileResult
+	if file.CRC32 != "" {
+		crc, err := strconv.ParseUint(file.CRC32, 16, 32)
+		if err != nil {
+			return fileResult{}, false, fmt.Errorf("invalid crc32 for %s in sidecar: %w", file.Path, err)
+		}
+		result.CRC32, result.HasCRC = uint32(crc), true
+	}
+	if file.Digest != "" {
+		result.Digest, err = hex.DecodeString(file.Digest)
+		if err != nil {
+			return fileResult{}, false, fmt.Errorf("invalid digest for %s in sidecar: %w", file.Path, err)
+		}
+	}
+
+	if entry, ok := cache.lookup(dstPath); ok {
+		if !result.HasCRC && entry.CRC32 != "" {
+			if crc, err := strconv.ParseUint(entry.CRC32, 16, 32); err == nil {
+				result.CRC32, result.HasCRC = uint32(crc), needCRC
+			}
+		}
+		if result.Digest == nil && needDigest {
+			result.Digest, _ = hex.DecodeString(entry.Digests[opts.checksum()])
+			if len(result.Digest) == 0 {
+				result.Digest = nil
+			}
+		}
+	}
+
+	if (result.HasCRC || !needCRC) && (result.Digest != nil || !needDigest) {
+		return result, true, nil
+	}
+
+	f, err := openExtracted(dstPath)
+	if err != nil {
+		return fileResult{}, false, err
+	}
+	defer f.Close()
+
+	crc := crc32.NewIEEE()
+	digest := newChecksum(opts.checksum())
+	if _, err := io.Copy(io.MultiWriter(crc, digest), f); err != nil {
+		return fileResult{}, false, err
+	}
+
+	result = fileResult{CRC32: crc.Sum32(), HasCRC: needCRC}
+	if needDigest {
+		result.Digest = digest.Sum(nil)
+	}
+
+	cache.update(dstPath, func(entry *hashCacheEntry) {
+		entry.CRC32 = fmt.Sprintf("%08x", result.CRC32)
+		if needDigest {
+			if entry.Digests == nil {
+				entry.Digests = make(map[string]string)
+			}
+			entry.Digests[opts.checksum()] = hex.EncodeToString(result.Digest)
+		}
+	})
+
+	return result, true, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/dustin/go-humanize"
+)
+
+// routeRule is a rule of the routes file as written. Every condition that is set has to
+// match, a rule without any matches every image.
+type routeRule struct {
+	// Name is a glob over the file name of the image
+	Name string `json:"name,omitempty"`
+	// Label is a glob over the volume label, e.g. "*UHD*"
+	Label string `json:"label,omitempty"`
+	// DiscType is BD, DVD or data in any case
+	DiscType string `json:"disc_type,omitempty"`
+	// MinSize and MaxSize bound what the image has to extract, e.g. "40GiB"
+	MinSize string `json:"min_size,omitempty"`
+	MaxSize string `json:"max_size,omitempty"`
+	// Dest is where a matching image goes, a destination template like on the command line
+	Dest string `json:"dest"`
+}
+
+// route is a parsed routeRule
+type route struct {
+	rule    routeRule
+	minSize int64
+	maxSize int64
+	dest    *template.Template
+}
+
+// readRoutes loads the rules of a routes file in order, so mistakes show before any image is opened
+func readRoutes(path string) ([]route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes: %w", err)
+	}
+
+	var rules []routeRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+
+	routes := make([]route, 0, len(rules))
+	for i, rule := range rules {
+		r, err := newRoute(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: rule %d: %w", path, i+1, err)
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+func newRoute(rule routeRule) (route, error) {
+	r := route{rule: rule}
+	if rule.Dest == "" {
+		return r, fmt.Errorf("needs a dest")
+	}
+	for _, pattern := range []string{rule.Name, rule.Label} {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return r, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	if rule.DiscType != "" && !strings.EqualFold(rule.DiscType, DiscTypeBluray) && !strings.EqualFold(rule.DiscType, DiscTypeDVD) && !strings.EqualFold(rule.DiscType, DiscTypeData) {
+		return r, fmt.Errorf("invalid disc_type %q: must be one of %s, %s or %s", rule.DiscType, DiscTypeBluray, DiscTypeDVD, DiscTypeData)
+	}
+	for _, bound := range []struct {
+		value string
+		size  *int64
+	}{{rule.MinSize, &r.minSize}, {rule.MaxSize, &r.maxSize}} {
+		if bound.value == "" {
+			continue
+		}
+		size, err := humanize.ParseBytes(bound.value)
+		if err != nil {
+			return r, fmt.Errorf("invalid size %q: %w", bound.value, err)
+		}
+		*bound.size = int64(size)
+	}
+
+	var err error
+	if r.dest, err = parseDestinationTemplate(rule.Dest); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// globFold matches name against a glob ignoring case, labels are usually upper case
+func globFold(pattern, name string) bool {
+	ok, _ := filepath.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok
+}
+
+// matches reports whether the route applies to the image
+func (r route) matches(meta ImageMetadata) bool {
+	switch {
+	case r.rule.Name != "" && !globFold(r.rule.Name, imageBaseName(meta.ImagePath)):
+		return false
+	case r.rule.Label != "" && !globFold(r.rule.Label, meta.VolumeLabel):
+		return false
+	case r.rule.DiscType != "" && !strings.EqualFold(r.rule.DiscType, meta.DiscType):
+		return false
+	case r.minSize > 0 && meta.TotalSize < r.minSize:
+		return false
+	case r.maxSize > 0 && meta.TotalSize > r.maxSize:
+		return false
+	}
+	return true
+}
+
+// routeImage returns the destination of the first route matching the image and its number,
+// 0 if none does
+func routeImage(routes []route, meta ImageMetadata) (string, int, error) {
+	for i, r := range routes {
+		if !r.matches(meta) {
+			continue
+		}
+		dest, err := renderDestination(r.dest, meta)
+		if err != nil {
+			return "", 0, fmt.Errorf("route %d: %w", i+1, err)
+		}
+		return dest, i + 1, nil
+	}
+	return "", 0, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// checkEntryName rejects entry names from an image that could make a destination
+// path point anywhere else than a direct child of its parent directory
+func checkEntryName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("empty name")
+	case name == "." || name == "..":
+		return fmt.Errorf("name %q refers to a directory itself", name)
+	case strings.ContainsAny(name, "/\\\x00"):
+		return fmt.Errorf("name %q contains a path separator", name)
+	}
+	return nil
+}
+
+// warnUnsafeEntries logs the entries a scan refused because of their name
+func warnUnsafeEntries(logger *slog.Logger, scan *scanResult) {
+	for _, entry := range scan.Skipped {
+		if entry.Unsafe {
+			logger.Warn("Skipping unsafe entry", "file", entry.SrcPath, "reason", entry.Reason)
+		}
+	}
+}
+
+// withinRoot reports whether path is root or below it, both must be clean
+func withinRoot(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// destRoot is an extraction root that destination paths are resolved against
+type destRoot struct {
+	path     string
+	resolved string
+
+	// mu guards safe, workers create directories as they extract files
+	mu sync.Mutex
+	// safe caches directories already known to resolve inside the root
+	safe map[string]bool
+}
+
+// newDestRoot creates the root if needed, e.g. the directory of a nested image
+func newDestRoot(path string) (*destRoot, error) {
+	path = filepath.Clean(path)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &destRoot{path: path, resolved: resolved, safe: map[string]bool{path: true}}, nil
+}
+
+// resolvePath returns the absolute path with all symlinks resolved
+func resolvePath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// join returns rel below the root, refusing anything that escapes it
+func (r *destRoot) join(rel string) (string, error) {
+	path := filepath.Join(r.path, rel)
+	if !withinRoot(r.path, path) {
+		return "", fmt.Errorf("refusing %q: it escapes the destination %s", rel, r.path)
+	}
+	return path, nil
+}
+
+// mkdirAll creates dir one component at a time. Existing symlinks are only
+// followed if they resolve inside the root, so a link planted in the destination
+// can't be used to create directories or write files anywhere else.
+func (r *destRoot) mkdirAll(dir string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.mkdirAllLocked(dir)
+}
+
+func (r *destRoot) mkdirAllLocked(dir string) error {
+	if r.safe[dir] {
+		return nil
+	}
+
+	parent := filepath.Dir(dir)
+	if parent != dir && withinRoot(r.path, parent) {
+		if err := r.mkdirAllLocked(parent); err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Lstat(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := os.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	case err != nil:
+		return err
+	case info.Mode()&os.ModeSymlink != 0:
+		resolved, err := resolvePath(dir)
+		if err != nil {
+			return err
+		}
+		if !withinRoot(r.resolved, resolved) {
+			return fmt.Errorf("refusing %s: it is a symlink pointing outside the destination", dir)
+		}
+	case !info.IsDir():
+		return fmt.Errorf("refusing %s: it exists and is not a directory", dir)
+	}
+
+	r.safe[dir] = true
+	return nil
+}
+
+// checkFile makes sure a file can be written without following a symlink
+func (r *destRoot) checkFile(path string) error {
+	return refuseSymlink(path)
+}
+
+// refuseSymlink returns an error if path is a symlink
+func refuseSymlink(path string) error {
+	info, err := os.Lstat(path)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("refusing to write through symlink %s", path)
+	}
+	return nil
+}
+
+// createFile creates path for writing without following a symlink at it. Partial files are
+// always created anew, so a link planted in place of one is removed rather than written through.
+func createFile(path string, partial bool) (*os.File, error) {
+	if !partial {
+		// Checked again right before writing since the destination may have changed since the scan,
+		// O_NOFOLLOW refuses a link that appears in between
+		if err := refuseSymlink(path); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|openNoFollow, 0644)
+		if errors.Is(err, syscall.ELOOP) {
+			return nil, fmt.Errorf("refusing to write through symlink %s", path)
+		}
+		return f, err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	// O_EXCL also fails for a symlink created in between, even one pointing nowhere
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+}
+
+// stagingPath returns where destPath is written in the staging directory. The name is
+// derived from the full destination so files of the same name in different directories
+// or concurrent jobs don't collide.
+func stagingPath(staging, destPath string) string {
+	sum := sha256.Sum256([]byte(destPath))
+	return filepath.Join(staging, hex.EncodeToString(sum[:8])+"-"+filepath.Base(destPath)+PartialSuffix)
+}
+
+// moveFile renames src to dst, copying it when they are on different filesystems like a
+// staging disk and the destination. The copy is written as partial file next to dst first.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + PartialSuffix
+	out, err := createFile(tmp, true)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Remove(src)
+}
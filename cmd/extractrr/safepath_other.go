@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// openNoFollow is not available, createFile relies on checking for a symlink first
+const openNoFollow = 0
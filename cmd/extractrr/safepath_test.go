@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"file.bin", true},
+		{"..hidden", true},
+		{"with space", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"/etc/passwd", false},
+		{"../escape", false},
+		{"sub/file", false},
+		{`..\escape`, false},
+		{"nul\x00byte", false},
+	}
+	for _, tt := range tests {
+		if err := checkEntryName(tt.name); (err == nil) != tt.ok {
+			t.Errorf("checkEntryName(%q) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestDestRootJoin(t *testing.T) {
+	root, err := newDestRoot(filepath.Join(t.TempDir(), "dest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rel  string
+		want string
+	}{
+		{"file.bin", "file.bin"},
+		{"sub/dir/file.bin", "sub/dir/file.bin"},
+		{"sub/../file.bin", "file.bin"},
+		{".", "."},
+		// Absolute names are joined below the root rather than taken as they are
+		{"/etc/passwd", "etc/passwd"},
+		{"..", ""},
+		{"../dest2/file.bin", ""},
+		{"sub/../../file.bin", ""},
+	}
+	for _, tt := range tests {
+		got, err := root.join(tt.rel)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("join(%q) = %s, want it refused", tt.rel, got)
+			}
+			continue
+		}
+		if want := filepath.Join(root.path, tt.want); err != nil || got != want {
+			t.Errorf("join(%q) = %s, %v, want %s", tt.rel, got, err, want)
+		}
+	}
+}
+
+func TestDestRootMkdirAll(t *testing.T) {
+	base := t.TempDir()
+	outside := filepath.Join(base, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	root, err := newDestRoot(filepath.Join(base, "dest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root.path, "inside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"out": outside, "in": filepath.Join(root.path, "inside")} {
+		if err := os.Symlink(target, filepath.Join(root.path, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root.path, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir string
+		ok  bool
+	}{
+		{"a/b/c", true},
+		{"in/sub", true},
+		{"out", false},
+		{"out/sub", false},
+		{"file/sub", false},
+	}
+	for _, tt := range tests {
+		err := root.mkdirAll(filepath.Join(root.path, tt.dir))
+		if (err == nil) != tt.ok {
+			t.Errorf("mkdirAll(%s) = %v, want ok %v", tt.dir, err, tt.ok)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(root.path, "inside", "sub")); err != nil {
+		t.Errorf("directory below the inside link wasn't created: %v", err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("directories were created outside the destination: %v", entries)
+	}
+}
+
+func TestCreateFile(t *testing.T) {
+	base := t.TempDir()
+	target := filepath.Join(base, "target")
+	if err := os.WriteFile(target, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		partial bool
+		link    string
+		ok      bool
+	}{
+		{"new", false, "", true},
+		{"new partial", true, "", true},
+		{"existing", false, "", true},
+		{"link", false, target, false},
+		{"dangling link", false, filepath.Join(base, "nowhere"), false},
+		{"partial link", true, target, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(base, tt.name)
+		if tt.name == "existing" {
+			if err := os.WriteFile(path, []byte("old content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.link != "" {
+			if err := os.Symlink(tt.link, path); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		f, err := createFile(path, tt.partial)
+		if (err == nil) != tt.ok {
+			t.Errorf("createFile(%s) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if err != nil {
+			continue
+		}
+		_, err = f.WriteString("new")
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != 3 {
+			t.Errorf("%s isn't a regular file with the new content after createFile", tt.name)
+		}
+	}
+
+	if data, _ := os.ReadFile(target); string(data) != "keep" {
+		t.Errorf("symlink target was written through, it has %q", data)
+	}
+	if _, err := os.Lstat(filepath.Join(base, "nowhere")); err == nil {
+		t.Errorf("dangling symlink target was created")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// openNoFollow makes opening a symlink fail instead of following it
+const openNoFollow = syscall.O_NOFOLLOW
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+func CommandScrub() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "scrub",
+		Short: "Re-check extracted files against their sidecar manifests",
+		Long: `Re-check extracted files against their sidecar manifests
+
+Finds every ` + SidecarName + ` below the given directories and re-hashes the recorded files,
+reporting files that are missing or no longer match, e.g. because of bit-rot.
+Run it from cron or a systemd timer; with --min-age destinations that were scrubbed
+recently are skipped so a large library is spread over several runs.`,
+		Example: `  extractrr scrub /media
+  extractrr scrub /media --min-age 720h --progress=false`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return fmt.Errorf("requires at least one arg")
+			}
+			return nil
+		},
+	}
+
+	var (
+		numWorkers   = command.Flags().Int("workers", runtime.NumCPU(), "Number of parallel workers")
+		bufferSize   = bufferFlag(command.Flags(), "Buffer size for file reading")
+		showProgress = command.Flags().Bool("progress", true, "Show progress bar")
+		minAge       = command.Flags().Duration("min-age", 0, "Skip destinations scrubbed more recently than this")
+	)
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		startTime := time.Now()
+		logger := slog.With("job", newJobID())
+
+		dests, err := findSidecars(args)
+		if err != nil {
+			return err
+		}
+
+		var targets []scrubTarget
+		var totalSize int64
+		for _, dest := range dests {
+			manifest, err := readSidecar(dest)
+			if err != nil {
+				logger.Error("Failed to read sidecar", "dest", dest, "error", err)
+				continue
+			}
+
+			if manifest.ScrubbedAt != nil && *minAge > 0 && time.Since(*manifest.ScrubbedAt) < *minAge {
+				continue
+			}
+
+			for _, file := range manifest.Files {
+				if file.Status != StatusComplete || (file.CRC32 == "" && file.Digest == "") {
+					continue
+				}
+				targets = append(targets, scrubTarget{Dest: dest, File: file, Checksum: manifest.Options.checksum()})
+				totalSize += file.Size
+			}
+		}
+
+		logger.Info("Scrubbing files", "destinations", len(dests), "files", len(targets), "bytes", totalSize, "size", humanize.IBytes(uint64(totalSize)))
+
+		var bar *pb.ProgressBar
+		if *showProgress {
+			bar = pb.Full.Start64(totalSize)
+			bar.Set(pb.Bytes, true)
+		}
+
+		problems := scrubFiles(targets, *numWorkers, *bufferSize, bar)
+		if bar != nil {
+			bar.Finish()
+		}
+
+		// Only record a scrub for destinations that came out clean
+		failedDests := make(map[string]bool)
+		for i, err := range problems {
+			if err != nil {
+				logger.Error("Scrub failed", "dest", targets[i].Dest, "file", targets[i].File.Path, "bytes", targets[i].File.Size, "error", err)
+				failedDests[targets[i].Dest] = true
+			}
+		}
+
+		now := time.Now()
+		scrubbed := make(map[string]bool)
+		for _, target := range targets {
+			if failedDests[target.Dest] || scrubbed[target.Dest] {
+				continue
+			}
+			scrubbed[target.Dest] = true
+
+			manifest, err := readSidecar(target.Dest)
+			if err != nil {
+				return err
+			}
+			manifest.ScrubbedAt = &now
+			if err := writeSidecar(target.Dest, manifest); err != nil {
+				return fmt.Errorf("failed to update sidecar in %s: %w", target.Dest, err)
+			}
+		}
+
+		logger.Info("Scrub completed", "files", len(targets), "failed_destinations", len(failedDests), "duration", time.Since(startTime).String())
+		if len(failedDests) > 0 {
+			return fmt.Errorf("scrub found problems in %d destinations", len(failedDests))
+		}
+
+		return nil
+	}
+
+	return command
+}
+
+// scrubTarget is a manifest file to re-check
+type scrubTarget struct {
+	Dest     string
+	File     SidecarFile
+	Checksum string
+}
+
+// findSidecars returns every directory below roots containing a sidecar
+func findSidecars(roots []string) ([]string, error) {
+	var dests []string
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && d.Name() == SidecarName {
+				dests = append(dests, filepath.Dir(path))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dests, nil
+}
+
+// scrubFiles re-hashes targets with a pool of workers and returns an error per target
+func scrubFiles(targets []scrubTarget, workers, bufferSize int, bar *pb.ProgressBar) []error {
+	problems := make([]error, len(targets))
+	jobChan := make(chan int, len(targets))
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			buffer := make([]byte, bufferSize)
+			for idx := range jobChan {
+				problems[idx] = scrubFile(targets[idx], buffer, bar)
+			}
+		}()
+	}
+
+	for i := range targets {
+		jobChan <- i
+	}
+	close(jobChan)
+	wg.Wait()
+
+	return problems
+}
+
+// scrubFile compares a destination file with its manifest entry, using the
+// manifest's own algorithm when it recorded one and crc32 otherwise
+func scrubFile(target scrubTarget, buffer []byte, bar *pb.ProgressBar) error {
+	algo, expected := ChecksumCRC32, target.File.CRC32
+	if target.File.Digest != "" {
+		algo, expected = target.Checksum, target.File.Digest
+	}
+	if err := validateChecksum(algo); err != nil {
+		return fmt.Errorf("invalid sidecar: %w", err)
+	}
+
+	f, err := openExtracted(filepath.Join(target.Dest, filepath.FromSlash(target.File.Path)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("file is missing")
+		}
+		return err
+	}
+	defer f.Close()
+
+	h := newChecksum(algo)
+	var r io.Reader = f
+	if bar != nil {
+		r = bar.NewProxyReader(f)
+	}
+
+	n, err := io.CopyBuffer(h, r, buffer)
+	if err != nil {
+		return err
+	}
+	if n != target.File.Size {
+		return fmt.Errorf("size changed: expected %d bytes, found %d", target.File.Size, n)
+	}
+	if found := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(found, expected) {
+		return fmt.Errorf("%s mismatch: expected %s, found %s", algo, expected, found)
+	}
+
+	return nil
+}
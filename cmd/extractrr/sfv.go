@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SFV output modes
+const (
+	SFVNone   = "none"
+	SFVSingle = "single"
+	SFVPerDir = "per-dir"
+)
+
+func validateSFVMode(mode string) error {
+	switch mode {
+	case SFVNone, SFVSingle, SFVPerDir:
+		return nil
+	default:
+		return fmt.Errorf("invalid sfv mode %q: must be one of %s, %s or %s", mode, SFVNone, SFVSingle, SFVPerDir)
+	}
+}
+
+// writeSFV writes the CRC32 checksums of all successfully extracted files as .sfv files.
+// In single mode one file named after the ISO is written to the root of extractDir,
+// in per-dir mode every directory gets an .sfv named after itself listing its own files.
+func writeSFV(isoFile, extractDir, mode string, results map[string]fileResult) error {
+	isoName := strings.TrimSuffix(imageBaseName(isoFile), filepath.Ext(imageBaseName(isoFile)))
+	extractDir = filepath.Clean(extractDir)
+
+	// Group entries by the directory their paths are relative to
+	groups := make(map[string]map[string]uint32)
+	for path, result := range results {
+		if !result.HasCRC {
+			continue
+		}
+
+		dir := extractDir
+		if mode == SFVPerDir {
+			dir = filepath.Dir(path)
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if groups[dir] == nil {
+			groups[dir] = make(map[string]uint32)
+		}
+		groups[dir][filepath.ToSlash(rel)] = result.CRC32
+	}
+
+	for dir, entries := range groups {
+		name := isoName
+		if dir != extractDir {
+			name = filepath.Base(dir)
+		}
+
+		if err := writeSFVFile(filepath.Join(dir, name+".sfv"), entries); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSFVFile writes a single .sfv file with entries sorted by name
+func writeSFVFile(path string, entries map[string]uint32) error {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "; Generated by extractrr %s on %s\n", version, time.Now().Format("2006-01-02 15:04:05"))
+	for _, name := range names {
+		fmt.Fprintf(w, "%s %08X\n", name, entries[name])
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SidecarName is the metadata file written into the root of every extraction
+const SidecarName = ".extractrr.json"
+
+// Extraction states recorded in the sidecar
+const (
+	StatusRunning  = "running"
+	StatusComplete = "complete"
+	StatusFailed   = "failed"
+)
+
+// fingerprintChunk is how much of the start and end of the source is hashed for its fingerprint
+const fingerprintChunk = 1024 * 1024
+
+// Sidecar records how a destination was produced
+type Sidecar struct {
+	ToolVersion string         `json:"tool_version"`
+	Source      SidecarSource  `json:"source"`
+	Options     ExtractOptions `json:"options"`
+	Status      string         `json:"status"`
+	StartedAt   time.Time      `json:"started_at"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+	ScrubbedAt  *time.Time     `json:"scrubbed_at,omitempty"`
+	Files       []SidecarFile  `json:"files"`
+	// Timing covers the files extracted by the last run
+	Timing *SidecarTiming `json:"timing,omitempty"`
+}
+
+// SidecarSource identifies the image a destination was extracted from
+type SidecarSource struct {
+	Path  string    `json:"path"`
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+	// Hash is a sha256 over the image size and its first and last MiB,
+	// cheap enough to compute for every multi-GB image
+	Hash string `json:"hash"`
+	// VolumeLabel recognizes the same disc obtained from another source
+	VolumeLabel string `json:"volume_label,omitempty"`
+}
+
+// SidecarFile is a single manifest entry
+type SidecarFile struct {
+	Path    string `json:"path"`
+	SrcPath string `json:"src_path"`
+	Size    int64  `json:"size"`
+	CRC32   string `json:"crc32,omitempty"`
+	// Digest is the hex checksum in the manifest's algorithm when that isn't crc32
+	Digest string `json:"digest,omitempty"`
+	// Type is the content type detected with --detect-types
+	Type   string `json:"type,omitempty"`
+	Status string `json:"status"`
+	// Duration is how long extracting the file took
+	Duration time.Duration `json:"duration,omitempty"`
+	// Image is set for nested images extracted into the directory at Path
+	Image bool `json:"image,omitempty"`
+}
+
+// newSidecar creates a sidecar in running state for the planned jobs
+func newSidecar(isoFile, extractDir, label string, opts ExtractOptions, jobs []Job) (*Sidecar, error) {
+	source, err := describeSource(isoFile)
+	if err != nil {
+		return nil, err
+	}
+	source.VolumeLabel = label
+
+	sidecar := &Sidecar{
+		ToolVersion: version,
+		Source:      source,
+		Options:     opts,
+		Status:      StatusRunning,
+		StartedAt:   time.Now(),
+		Files:       make([]SidecarFile, 0, len(jobs)),
+	}
+
+	for _, job := range jobs {
+		rel, err := filepath.Rel(extractDir, job.DstPath)
+		if err != nil {
+			return nil, err
+		}
+
+		sidecar.Files = append(sidecar.Files, SidecarFile{
+			Path:    filepath.ToSlash(rel),
+			SrcPath: job.SrcPath,
+			Size:    job.Size,
+			Status:  StatusRunning,
+			Image:   job.Image,
+		})
+	}
+
+	return sidecar, nil
+}
+
+// describeSource stats and fingerprints the source image
+func describeSource(isoFile string) (SidecarSource, error) {
+	absPath := isoFile
+	if !isRemotePath(isoFile) {
+		var err error
+		absPath, err = filepath.Abs(isoFile)
+		if err != nil {
+			return SidecarSource{}, err
+		}
+	}
+
+	f, err := openSourceFile(isoFile)
+	if err != nil {
+		return SidecarSource{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, f.Size())
+
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, min(f.Size(), fingerprintChunk))); err != nil {
+		return SidecarSource{}, err
+	}
+	if f.Size() > 2*fingerprintChunk {
+		if _, err := io.Copy(h, io.NewSectionReader(f, f.Size()-fingerprintChunk, fingerprintChunk)); err != nil {
+			return SidecarSource{}, err
+		}
+	}
+
+	return SidecarSource{
+		Path:  absPath,
+		Size:  f.Size(),
+		Mtime: f.ModTime(),
+		Hash:  "sha256:" + hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// finish records the per-file results and the final status
+func (s *Sidecar) finish(extractDir string, results map[string]fileResult) {
+	s.Status = StatusComplete
+	for i := range s.Files {
+		file := &s.Files[i]
+
+		result, ok := results[filepath.Join(extractDir, filepath.FromSlash(file.Path))]
+		if !ok || result.Err != nil {
+			file.Status = StatusFailed
+			s.Status = StatusFailed
+			continue
+		}
+
+		file.Status = StatusComplete
+		if result.HasCRC {
+			file.CRC32 = fmt.Sprintf("%08x", result.CRC32)
+		}
+		if result.Digest != nil {
+			file.Digest = hex.EncodeToString(result.Digest)
+		}
+		if result.Type != "" {
+			file.Type = result.Type
+		}
+		// Files a resume found complete keep the time of the run that extracted them
+		if result.Duration > 0 {
+			file.Duration = result.Duration
+		}
+	}
+	s.Timing = summarizeTiming(s.Files)
+
+	now := time.Now()
+	s.CompletedAt = &now
+}
+
+// writeSidecar atomically writes the sidecar into extractDir
+func writeSidecar(extractDir string, sidecar *Sidecar) error {
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(extractDir, SidecarName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// readSidecar loads the sidecar from extractDir
+func readSidecar(extractDir string) (*Sidecar, error) {
+	data, err := os.ReadFile(filepath.Join(extractDir, SidecarName))
+	if err != nil {
+		return nil, err
+	}
+
+	var sidecar Sidecar
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return nil, fmt.Errorf("invalid sidecar in %s: %w", extractDir, err)
+	}
+
+	return &sidecar, nil
+}
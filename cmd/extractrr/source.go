@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// SourceBackend is an opened image. Everything outside the backend reads images through
+// it, so other formats, remote readers or fakes in tests can take the place of libudfread
+// without touching scanning, extraction or the commands. A backend is not safe for
+// concurrent use, every worker opens its own.
+type SourceBackend interface {
+	// Stat describes the entry at an absolute in-image path
+	Stat(path string) (entryInfo, error)
+	// ReadDir returns the entries of a directory as stored, names are not checked
+	ReadDir(path string) ([]dirEntry, error)
+	// OpenFile opens a regular file for reading
+	OpenFile(path string) (entryFile, error)
+	// VolumeLabel is the label of the image as recorded, "" if there is none
+	VolumeLabel() string
+	Close()
+}
+
+// dirEntry is a single entry of an image directory
+type dirEntry struct {
+	Name      string
+	IsDir     bool
+	IsRegular bool
+}
+
+// entryInfo describes an entry of an image
+type entryInfo struct {
+	IsDir bool
+	Size  int64
+	// Block is the first block of a file in the image, -1 if the backend doesn't know it
+	Block int64
+}
+
+// entryFile is a file opened in an image, Read returns io.EOF at its end
+type entryFile interface {
+	io.Reader
+	Size() int64
+	Close() error
+}
+
+// openBackend opens src with the backend for its format
+var openBackend = openUDFBackend
+
+// readDir returns the entries of an image directory, leaving out names that could
+// point outside of it
+func readDir(image SourceBackend, path string) ([]dirEntry, error) {
+	entries, err := image.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	safe := entries[:0]
+	for _, entry := range entries {
+		if checkEntryName(entry.Name) == nil {
+			safe = append(safe, entry)
+		}
+	}
+	return safe, nil
+}
+
+// getFileSize returns the size of a file in the image
+func getFileSize(image SourceBackend, path string) (int64, error) {
+	info, err := image.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir {
+		return 0, fmt.Errorf("not a file: %s", path)
+	}
+	return info.Size, nil
+}
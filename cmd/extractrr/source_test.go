@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeBackend is an in-memory image, entries are keyed by absolute in-image path. The
+// parents of every file are directories.
+type fakeBackend struct {
+	files   map[string][]byte
+	dirs    map[string]bool
+	special map[string]bool
+	// raw adds entries to a directory listing as they are, for names a real tree can't have
+	raw map[string][]dirEntry
+}
+
+func newFakeBackend(files map[string]string, special ...string) *fakeBackend {
+	b := &fakeBackend{files: make(map[string][]byte), dirs: map[string]bool{"/": true}, special: make(map[string]bool), raw: make(map[string][]dirEntry)}
+	for p, data := range files {
+		b.files[p] = []byte(data)
+		b.addParents(p)
+	}
+	for _, p := range special {
+		b.special[p] = true
+		b.addParents(p)
+	}
+	return b
+}
+
+func (b *fakeBackend) addParents(p string) {
+	for dir := path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+		b.dirs[dir] = true
+	}
+}
+
+func (b *fakeBackend) Stat(p string) (entryInfo, error) {
+	p = filepath.ToSlash(p)
+	if b.dirs[p] {
+		return entryInfo{IsDir: true, Block: -1}, nil
+	}
+	if data, ok := b.files[p]; ok {
+		return entryInfo{Size: int64(len(data)), Block: -1}, nil
+	}
+	return entryInfo{}, fmt.Errorf("failed to open file: %s", p)
+}
+
+func (b *fakeBackend) ReadDir(p string) ([]dirEntry, error) {
+	p = filepath.ToSlash(p)
+	if !b.dirs[p] {
+		return nil, fmt.Errorf("failed to open directory: %s", p)
+	}
+
+	var entries []dirEntry
+	add := func(child string, dir, regular bool) {
+		if child != p && path.Dir(child) == p {
+			entries = append(entries, dirEntry{Name: path.Base(child), IsDir: dir, IsRegular: regular})
+		}
+	}
+	for child := range b.dirs {
+		add(child, true, false)
+	}
+	for child := range b.files {
+		add(child, false, true)
+	}
+	for child := range b.special {
+		add(child, false, false)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return append(entries, b.raw[p]...), nil
+}
+
+func (b *fakeBackend) OpenFile(p string) (entryFile, error) {
+	data, ok := b.files[filepath.ToSlash(p)]
+	if !ok {
+		return nil, fmt.Errorf("failed to open file: %s", p)
+	}
+	return &fakeFile{Reader: bytes.NewReader(data)}, nil
+}
+
+func (b *fakeBackend) VolumeLabel() string { return "FAKE" }
+func (b *fakeBackend) Close()              {}
+
+type fakeFile struct {
+	*bytes.Reader
+}
+
+func (f *fakeFile) Close() error { return nil }
+
+// useFakeBackend makes every image open as b for the rest of the test
+func useFakeBackend(t *testing.T, b *fakeBackend) {
+	t.Helper()
+	saved := openBackend
+	openBackend = func(*imageSource) (SourceBackend, error) { return b, nil }
+	t.Cleanup(func() { openBackend = saved })
+}
+
+// discImage is a small Blu-ray like tree with an entry of every kind
+func discImage() *fakeBackend {
+	b := newFakeBackend(map[string]string{
+		"/BDMV/index.bdmv":         "index",
+		"/BDMV/STREAM/00001.m2ts":  strings.Repeat("a", 1000),
+		"/BDMV/STREAM/00002.m2ts":  strings.Repeat("b", 3000),
+		"/CERTIFICATE/id.bdmv":     "cert",
+		"/CERTIFICATE/BACKUP/a.md": "backup",
+		"/readme.txt":              "read me",
+	}, "/link")
+	b.raw["/BDMV"] = []dirEntry{{Name: "../escape", IsRegular: true}}
+	return b
+}
+
+func jobPaths(scan *scanResult) map[string]string {
+	jobs := make(map[string]string)
+	for _, job := range scan.Jobs {
+		jobs[job.SrcPath] = job.DstPath
+	}
+	return jobs
+}
+
+func skipReasons(scan *scanResult) map[string]string {
+	skipped := make(map[string]string)
+	for _, entry := range scan.Skipped {
+		skipped[entry.SrcPath] = entry.Reason
+	}
+	return skipped
+}
+
+func TestScanISOStructure(t *testing.T) {
+	useFakeBackend(t, discImage())
+	image, err := openBackend(&imageSource{Path: "disc.iso"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer image.Close()
+
+	scan := &scanResult{}
+	if err := scanISOStructure(image, "/", "", 0, nil, scan); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"/BDMV/index.bdmv":         "BDMV/index.bdmv",
+		"/BDMV/STREAM/00001.m2ts":  "BDMV/STREAM/00001.m2ts",
+		"/BDMV/STREAM/00002.m2ts":  "BDMV/STREAM/00002.m2ts",
+		"/CERTIFICATE/id.bdmv":     "CERTIFICATE/id.bdmv",
+		"/CERTIFICATE/BACKUP/a.md": "CERTIFICATE/BACKUP/a.md",
+		"/readme.txt":              "readme.txt",
+	}
+	if got := jobPaths(scan); !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v, want %v", got, want)
+	}
+	if scan.FileCount != 6 || scan.TotalSize != 4022 {
+		t.Errorf("got %d files of %d bytes, want 6 files of 4022 bytes", scan.FileCount, scan.TotalSize)
+	}
+
+	dirs := slices.Sorted(slices.Values(scan.Dirs))
+	if want := []string{"", "BDMV", "BDMV/STREAM", "CERTIFICATE", "CERTIFICATE/BACKUP"}; !slices.Equal(dirs, want) {
+		t.Errorf("got dirs %q, want %q", dirs, want)
+	}
+
+	var unsafe, special int
+	for _, entry := range scan.Skipped {
+		switch {
+		case entry.Unsafe && entry.SrcPath == "/BDMV/../escape" && entry.DstPath == "BDMV":
+			unsafe++
+		case entry.Special && entry.SrcPath == "/link":
+			special++
+		default:
+			t.Errorf("unexpected skipped entry %+v", entry)
+		}
+	}
+	if unsafe != 1 || special != 1 {
+		t.Errorf("got %d unsafe and %d special entries, want one each", unsafe, special)
+	}
+}
+
+func TestScanISOStructureStrip(t *testing.T) {
+	scan := &scanResult{}
+	if err := scanISOStructure(discImage(), "/", "", 1, nil, scan); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"/BDMV/index.bdmv":         "index.bdmv",
+		"/BDMV/STREAM/00001.m2ts":  "STREAM/00001.m2ts",
+		"/BDMV/STREAM/00002.m2ts":  "STREAM/00002.m2ts",
+		"/CERTIFICATE/id.bdmv":     "id.bdmv",
+		"/CERTIFICATE/BACKUP/a.md": "BACKUP/a.md",
+	}
+	if got := jobPaths(scan); !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v, want %v", got, want)
+	}
+
+	skipped := skipReasons(scan)
+	for _, p := range []string{"/readme.txt", "/link"} {
+		if skipped[p] != stripSkipReason {
+			t.Errorf("%s skipped for %q, want %q", p, skipped[p], stripSkipReason)
+		}
+	}
+}
+
+func TestScanISOStructureFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    filterOptions
+		jobs    []string
+		skipped map[string]int64
+	}{
+		{
+			name:    "path",
+			opts:    filterOptions{Paths: []string{"/BDMV/STREAM"}},
+			jobs:    []string{"/BDMV/STREAM/00001.m2ts", "/BDMV/STREAM/00002.m2ts"},
+			skipped: map[string]int64{"/BDMV/index.bdmv": 5, "/CERTIFICATE": 10, "/readme.txt": 7, "/link": 0},
+		},
+		{
+			name:    "exclude directory",
+			opts:    filterOptions{Exclude: []string{"CERTIFICATE"}},
+			jobs:    []string{"/BDMV/STREAM/00001.m2ts", "/BDMV/STREAM/00002.m2ts", "/BDMV/index.bdmv", "/readme.txt"},
+			skipped: map[string]int64{"/CERTIFICATE": 10},
+		},
+		{
+			name:    "include",
+			opts:    filterOptions{Include: []string{"*.m2ts"}},
+			jobs:    []string{"/BDMV/STREAM/00001.m2ts", "/BDMV/STREAM/00002.m2ts"},
+			skipped: map[string]int64{"/BDMV/index.bdmv": 5, "/CERTIFICATE/id.bdmv": 4, "/CERTIFICATE/BACKUP/a.md": 6, "/readme.txt": 7, "/link": 0},
+		},
+		{
+			name:    "exclude regex",
+			opts:    filterOptions{ExcludeRegex: []string{`^/BDMV/STREAM/0000[2-9]`}},
+			jobs:    []string{"/BDMV/STREAM/00001.m2ts", "/BDMV/index.bdmv", "/CERTIFICATE/BACKUP/a.md", "/CERTIFICATE/id.bdmv", "/readme.txt"},
+			skipped: map[string]int64{"/BDMV/STREAM/00002.m2ts": 3000},
+		},
+		{
+			name:    "skeleton",
+			opts:    filterOptions{MaxSize: 1000},
+			jobs:    []string{"/BDMV/STREAM/00001.m2ts", "/BDMV/index.bdmv", "/CERTIFICATE/BACKUP/a.md", "/CERTIFICATE/id.bdmv", "/readme.txt"},
+			skipped: map[string]int64{"/BDMV/STREAM/00002.m2ts": 3000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newPathFilter(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scan := &scanResult{}
+			if err := scanISOStructure(discImage(), "/", "", 0, filter, scan); err != nil {
+				t.Fatal(err)
+			}
+
+			jobs := slices.Sorted(func(yield func(string) bool) {
+				for _, job := range scan.Jobs {
+					yield(job.SrcPath)
+				}
+			})
+			if !slices.Equal(jobs, tt.jobs) {
+				t.Errorf("got jobs %q, want %q", jobs, tt.jobs)
+			}
+
+			skipped := make(map[string]int64)
+			for _, entry := range scan.Skipped {
+				if !entry.Unsafe && !entry.Special {
+					skipped[entry.SrcPath] = entry.Size
+				}
+			}
+			if !reflect.DeepEqual(skipped, tt.skipped) {
+				t.Errorf("got skipped %v, want %v", skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestReplaceConflictingEntries(t *testing.T) {
+	dest := t.TempDir()
+	existing := []string{"BDMV/STREAM/old.m2ts", "BDMV/keep.txt", "CERTIFICATE/id.bdmv", "CERTIFICATE/stale", "readme.txt", "unrelated.txt"}
+	for _, name := range existing {
+		p := filepath.Join(dest, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// index.bdmv is filtered out, so BDMV is only partly extracted and kept
+	filter, err := newPathFilter(filterOptions{Exclude: []string{"index.bdmv"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan := &scanResult{}
+	if err := scanISOStructure(discImage(), "/", "", 0, filter, scan); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceConflictingEntries(dest, scan, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+
+	var left []string
+	filepath.WalkDir(dest, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dest, p)
+			left = append(left, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if want := []string{"BDMV/keep.txt", "unrelated.txt"}; !slices.Equal(left, want) {
+		t.Errorf("left %q in the destination, want %q", left, want)
+	}
+}
+
+func TestReplaceConflictingEntriesNestedImage(t *testing.T) {
+	dest := t.TempDir()
+	for _, name := range []string{"extras/bonus/stale.m2ts", "extras/notes.txt"} {
+		p := filepath.Join(dest, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// notes.txt is filtered out, so only the directory of the nested image is replaced
+	image := newFakeBackend(map[string]string{"/extras/bonus.iso": "image", "/extras/notes.txt": "new"})
+	filter, err := newPathFilter(filterOptions{Exclude: []string{"notes.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan := &scanResult{}
+	if err := scanISOStructure(image, "/", "", 0, filter, scan); err != nil {
+		t.Fatal(err)
+	}
+	markImageJobs(scan.Jobs)
+	if err := replaceConflictingEntries(dest, scan, slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "extras", "bonus")); !os.IsNotExist(err) {
+		t.Errorf("directory of the nested image wasn't replaced: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "extras", "notes.txt")); err != nil {
+		t.Errorf("filtered out file was removed: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// spaceCheckInterval is how often free space is queried while there is enough of it
+	spaceCheckInterval = time.Second
+	// spacePollInterval is how often free space is queried while writers are paused
+	spacePollInterval = 5 * time.Second
+)
+
+// errDiskFreeUnsupported is returned by diskFree where free space can't be queried
+var errDiskFreeUnsupported = errors.New("free space monitoring is not supported on this platform")
+
+// spaceGuard pauses every writer while the destination has less than minFree bytes
+// free and lets them continue once space has been freed. If that takes longer than
+// timeout, writes fail instead of waiting forever.
+type spaceGuard struct {
+	logger  *slog.Logger
+	dir     string
+	minFree int64
+	timeout time.Duration
+
+	mu      sync.Mutex
+	free    int64
+	checked time.Time
+}
+
+// newSpaceGuard returns nil, which never pauses, when minFree is not positive
+func newSpaceGuard(logger *slog.Logger, dir string, minFree int64, timeout time.Duration) *spaceGuard {
+	if minFree <= 0 {
+		return nil
+	}
+
+	if _, err := diskFree(dir); err != nil {
+		logger.Warn("Not monitoring free space", "dest", dir, "error", err)
+		return nil
+	}
+
+	return &spaceGuard{logger: logger, dir: dir, minFree: minFree, timeout: timeout}
+}
+
+// reserve waits until n more bytes can be written while staying above the watermark
+func (g *spaceGuard) reserve(n int64) error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Between checks the free space is estimated from what has been written
+	if time.Since(g.checked) < spaceCheckInterval && g.free-n >= g.minFree {
+		g.free -= n
+		return nil
+	}
+
+	if err := g.waitLocked(n); err != nil {
+		return err
+	}
+	g.free -= n
+
+	return nil
+}
+
+// waitForSpace is called after a write failed with ENOSPC and always re-checks
+func (g *spaceGuard) waitForSpace(n int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.checked = time.Time{}
+	return g.waitLocked(n)
+}
+
+// waitLocked polls until n bytes fit above the watermark. Holding the lock while
+// waiting is what pauses the other writers.
+func (g *spaceGuard) waitLocked(n int64) error {
+	free, err := diskFree(g.dir)
+	if err != nil {
+		return err
+	}
+	g.free, g.checked = free, time.Now()
+	if free-n >= g.minFree {
+		return nil
+	}
+
+	g.logger.Warn("Destination is low on space, pausing writers", "dest", g.dir, "free", humanize.IBytes(uint64(free)), "min_free", humanize.IBytes(uint64(g.minFree)))
+	start := time.Now()
+	for free-n < g.minFree {
+		if g.timeout > 0 && time.Since(start) > g.timeout {
+			return fmt.Errorf("destination stayed below --min-free %s for %s", humanize.IBytes(uint64(g.minFree)), g.timeout)
+		}
+
+		time.Sleep(spacePollInterval)
+		if free, err = diskFree(g.dir); err != nil {
+			return err
+		}
+	}
+	g.free, g.checked = free, time.Now()
+
+	g.logger.Info("Destination has space again, resuming writers", "dest", g.dir, "free", humanize.IBytes(uint64(free)), "paused", time.Since(start).Round(time.Second).String())
+
+	return nil
+}
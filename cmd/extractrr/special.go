@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// What to do with entries that are neither a regular file nor a directory
+const (
+	SpecialSkip        = "skip"
+	SpecialFail        = "fail"
+	SpecialPlaceholder = "placeholder"
+)
+
+var specialModes = []string{SpecialSkip, SpecialFail, SpecialPlaceholder}
+
+func validateSpecialMode(mode string) error {
+	if !slices.Contains(specialModes, mode) {
+		return fmt.Errorf("invalid special entry mode %q: must be one of %s", mode, strings.Join(specialModes, ", "))
+	}
+	return nil
+}
+
+// specialEntries returns the special entries a scan found
+func specialEntries(scan *scanResult) []skippedEntry {
+	var entries []skippedEntry
+	for _, entry := range scan.Skipped {
+		if entry.Special {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// specialPaths returns the in-image paths of the special entries for the summary
+func specialPaths(scan *scanResult) []string {
+	var paths []string
+	for _, entry := range specialEntries(scan) {
+		paths = append(paths, entry.SrcPath)
+	}
+	return paths
+}
+
+// checkSpecialEntries logs every special entry and fails in fail mode, before anything is written
+func checkSpecialEntries(logger *slog.Logger, scan *scanResult, mode string) error {
+	entries := specialEntries(scan)
+	for _, entry := range entries {
+		switch mode {
+		case SpecialPlaceholder:
+			logger.Warn("Writing empty placeholder for special entry", "file", entry.SrcPath)
+		default:
+			logger.Warn("Skipping special entry", "file", entry.SrcPath)
+		}
+	}
+
+	if mode == SpecialFail && len(entries) > 0 {
+		return fmt.Errorf("image has %d special entries, e.g. %s, use --special skip or placeholder to extract it anyway", len(entries), entries[0].SrcPath)
+	}
+	return nil
+}
+
+// createPlaceholders writes an empty file in place of every special entry, so the
+// extracted tree has an entry for each one in the image
+func createPlaceholders(root *destRoot, scan *scanResult) error {
+	for _, entry := range specialEntries(scan) {
+		path, err := root.join(entry.DstPath)
+		if err != nil {
+			return err
+		}
+		if err := root.mkdirAll(filepath.Dir(path)); err != nil {
+			return err
+		}
+		f, err := createFile(path, false)
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// SplitManifestSuffix names the manifest written next to the parts of a split file
+const SplitManifestSuffix = ".parts.json"
+
+// splitPartSize leaves headroom below the 4 GiB - 1 byte limit of FAT32
+const splitPartSize = 4<<30 - 1<<20
+
+// splitManifest describes how a file was split, so it can be joined and checked again
+type splitManifest struct {
+	Name     string   `json:"name"`
+	Size     int64    `json:"size"`
+	PartSize int64    `json:"part_size"`
+	Parts    []string `json:"parts"`
+}
+
+// partName returns the path of the nth part of base, counting from 1
+func partName(base string, n int) string {
+	return fmt.Sprintf("%s.%03d", base, n)
+}
+
+// markSplitJobs flags the jobs too large for the filesystem of extractDir to be written in parts
+func markSplitJobs(extractDir string, jobs []Job) int {
+	fstype, err := detectFSType(extractDir)
+	if err != nil {
+		return 0
+	}
+	limit := knownFSLimits[fstype].MaxFileSize
+	if limit <= 0 {
+		return 0
+	}
+
+	count := 0
+	for i := range jobs {
+		if jobs[i].Size > limit {
+			jobs[i].Split = true
+			count++
+		}
+	}
+	return count
+}
+
+// partWriter writes a file as numbered parts of at most splitPartSize bytes
+type partWriter struct {
+	base string
+	// partial writes every part with PartialSuffix until commit
+	partial bool
+	parts   []string
+	cur     *os.File
+	curSize int64
+}
+
+func (p *partWriter) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		if p.cur == nil || p.curSize == splitPartSize {
+			if err := p.next(); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := p.cur.Write(b[written : written+int(min(int64(len(b)-written), splitPartSize-p.curSize))])
+		written += n
+		p.curSize += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// next closes the current part and starts the next one
+func (p *partWriter) next() error {
+	if err := p.Close(); err != nil {
+		return err
+	}
+
+	path := partName(p.base, len(p.parts)+1)
+	if p.partial {
+		path += PartialSuffix
+	}
+	f, err := createFile(path, p.partial)
+	if err != nil {
+		return err
+	}
+
+	p.parts = append(p.parts, path)
+	p.cur, p.curSize = f, 0
+
+	return nil
+}
+
+func (p *partWriter) Close() error {
+	if p.cur == nil {
+		return nil
+	}
+	err := p.cur.Close()
+	p.cur = nil
+	return err
+}
+
+// remove deletes the parts written so far
+func (p *partWriter) remove() {
+	p.Close()
+	for _, path := range p.parts {
+		os.Remove(path)
+	}
+}
+
+// commit renames partial parts into place and writes the manifest. A file of the same
+// name from an earlier unsplit extraction is removed so readers find the parts.
+func (p *partWriter) commit(ctx context.Context, size int64) error {
+	if err := p.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	manifest := splitManifest{Name: filepath.Base(p.base), Size: size, PartSize: splitPartSize}
+	for _, path := range p.parts {
+		final := strings.TrimSuffix(path, PartialSuffix)
+		if p.partial {
+			if err := os.Rename(path, final); err != nil {
+				return err
+			}
+		}
+		manifest.Parts = append(manifest.Parts, filepath.Base(final))
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := createFile(p.base+SplitManifestSuffix, false)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(p.base); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// readSplitManifest reads the manifest of the split file at path
+func readSplitManifest(path string) (*splitManifest, error) {
+	data, err := os.ReadFile(path + SplitManifestSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest splitManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid split manifest for %s: %w", path, err)
+	}
+	for _, part := range manifest.Parts {
+		if part != filepath.Base(part) {
+			return nil, fmt.Errorf("invalid split manifest for %s: part %q is not in the same directory", path, part)
+		}
+	}
+
+	return &manifest, nil
+}
+
+// extractedFile reads an extracted file, joining the parts of a split one
+type extractedFile struct {
+	io.Reader
+	Size  int64
+	files []*os.File
+}
+
+// openExtracted opens the extracted file at path, or the parts it was split into
+func openExtracted(path string) (*extractedFile, error) {
+	f, err := os.Open(path)
+	if err == nil {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &extractedFile{Reader: f, Size: info.Size(), files: []*os.File{f}}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	manifest, merr := readSplitManifest(path)
+	if errors.Is(merr, os.ErrNotExist) {
+		return nil, err
+	}
+	if merr != nil {
+		return nil, merr
+	}
+
+	ef := &extractedFile{}
+	readers := make([]io.Reader, 0, len(manifest.Parts))
+	for _, part := range manifest.Parts {
+		pf, err := os.Open(filepath.Join(filepath.Dir(path), part))
+		if err != nil {
+			ef.Close()
+			return nil, err
+		}
+		ef.files = append(ef.files, pf)
+
+		info, err := pf.Stat()
+		if err != nil {
+			ef.Close()
+			return nil, err
+		}
+		ef.Size += info.Size()
+		readers = append(readers, pf)
+	}
+	ef.Reader = io.MultiReader(readers...)
+
+	return ef, nil
+}
+
+// extractedSize returns the size of the extracted file at path, split or not
+func extractedSize(path string) (int64, error) {
+	f, err := openExtracted(path)
+	if err != nil {
+		return 0, err
+	}
+	f.Close()
+	return f.Size, nil
+}
+
+func (f *extractedFile) Close() error {
+	var err error
+	for _, file := range f.files {
+		err = errors.Join(err, file.Close())
+	}
+	return err
+}
+
+// splitBase returns the original path for a split file given as itself, its manifest or a part
+func splitBase(path string) string {
+	if base, ok := strings.CutSuffix(path, SplitManifestSuffix); ok {
+		return base
+	}
+	if ext := filepath.Ext(path); len(ext) == 4 && strings.Trim(ext[1:], "0123456789") == "" {
+		return strings.TrimSuffix(path, ext)
+	}
+	return path
+}
+
+func CommandJoin() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "join",
+		Short: "Join a file split by --split-over-4g",
+		Long: `Join a file split by --split-over-4g
+
+Concatenates the parts listed in the split manifest into the original file and
+checks the result has the recorded size. The file may be given by its original
+name, its manifest or any of its parts.`,
+		Example: `  extractrr join /mnt/usb/BDMV/STREAM/00001.m2ts
+  extractrr join /mnt/usb/BDMV/STREAM/00001.m2ts.001 /path/to/00001.m2ts --remove`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 || len(args) > 2 {
+				return fmt.Errorf("requires one or two args")
+			}
+			return nil
+		},
+	}
+
+	var (
+		remove = command.Flags().Bool("remove", false, "Remove the parts and the manifest once joined")
+	)
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		base := splitBase(args[0])
+		output := base
+		if len(args) == 2 {
+			output = args[1]
+		}
+
+		manifest, err := readSplitManifest(base)
+		if err != nil {
+			return fmt.Errorf("failed to read split manifest: %w", err)
+		}
+
+		in, err := openExtracted(base)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		// Written as partial file first, so the parts stay the only copy until the join is complete
+		out, err := createFile(output+PartialSuffix, true)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(out, in)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil && n != manifest.Size {
+			err = fmt.Errorf("joined %d bytes, manifest records %d", n, manifest.Size)
+		}
+		if err != nil {
+			os.Remove(output + PartialSuffix)
+			return fmt.Errorf("failed to join %s: %w", base, err)
+		}
+		if err := os.Rename(output+PartialSuffix, output); err != nil {
+			return err
+		}
+
+		if *remove {
+			dir := filepath.Dir(base)
+			for _, part := range manifest.Parts {
+				if err := os.Remove(filepath.Join(dir, part)); err != nil {
+					return err
+				}
+			}
+			if err := os.Remove(base + SplitManifestSuffix); err != nil {
+				return err
+			}
+		}
+
+		slog.Info("Joined split file", "file", output, "parts", len(manifest.Parts), "bytes", n)
+
+		return nil
+	}
+
+	return command
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Actions taken when the whole pool stops making progress
+const (
+	StuckWarn   = "warn"
+	StuckNotify = "notify"
+	StuckAbort  = "abort"
+)
+
+var stuckActions = []string{StuckWarn, StuckNotify, StuckAbort}
+
+// errStuck is returned when an extraction was aborted because no data moved
+var errStuck = errors.New("aborted stuck extraction")
+
+func validateStuckAction(action string) error {
+	if !slices.Contains(stuckActions, action) {
+		return fmt.Errorf("invalid stuck action %q: must be one of %s", action, strings.Join(stuckActions, ", "))
+	}
+	return nil
+}
+
+// stuckWatch configures how a pool reacts to throughput dropping to zero, e.g. on a hung
+// NFS mount or a dying disk. A zero After disables it.
+type stuckWatch struct {
+	// After is how long no data may move before the pool counts as stuck
+	After  time.Duration
+	Action string
+	// Source names the image in notifications
+	Source string
+}
+
+// watch checks whether the pool is stuck or workers hang until done is closed
+func (s *poolState) watch(watch stuckWatch, hungAfter time.Duration, done <-chan struct{}) {
+	interval := time.Second
+	for _, d := range []time.Duration{watch.After, hungAfter} {
+		if d > 0 {
+			interval = min(interval, d/4)
+		}
+	}
+	if watch.After <= 0 && hungAfter <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(max(interval, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if hungAfter > 0 {
+				s.checkHung(hungAfter)
+			}
+			if watch.After > 0 {
+				s.checkStuck(watch)
+			}
+		}
+	}
+}
+
+// checkStuck reports each stretch without progress once and carries out the action
+func (s *poolState) checkStuck(watch stuckWatch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idle := time.Since(s.lastProgress)
+	if s.stuck || s.aborted != nil || idle < watch.After {
+		return
+	}
+
+	var busy []any
+	for id, w := range s.workers {
+		if w.busy && !w.abandoned {
+			busy = append(busy, slog.Group(fmt.Sprintf("worker%d", id), "file", w.file, "idle", time.Since(w.lastProgress).Round(time.Second).String()))
+		}
+	}
+	if len(busy) == 0 {
+		return
+	}
+
+	s.stuck = true
+	s.logger.Warn("No progress, extraction looks stuck", append([]any{"idle", idle.Round(time.Second).String()}, busy...)...)
+
+	switch watch.Action {
+	case StuckNotify:
+		// Notifying runs a command, don't hold up the workers meanwhile
+		go func() {
+			message := msg(MsgNotifyStuck, messageData{Image: imageBaseName(watch.Source), Duration: idle.Round(time.Second).String()})
+			if err := notifyDesktop(msg(MsgNotifyStuckTitle, messageData{}), message); err != nil {
+				s.logger.Warn("Failed to show desktop notification", "error", err)
+			}
+		}()
+	case StuckAbort:
+		s.abortLocked(fmt.Errorf("%w: no progress for %s", errStuck, idle.Round(time.Second)))
+	}
+}
+
+// abort ends the run with err unless it already ended
+func (s *poolState) abort(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.aborted == nil {
+		s.abortLocked(err)
+	}
+}
+
+// abortLocked stops handing out jobs and gives up on the busy workers
+func (s *poolState) abortLocked(err error) {
+	s.aborted = err
+	for _, w := range s.workers {
+		if w.busy && !w.abandoned {
+			s.results[w.job].Err = err
+			s.abandonLocked(w)
+		}
+	}
+	s.cond.Broadcast()
+	s.logger.Error("Aborting extraction", "error", err)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestPoolStateAbortWhenStuck(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := newPoolState(logger, []Job{{SrcPath: "/a"}, {SrcPath: "/b"}, {SrcPath: "/c"}}, nil, 0)
+
+	startWorkers(s, 2)
+	if _, ok := s.next(0); !ok {
+		t.Fatal("worker 0 got no job")
+	}
+	if _, ok := s.next(1); !ok {
+		t.Fatal("worker 1 got no job")
+	}
+
+	// Worker 0 finishes its job, worker 1 hangs on its
+	s.progress(0, 5)
+	s.finish(0, fileResult{})
+
+	s.lastProgress = time.Now().Add(-time.Minute)
+	s.checkStuck(stuckWatch{After: time.Second, Action: StuckAbort})
+	if s.aborted == nil {
+		t.Fatal("pool was not aborted")
+	}
+
+	if _, ok := s.next(0); ok {
+		t.Fatal("aborted pool handed out a job")
+	}
+	s.exit(0)
+
+	// The hung worker was let go of, so waiting must not block on it
+	waited := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool still waits for the abandoned worker")
+	}
+
+	// Whatever the abandoned worker reports later is discarded
+	s.progress(1, 10)
+	s.finish(1, fileResult{})
+	s.exit(1)
+
+	if s.processed != 5 {
+		t.Errorf("processed = %d, want 5", s.processed)
+	}
+	if s.results[0].Err != nil {
+		t.Errorf("finished job failed: %v", s.results[0].Err)
+	}
+	if s.results[1].Err != s.aborted {
+		t.Errorf("abandoned job error = %v, want %v", s.results[1].Err, s.aborted)
+	}
+	if s.results[2].Err == nil {
+		t.Error("job no worker got to succeeded")
+	}
+}
+
+func TestPoolStateWarnsOncePerStretch(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := newPoolState(logger, []Job{{SrcPath: "/a"}}, nil, 0)
+
+	startWorkers(s, 1)
+	s.next(0)
+
+	s.lastProgress = time.Now().Add(-time.Minute)
+	s.checkStuck(stuckWatch{After: time.Second, Action: StuckWarn})
+	if !s.stuck || s.aborted != nil {
+		t.Fatalf("stuck = %v, aborted = %v, want a warning only", s.stuck, s.aborted)
+	}
+
+	s.progress(0, 1)
+	if s.stuck {
+		t.Error("progress did not clear the stuck state")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// writeRampInterval is how long write latency has to stay healthy before another writer is let back in
+const writeRampInterval = 5 * time.Second
+
+// writeGate limits how many workers write to the destination at once. It starts
+// with every worker allowed and halves the limit when the smoothed write latency
+// stays above the threshold, e.g. on SMR or USB disks whose cache has filled up,
+// then lets writers back in one at a time once latency has recovered.
+type writeGate struct {
+	logger    *slog.Logger
+	threshold time.Duration
+	max       int
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	limit      int
+	active     int
+	latency    time.Duration
+	lastChange time.Time
+}
+
+// newWriteGate returns nil, which lets every write through, when threshold is not positive
+func newWriteGate(logger *slog.Logger, workers int, threshold time.Duration) *writeGate {
+	if threshold <= 0 {
+		return nil
+	}
+
+	g := &writeGate{
+		logger:     logger,
+		threshold:  threshold,
+		max:        workers,
+		limit:      workers,
+		lastChange: time.Now(),
+	}
+	g.cond = sync.NewCond(&g.mu)
+
+	return g
+}
+
+// acquire waits until the worker may write
+func (g *writeGate) acquire() {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.active >= g.limit {
+		g.cond.Wait()
+	}
+	g.active++
+}
+
+// release ends a write that took d and adjusts the limit
+func (g *writeGate) release(d time.Duration) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.active--
+	if g.latency == 0 {
+		g.latency = d
+	} else {
+		g.latency = (g.latency*4 + d) / 5
+	}
+
+	since := time.Since(g.lastChange)
+	switch {
+	case g.latency > g.threshold && g.limit > 1 && since > g.threshold:
+		g.limit = max(g.limit/2, 1)
+		g.lastChange = time.Now()
+		g.logger.Warn("Destination is stalling, reducing writers", "writers", g.limit, "latency", g.latency.String())
+	case g.latency < g.threshold/4 && g.limit < g.max && since > writeRampInterval:
+		g.limit++
+		g.lastChange = time.Now()
+		g.logger.Info("Destination recovered, adding writer", "writers", g.limit, "latency", g.latency.String())
+	}
+
+	g.cond.Broadcast()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// slowestFiles is how many of the slowest files a timing summary lists
+const slowestFiles = 10
+
+// SidecarTiming summarizes how long the files of a run took, to tell whether per-file
+// overhead on many small files or raw bandwidth on the big streams dominated
+type SidecarTiming struct {
+	Files      int               `json:"files"`
+	Duration   TimingPercentiles `json:"duration"`
+	Throughput TimingPercentiles `json:"throughput"`
+	Slowest    []SidecarFileTime `json:"slowest"`
+}
+
+// TimingPercentiles are nanoseconds for durations and bytes per second for throughput
+type TimingPercentiles struct {
+	P50 int64 `json:"p50"`
+	P95 int64 `json:"p95"`
+	Max int64 `json:"max"`
+}
+
+// SidecarFileTime is the timing of a single file
+type SidecarFileTime struct {
+	Path       string        `json:"path"`
+	Size       int64         `json:"size"`
+	Duration   time.Duration `json:"duration"`
+	Throughput int64         `json:"throughput"`
+}
+
+// summarizeTiming returns the timing summary of the files extracted in this run, or nil if there are none
+func summarizeTiming(files []SidecarFile) *SidecarTiming {
+	var times []SidecarFileTime
+	for _, file := range files {
+		if file.Duration > 0 {
+			times = append(times, SidecarFileTime{
+				Path:       file.Path,
+				Size:       file.Size,
+				Duration:   file.Duration,
+				Throughput: int64(float64(file.Size) / file.Duration.Seconds()),
+			})
+		}
+	}
+	if len(times) == 0 {
+		return nil
+	}
+
+	durations := make([]int64, len(times))
+	throughputs := make([]int64, len(times))
+	for i, t := range times {
+		durations[i] = int64(t.Duration)
+		throughputs[i] = t.Throughput
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Duration > times[j].Duration })
+
+	return &SidecarTiming{
+		Files:      len(times),
+		Duration:   percentiles(durations),
+		Throughput: percentiles(throughputs),
+		Slowest:    times[:min(len(times), slowestFiles)],
+	}
+}
+
+// percentiles uses the nearest rank on the sorted values
+func percentiles(values []int64) TimingPercentiles {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	rank := func(p float64) int64 {
+		i := int(p*float64(len(values))+0.5) - 1
+		return values[min(max(i, 0), len(values)-1)]
+	}
+	return TimingPercentiles{P50: rank(0.50), P95: rank(0.95), Max: values[len(values)-1]}
+}
@@ -0,0 +1,124 @@
+package main
+
+/*
+#include <udfread/udfread.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// udfBackend reads images with libudfread
+type udfBackend struct {
+	image *imageHandle
+}
+
+func openUDFBackend(src *imageSource) (SourceBackend, error) {
+	image, err := openImage(src)
+	if err != nil {
+		return nil, err
+	}
+	return &udfBackend{image: image}, nil
+}
+
+func (b *udfBackend) Stat(path string) (entryInfo, error) {
+	file := openUDFFile(b.image.udf, path)
+	if file == nil {
+		// libudfread can only open regular files, anything else may be a directory
+		dir := openUDFDir(b.image.udf, path)
+		if dir == nil {
+			return entryInfo{}, fmt.Errorf("failed to open file: %s", path)
+		}
+		C.udfread_closedir(dir)
+		return entryInfo{IsDir: true, Block: -1}, nil
+	}
+	defer C.udfread_file_close(file)
+
+	size := int64(C.udfread_file_size(file))
+	if size < 0 {
+		return entryInfo{}, fmt.Errorf("failed to get file size: %s", path)
+	}
+
+	block := int64(-1)
+	if size > 0 {
+		block = int64(uint32(C.udfread_file_lba(file, 0)))
+	}
+
+	return entryInfo{Size: size, Block: block}, nil
+}
+
+func (b *udfBackend) ReadDir(path string) ([]dirEntry, error) {
+	dir := openUDFDir(b.image.udf, path)
+	if dir == nil {
+		return nil, fmt.Errorf("failed to open directory: %s", path)
+	}
+	defer C.udfread_closedir(dir)
+
+	var entries []dirEntry
+	for {
+		var dirent C.struct_udfread_dirent
+		if C.udfread_readdir(dir, &dirent) == nil {
+			break
+		}
+
+		name := C.GoString(dirent.d_name)
+		if name == "." || name == ".." {
+			continue
+		}
+		entries = append(entries, dirEntry{Name: name, IsDir: dirent.d_type == C.UDF_DT_DIR, IsRegular: dirent.d_type == C.UDF_DT_REG})
+	}
+
+	return entries, nil
+}
+
+func (b *udfBackend) OpenFile(path string) (entryFile, error) {
+	file := openUDFFile(b.image.udf, path)
+	if file == nil {
+		return nil, fmt.Errorf("failed to open file: %s", path)
+	}
+	return &udfFile{file: file, path: path}, nil
+}
+
+func (b *udfBackend) VolumeLabel() string {
+	if label := C.udfread_get_volume_id(b.image.udf); label != nil {
+		return strings.TrimSpace(C.GoString(label))
+	}
+	return ""
+}
+
+func (b *udfBackend) Close() {
+	b.image.close()
+}
+
+// udfFile is a file opened with libudfread
+type udfFile struct {
+	file *C.UDFFILE
+	path string
+}
+
+func (f *udfFile) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n := readUDFFile(f.file, f.path, p)
+	switch {
+	case n > 0:
+		return int(n), nil
+	case n == 0:
+		return 0, io.EOF
+	default:
+		return 0, fmt.Errorf("failed to read %s", f.path)
+	}
+}
+
+func (f *udfFile) Size() int64 {
+	return int64(C.udfread_file_size(f.file))
+}
+
+func (f *udfFile) Close() error {
+	C.udfread_file_close(f.file)
+	return nil
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// udfBlockSize is the sector size of UDF images and the logical block size mkimage writes
+const udfBlockSize = 2048
+
+// Where the volume structures of a written image go, the partition follows the anchor
+const (
+	udfMainVDS    = 32
+	udfReserveVDS = 48
+	udfVDSBlocks  = 16
+	udfIntegrity  = 64
+	udfAnchor     = 256
+	udfPartition  = 257
+)
+
+// udfMaxExtent is the longest extent a short allocation descriptor can record
+const udfMaxExtent = 1<<30 - udfBlockSize
+
+// udfFirstUniqueID is the unique id of the first entry below the root, 1-15 are reserved
+const udfFirstUniqueID = 16
+
+// Descriptor tag identifiers of ECMA-167
+const (
+	tagPrimaryVolume     = 1
+	tagAnchor            = 2
+	tagImplementationUse = 4
+	tagPartition         = 5
+	tagLogicalVolume     = 6
+	tagUnallocatedSpace  = 7
+	tagTerminating       = 8
+	tagIntegrity         = 9
+	tagFileSet           = 256
+	tagFileIdentifier    = 257
+	tagFileEntry         = 261
+)
+
+// udfDescriptorVersion is the tag version of NSR02, the UDF 1.02 descriptors
+const udfDescriptorVersion = 2
+
+// File entry and file identifier fields
+const (
+	udfFileTypeDirectory  = 4
+	udfFileTypeRegular    = 5
+	udfCharDirectory      = 0x02
+	udfCharParent         = 0x08
+	udfPermissionsFile    = 0x1084 // read for everyone
+	udfPermissionsDir     = 0x14a5 // read and search for everyone
+	udfFileEntryHeaderLen = 176
+)
+
+// udfLE is the byte order of every UDF field
+var udfLE = binary.LittleEndian
+
+// udfNode is an entry of an image to write. Nodes refer to their parent by index, the
+// root is the first node and its own parent.
+type udfNode struct {
+	Name   string
+	Dir    bool
+	Parent int
+	// Size is the file size, directories get the size of their identifiers
+	Size int64
+
+	children []int
+	icb      uint32
+	data     uint32
+}
+
+// udfLayout is where everything of an image goes, in logical blocks of the partition
+type udfLayout struct {
+	nodes []udfNode
+	// blocks is the length of the partition
+	blocks uint32
+	files  uint32
+	dirs   uint32
+}
+
+// udfFillFunc writes the content of the file node at offset into buf
+type udfFillFunc func(node int, offset int64, buf []byte)
+
+// layoutUDF assigns blocks to the nodes: the file set descriptor first, then every file
+// entry and directory, then the file data in node order
+func layoutUDF(nodes []udfNode) (*udfLayout, error) {
+	l := &udfLayout{nodes: nodes}
+	for i := range nodes {
+		nodes[i].children = nil
+	}
+	for i := 1; i < len(nodes); i++ {
+		parent := nodes[i].Parent
+		nodes[parent].children = append(nodes[parent].children, i)
+	}
+
+	next := int64(2)
+	for i := range nodes {
+		n := &nodes[i]
+		n.icb = uint32(next)
+		next++
+		if !n.Dir {
+			l.files++
+			if extents := (n.Size + udfMaxExtent - 1) / udfMaxExtent; udfFileEntryHeaderLen+8*extents > udfBlockSize {
+				return nil, fmt.Errorf("%s is too large for a single file entry", n.Name)
+			}
+			continue
+		}
+		l.dirs++
+		n.Size = fidLength("")
+		for _, child := range n.children {
+			n.Size += fidLength(nodes[child].Name)
+		}
+		n.data = uint32(next)
+		next += udfBlocks(n.Size)
+	}
+	for i := range nodes {
+		if n := &nodes[i]; !n.Dir {
+			n.data = uint32(next)
+			next += udfBlocks(n.Size)
+		}
+	}
+
+	if udfPartition+next+1 > 1<<32 {
+		return nil, fmt.Errorf("image too large for UDF")
+	}
+	l.blocks = uint32(next)
+
+	return l, nil
+}
+
+// size returns the size of the whole image in bytes
+func (l *udfLayout) size() int64 {
+	return int64(udfPartition+l.blocks+1) * udfBlockSize
+}
+
+// writeUDF writes the image in a single pass, fill provides the file contents
+func writeUDF(out io.Writer, l *udfLayout, label string, recorded time.Time, fill udfFillFunc) error {
+	w := &udfWriter{w: bufio.NewWriterSize(out, 1<<20)}
+	recorded = recorded.UTC()
+
+	// System area and the volume recognition sequence
+	w.zero(16)
+	for _, id := range []string{"BEA01", "NSR02", "TEA01"} {
+		b := make([]byte, udfBlockSize)
+		copy(b[1:], id)
+		b[6] = 1
+		w.block(b)
+	}
+
+	w.zero(udfMainVDS - w.sector)
+	w.vds(l, label, recorded, udfMainVDS)
+	w.vds(l, label, recorded, udfReserveVDS)
+	w.integrity(l, recorded)
+
+	w.zero(udfAnchor - w.sector)
+	w.anchor()
+
+	// Partition: the file set, the entries and directories, then the data
+	fsd := make([]byte, 512)
+	udfTimestamp(fsd[16:], recorded)
+	udfLE.PutUint16(fsd[28:], 3)
+	udfLE.PutUint16(fsd[30:], 3)
+	udfLE.PutUint32(fsd[32:], 1)
+	udfLE.PutUint32(fsd[36:], 1)
+	udfCharspec(fsd[48:])
+	udfDstring(fsd[112:240], label)
+	udfCharspec(fsd[240:])
+	udfDstring(fsd[304:336], label)
+	udfLongAD(fsd[400:], udfBlockSize, l.nodes[0].icb, 0)
+	udfDomain(fsd[416:])
+	udfTag(fsd, tagFileSet, 0)
+	w.block(fsd)
+	w.terminator(1)
+
+	for i := range l.nodes {
+		w.fileEntry(l, i, recorded)
+		if l.nodes[i].Dir {
+			w.directory(l, i)
+		}
+	}
+
+	buf := make([]byte, 1<<20)
+	for i, n := range l.nodes {
+		if n.Dir {
+			continue
+		}
+		for offset := int64(0); offset < n.Size; {
+			chunk := buf[:min(int64(len(buf)), n.Size-offset)]
+			fill(i, offset, chunk)
+			w.write(chunk)
+			offset += int64(len(chunk))
+		}
+		w.pad()
+	}
+
+	w.anchor()
+
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// udfWriter writes an image sequentially and keeps track of the sector it is at
+type udfWriter struct {
+	w      *bufio.Writer
+	sector uint32
+	// partial is how far into the current sector the last write went
+	partial int
+	err     error
+}
+
+func (w *udfWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.w.Write(p)
+	w.partial += len(p)
+	w.sector += uint32(w.partial / udfBlockSize)
+	w.partial %= udfBlockSize
+}
+
+// block writes b padded to a whole sector
+func (w *udfWriter) block(b []byte) {
+	w.write(b)
+	w.pad()
+}
+
+// pad fills the rest of the current sector with zeros
+func (w *udfWriter) pad() {
+	if w.partial > 0 {
+		w.write(make([]byte, udfBlockSize-w.partial))
+	}
+}
+
+// zero writes n empty sectors
+func (w *udfWriter) zero(n uint32) {
+	empty := make([]byte, udfBlockSize)
+	for range n {
+		w.write(empty)
+	}
+}
+
+// anchor writes an anchor volume descriptor pointer at the current sector
+func (w *udfWriter) anchor() {
+	b := make([]byte, 512)
+	udfExtent(b[16:], udfVDSBlocks*udfBlockSize, udfMainVDS)
+	udfExtent(b[24:], udfVDSBlocks*udfBlockSize, udfReserveVDS)
+	udfTag(b, tagAnchor, w.sector)
+	w.block(b)
+}
+
+// terminator writes a terminating descriptor recorded at location
+func (w *udfWriter) terminator(location uint32) {
+	b := make([]byte, 512)
+	udfTag(b, tagTerminating, location)
+	w.block(b)
+}
+
+// vds writes a volume descriptor sequence at base, ending with the rest of its extent
+func (w *udfWriter) vds(l *udfLayout, label string, recorded time.Time, base uint32) {
+	pvd := make([]byte, 512)
+	udfLE.PutUint32(pvd[16:], 1)
+	udfDstring(pvd[24:56], label)
+	udfLE.PutUint16(pvd[56:], 1)
+	udfLE.PutUint16(pvd[58:], 1)
+	udfLE.PutUint16(pvd[60:], 2)
+	udfLE.PutUint16(pvd[62:], 2)
+	udfLE.PutUint32(pvd[64:], 1)
+	udfLE.PutUint32(pvd[68:], 1)
+	udfDstring(pvd[72:200], fmt.Sprintf("%016X%s", recorded.Unix(), label))
+	udfCharspec(pvd[200:])
+	udfCharspec(pvd[264:])
+	udfTimestamp(pvd[376:], recorded)
+	udfImplementation(pvd[388:])
+	udfTag(pvd, tagPrimaryVolume, base)
+	w.block(pvd)
+
+	iuvd := make([]byte, 512)
+	udfLE.PutUint32(iuvd[16:], 2)
+	udfEntity(iuvd[20:], "*UDF LV Info")
+	udfCharspec(iuvd[52:])
+	udfDstring(iuvd[116:244], label)
+	udfImplementation(iuvd[352:])
+	udfTag(iuvd, tagImplementationUse, base+1)
+	w.block(iuvd)
+
+	pd := make([]byte, 512)
+	udfLE.PutUint32(pd[16:], 3)
+	udfLE.PutUint16(pd[20:], 1)
+	copy(pd[25:], "+NSR02")
+	udfLE.PutUint32(pd[184:], 1) // read only
+	udfLE.PutUint32(pd[188:], udfPartition)
+	udfLE.PutUint32(pd[192:], l.blocks)
+	udfImplementation(pd[196:])
+	udfTag(pd, tagPartition, base+2)
+	w.block(pd)
+
+	lvd := make([]byte, 446)
+	udfLE.PutUint32(lvd[16:], 4)
+	udfCharspec(lvd[20:])
+	udfDstring(lvd[84:212], label)
+	udfLE.PutUint32(lvd[212:], udfBlockSize)
+	udfDomain(lvd[216:])
+	udfLongAD(lvd[248:], udfBlockSize, 0, 0)
+	udfLE.PutUint32(lvd[264:], 6)
+	udfLE.PutUint32(lvd[268:], 1)
+	udfImplementation(lvd[272:])
+	udfExtent(lvd[432:], 2*udfBlockSize, udfIntegrity)
+	lvd[440], lvd[441] = 1, 6 // type 1 map of partition 0 on volume 1
+	udfLE.PutUint16(lvd[442:], 1)
+	udfTag(lvd, tagLogicalVolume, base+3)
+	w.block(lvd)
+
+	usd := make([]byte, 24)
+	udfLE.PutUint32(usd[16:], 5)
+	udfTag(usd, tagUnallocatedSpace, base+4)
+	w.block(usd)
+
+	w.terminator(base + 5)
+	w.zero(udfVDSBlocks - 6)
+}
+
+// integrity writes a closed logical volume integrity descriptor and its terminator
+func (w *udfWriter) integrity(l *udfLayout, recorded time.Time) {
+	b := make([]byte, 134)
+	udfTimestamp(b[16:], recorded)
+	udfLE.PutUint32(b[28:], 1) // close
+	udfLE.PutUint64(b[40:], uint64(udfFirstUniqueID+len(l.nodes)))
+	udfLE.PutUint32(b[72:], 1)
+	udfLE.PutUint32(b[76:], 46)
+	udfLE.PutUint32(b[84:], l.blocks)
+	udfImplementation(b[88:])
+	udfLE.PutUint32(b[120:], l.files)
+	udfLE.PutUint32(b[124:], l.dirs)
+	udfLE.PutUint16(b[128:], 0x0102)
+	udfLE.PutUint16(b[130:], 0x0102)
+	udfLE.PutUint16(b[132:], 0x0102)
+	udfTag(b, tagIntegrity, udfIntegrity)
+	w.block(b)
+	w.terminator(udfIntegrity + 1)
+}
+
+// fileEntry writes the file entry of node i with short allocation descriptors for its data
+func (w *udfWriter) fileEntry(l *udfLayout, i int, recorded time.Time) {
+	n := l.nodes[i]
+
+	var extents [][2]uint32
+	for offset := int64(0); offset < n.Size; offset += udfMaxExtent {
+		length := min(n.Size-offset, udfMaxExtent)
+		extents = append(extents, [2]uint32{uint32(length), n.data + uint32(offset/udfBlockSize)})
+	}
+
+	b := make([]byte, udfFileEntryHeaderLen+8*len(extents))
+	udfLE.PutUint16(b[20:], 4) // strategy
+	udfLE.PutUint16(b[24:], 1)
+	links := uint16(1)
+	if n.Dir {
+		b[27] = udfFileTypeDirectory
+		udfLE.PutUint32(b[44:], udfPermissionsDir)
+		for _, child := range n.children {
+			if l.nodes[child].Dir {
+				links++
+			}
+		}
+	} else {
+		b[27] = udfFileTypeRegular
+		udfLE.PutUint32(b[44:], udfPermissionsFile)
+	}
+	udfLE.PutUint32(b[36:], 0xffffffff)
+	udfLE.PutUint32(b[40:], 0xffffffff)
+	udfLE.PutUint16(b[48:], links)
+	udfLE.PutUint64(b[56:], uint64(n.Size))
+	udfLE.PutUint64(b[64:], uint64(udfBlocks(n.Size)))
+	udfTimestamp(b[72:], recorded)
+	udfTimestamp(b[84:], recorded)
+	udfTimestamp(b[96:], recorded)
+	udfLE.PutUint32(b[108:], 1)
+	udfImplementation(b[128:])
+	udfLE.PutUint64(b[160:], udfUniqueID(i))
+	udfLE.PutUint32(b[172:], uint32(8*len(extents)))
+	for j, extent := range extents {
+		udfLE.PutUint32(b[udfFileEntryHeaderLen+8*j:], extent[0])
+		udfLE.PutUint32(b[udfFileEntryHeaderLen+8*j+4:], extent[1])
+	}
+	udfTag(b, tagFileEntry, n.icb)
+	w.block(b)
+}
+
+// directory writes the file identifiers of directory node i, its parent first
+func (w *udfWriter) directory(l *udfLayout, i int) {
+	n := l.nodes[i]
+	data := make([]byte, 0, n.Size)
+
+	add := func(name string, chars byte, node int) {
+		b := make([]byte, fidLength(name))
+		udfLE.PutUint16(b[16:], 1)
+		b[18] = chars
+		if name != "" {
+			b[19] = byte(1 + len(name))
+			b[38] = 8
+			copy(b[39:], name)
+		}
+		udfLongAD(b[20:], udfBlockSize, l.nodes[node].icb, uint32(udfUniqueID(node)))
+		udfTag(b, tagFileIdentifier, n.data+uint32(len(data)/udfBlockSize))
+		data = append(data, b...)
+	}
+
+	add("", udfCharDirectory|udfCharParent, n.Parent)
+	for _, child := range n.children {
+		var chars byte
+		if l.nodes[child].Dir {
+			chars = udfCharDirectory
+		}
+		add(l.nodes[child].Name, chars, child)
+	}
+
+	w.block(data)
+}
+
+// fidLength returns the length of a file identifier descriptor, an empty name is the parent
+func fidLength(name string) int64 {
+	length := 38
+	if name != "" {
+		length += 1 + len(name)
+	}
+	return int64(length+3) &^ 3
+}
+
+// udfBlocks returns how many blocks size bytes take
+func udfBlocks(size int64) int64 {
+	return (size + udfBlockSize - 1) / udfBlockSize
+}
+
+// udfUniqueID returns the unique id of node i, the root has 0
+func udfUniqueID(i int) uint64 {
+	if i == 0 {
+		return 0
+	}
+	return uint64(udfFirstUniqueID + i)
+}
+
+// udfTag fills in the descriptor tag of b, which must be the whole descriptor
+func udfTag(b []byte, ident uint16, location uint32) {
+	udfLE.PutUint16(b[0:], ident)
+	udfLE.PutUint16(b[2:], udfDescriptorVersion)
+	udfLE.PutUint16(b[6:], 1)
+	udfLE.PutUint16(b[8:], udfCRC(b[16:]))
+	udfLE.PutUint16(b[10:], uint16(len(b)-16))
+	udfLE.PutUint32(b[12:], location)
+
+	var sum byte
+	for i, c := range b[:16] {
+		if i != 4 {
+			sum += c
+		}
+	}
+	b[4] = sum
+}
+
+// udfCRC is the CRC-ITU-T descriptors are checked with
+func udfCRC(p []byte) uint16 {
+	var crc uint16
+	for _, c := range p {
+		crc ^= uint16(c) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// udfDstring stores s as 8 bit characters in the fixed length field b
+func udfDstring(b []byte, s string) {
+	if s == "" {
+		return
+	}
+	b[0] = 8
+	n := copy(b[1:len(b)-1], s)
+	b[len(b)-1] = byte(1 + n)
+}
+
+func udfCharspec(b []byte) {
+	b[0] = 0
+	copy(b[1:64], "OSTA Compressed Unicode")
+}
+
+func udfExtent(b []byte, length, location uint32) {
+	udfLE.PutUint32(b[0:], length)
+	udfLE.PutUint32(b[4:], location)
+}
+
+// udfLongAD records an extent of partition 0, uid goes into the implementation use
+func udfLongAD(b []byte, length, block, uid uint32) {
+	udfLE.PutUint32(b[0:], length)
+	udfLE.PutUint32(b[4:], block)
+	udfLE.PutUint32(b[12:], uid)
+}
+
+func udfDomain(b []byte) {
+	copy(b[1:24], "*OSTA UDF Compliant")
+	udfLE.PutUint16(b[24:], 0x0102)
+}
+
+func udfEntity(b []byte, id string) {
+	copy(b[1:24], id)
+	udfLE.PutUint16(b[24:], 0x0102)
+}
+
+func udfImplementation(b []byte) {
+	copy(b[1:24], "*extractrr")
+}
+
+func udfTimestamp(b []byte, t time.Time) {
+	udfLE.PutUint16(b[0:], 1<<12) // local time, UTC
+	udfLE.PutUint16(b[2:], uint16(t.Year()))
+	b[4] = byte(t.Month())
+	b[5] = byte(t.Day())
+	b[6] = byte(t.Hour())
+	b[7] = byte(t.Minute())
+	b[8] = byte(t.Second())
+	b[9] = byte(t.Nanosecond() / 1e7)
+	b[10] = byte(t.Nanosecond() / 1e5 % 100)
+	b[11] = byte(t.Nanosecond() / 1e3 % 100)
+}
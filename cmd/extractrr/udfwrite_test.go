@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTreeImage writes the files and directories below root into a UDF image and
+// returns its path with the content of every file by in-image path
+func writeTreeImage(t *testing.T, root string) (string, map[string][]byte) {
+	t.Helper()
+
+	nodes := []udfNode{{Dir: true}}
+	index := map[string]int{".": 0}
+	contents := make(map[int][]byte)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		node := udfNode{Name: d.Name(), Dir: d.IsDir(), Parent: index[filepath.Dir(rel)]}
+		if !d.IsDir() {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			node.Size = int64(len(data))
+			contents[len(nodes)] = data
+		}
+		index[rel] = len(nodes)
+		nodes = append(nodes, node)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	layout, err := layoutUDF(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	image := filepath.Join(t.TempDir(), "tree.iso")
+	f, err := os.Create(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fill := func(node int, offset int64, buf []byte) {
+		copy(buf, contents[node][offset:])
+	}
+	if err := writeUDF(f, layout, "TREE", time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), fill); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != layout.size() {
+		t.Fatalf("image is %d bytes, layout says %d", info.Size(), layout.size())
+	}
+
+	files := make(map[string][]byte)
+	for rel, i := range index {
+		if data, ok := contents[i]; ok {
+			files["/"+filepath.ToSlash(rel)] = data
+		}
+	}
+	return image, files
+}
+
+// makeTree creates a small tree with nested, empty and multi block entries
+func makeTree(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	files := map[string][]byte{
+		"readme.txt":            []byte("hello\n"),
+		"empty.bin":             nil,
+		"sub/blocks.bin":        bytes.Repeat([]byte("0123456789abcdef"), 3*udfBlockSize/16+7),
+		"sub/deeper/note.txt":   []byte("nested"),
+		"sub/deeper/exact.bin":  bytes.Repeat([]byte{0xa5}, udfBlockSize),
+		"other/with space.data": []byte("spaces are kept"),
+	}
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "emptydir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// udfTestReader reads back an image written by writeUDF, checking the tag of every
+// descriptor it comes across
+type udfTestReader struct {
+	t         *testing.T
+	f         *os.File
+	partition uint32
+}
+
+// sector reads the sector at an absolute location
+func (r *udfTestReader) sector(location uint32) []byte {
+	r.t.Helper()
+	b := make([]byte, udfBlockSize)
+	if _, err := r.f.ReadAt(b, int64(location)*udfBlockSize); err != nil {
+		r.t.Fatalf("failed to read sector %d: %v", location, err)
+	}
+	return b
+}
+
+// descriptor reads the descriptor at an absolute sector and checks its tag, location is
+// what the tag must record
+func (r *udfTestReader) descriptor(sector uint32, ident uint16, location uint32) []byte {
+	r.t.Helper()
+	b := r.sector(sector)
+
+	var sum byte
+	for i, c := range b[:16] {
+		if i != 4 {
+			sum += c
+		}
+	}
+	crcLength := int(udfLE.Uint16(b[10:]))
+	switch {
+	case udfLE.Uint16(b) != ident:
+		r.t.Fatalf("sector %d has tag %d, want %d", sector, udfLE.Uint16(b), ident)
+	case b[4] != sum:
+		r.t.Fatalf("sector %d has tag checksum %#x, want %#x", sector, b[4], sum)
+	case udfLE.Uint16(b[2:]) != udfDescriptorVersion:
+		r.t.Fatalf("sector %d has descriptor version %d", sector, udfLE.Uint16(b[2:]))
+	case udfLE.Uint32(b[12:]) != location:
+		r.t.Fatalf("sector %d records location %d, want %d", sector, udfLE.Uint32(b[12:]), location)
+	case 16+crcLength > len(b) || udfCRC(b[16:16+crcLength]) != udfLE.Uint16(b[8:]):
+		r.t.Fatalf("sector %d has a bad descriptor CRC", sector)
+	}
+	return b
+}
+
+// block reads a descriptor at a logical block of the partition
+func (r *udfTestReader) block(block uint32, ident uint16) []byte {
+	r.t.Helper()
+	return r.descriptor(r.partition+block, ident, block)
+}
+
+// entry reads the file entry at block and returns whether it is a directory and its data
+func (r *udfTestReader) entry(block uint32) (bool, []byte) {
+	r.t.Helper()
+	fe := r.block(block, tagFileEntry)
+
+	size := int64(udfLE.Uint64(fe[56:]))
+	ads := fe[udfFileEntryHeaderLen+udfLE.Uint32(fe[168:]):][:udfLE.Uint32(fe[172:])]
+	data := make([]byte, 0, size)
+	for ; len(ads) >= 8; ads = ads[8:] {
+		length, location := udfLE.Uint32(ads), udfLE.Uint32(ads[4:])
+		extent := make([]byte, length)
+		if _, err := r.f.ReadAt(extent, int64(r.partition+location)*udfBlockSize); err != nil {
+			r.t.Fatalf("failed to read extent at block %d: %v", location, err)
+		}
+		data = append(data, extent...)
+	}
+	if int64(len(data)) != size {
+		r.t.Fatalf("entry at block %d has %d bytes of extents for a size of %d", block, len(data), size)
+	}
+	return fe[27] == udfFileTypeDirectory, data
+}
+
+// walk reads the directory at block and everything below it into files and dirs
+func (r *udfTestReader) walk(path string, block uint32, parent uint32, files map[string][]byte, dirs map[string]bool) {
+	r.t.Helper()
+	isDir, data := r.entry(block)
+	if !isDir {
+		r.t.Fatalf("%s is not a directory", path)
+	}
+	dirs[path] = true
+
+	// Identifiers are tagged with the block they start in, the directory data is contiguous
+	dataBlock := udfLE.Uint32(r.sector(r.partition + block)[udfFileEntryHeaderLen+4:])
+	for offset := 0; offset < len(data); {
+		fid := data[offset:]
+		if udfLE.Uint16(fid) != tagFileIdentifier {
+			r.t.Fatalf("%s has no file identifier at offset %d", path, offset)
+		}
+		nameLength, iuLength := int(fid[19]), int(udfLE.Uint16(fid[36:]))
+		length := (38 + iuLength + nameLength + 3) &^ 3
+		if location := dataBlock + uint32(offset/udfBlockSize); udfLE.Uint32(fid[12:]) != location {
+			r.t.Fatalf("identifier at %s+%d records location %d, want %d", path, offset, udfLE.Uint32(fid[12:]), location)
+		}
+		crcLength := int(udfLE.Uint16(fid[10:]))
+		if udfCRC(fid[16:16+crcLength]) != udfLE.Uint16(fid[8:]) {
+			r.t.Fatalf("identifier at %s+%d has a bad CRC", path, offset)
+		}
+		icb := udfLE.Uint32(fid[24:])
+		offset += length
+
+		if fid[18]&udfCharParent != 0 {
+			if icb != parent {
+				r.t.Fatalf("parent of %s points at block %d, want %d", path, icb, parent)
+			}
+			continue
+		}
+		name := fid[38+iuLength : 38+iuLength+nameLength]
+		if name[0] != 8 {
+			r.t.Fatalf("name in %s is not 8 bit compressed", path)
+		}
+		child := strings.TrimSuffix(path, "/") + "/" + string(name[1:])
+		if fid[18]&udfCharDirectory != 0 {
+			r.walk(child, icb, block, files, dirs)
+			continue
+		}
+		_, files[child] = r.entry(icb)
+	}
+}
+
+func TestWriteUDFDescriptors(t *testing.T) {
+	image, want := writeTreeImage(t, makeTree(t))
+	f, err := os.Open(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	last := uint32(info.Size()/udfBlockSize) - 1
+
+	r := &udfTestReader{t: t, f: f}
+	for i, id := range []string{"BEA01", "NSR02", "TEA01"} {
+		if got := string(r.sector(16 + uint32(i))[1:6]); got != id {
+			t.Fatalf("volume recognition sequence has %q at %d, want %q", got, i, id)
+		}
+	}
+
+	// Both anchors point at both volume descriptor sequences
+	for _, sector := range []uint32{udfAnchor, last} {
+		avdp := r.descriptor(sector, tagAnchor, sector)
+		if udfLE.Uint32(avdp[20:]) != udfMainVDS || udfLE.Uint32(avdp[28:]) != udfReserveVDS {
+			t.Fatalf("anchor at %d points at %d and %d", sector, udfLE.Uint32(avdp[20:]), udfLE.Uint32(avdp[28:]))
+		}
+	}
+
+	var lvd []byte
+	for _, base := range []uint32{udfMainVDS, udfReserveVDS} {
+		r.descriptor(base, tagPrimaryVolume, base)
+		r.descriptor(base+1, tagImplementationUse, base+1)
+		pd := r.descriptor(base+2, tagPartition, base+2)
+		lvd = r.descriptor(base+3, tagLogicalVolume, base+3)
+		r.descriptor(base+4, tagUnallocatedSpace, base+4)
+		r.descriptor(base+5, tagTerminating, base+5)
+		r.partition = udfLE.Uint32(pd[188:])
+		if end := r.partition + udfLE.Uint32(pd[192:]); end != last {
+			t.Fatalf("partition ends at %d, want the last anchor at %d", end, last)
+		}
+	}
+
+	lvid := r.descriptor(udfIntegrity, tagIntegrity, udfIntegrity)
+	r.descriptor(udfIntegrity+1, tagTerminating, udfIntegrity+1)
+	if files, dirs := udfLE.Uint32(lvid[120:]), udfLE.Uint32(lvid[124:]); files != 6 || dirs != 5 {
+		t.Fatalf("integrity records %d files and %d directories, want 6 and 5", files, dirs)
+	}
+
+	fsd := r.block(udfLE.Uint32(lvd[252:]), tagFileSet)
+	r.block(1, tagTerminating)
+
+	got := make(map[string][]byte)
+	dirs := make(map[string]bool)
+	root := udfLE.Uint32(fsd[404:])
+	r.walk("/", root, root, got, dirs)
+
+	for _, dir := range []string{"/", "/sub", "/sub/deeper", "/other", "/emptydir"} {
+		if !dirs[dir] {
+			t.Errorf("directory %s is missing", dir)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("read back %d files, want %d", len(got), len(want))
+	}
+	for path, data := range want {
+		if !bytes.Equal(got[path], data) {
+			t.Errorf("%s reads back %d bytes that differ from the %d written", path, len(got[path]), len(data))
+		}
+	}
+}
+
+func TestWriteUDFReadsBackThroughBackend(t *testing.T) {
+	image, want := writeTreeImage(t, makeTree(t))
+
+	backend, err := openBackend(&imageSource{Path: image})
+	if err != nil && strings.Contains(err.Error(), "failed to initialize UDF reader") {
+		t.Skipf("libudfread is not usable here: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	if label := backend.VolumeLabel(); label != "TREE" {
+		t.Errorf("volume label is %q, want TREE", label)
+	}
+
+	entries, err := readDir(backend, "/sub/deeper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("/sub/deeper has %d entries, want 2", len(entries))
+	}
+
+	for path, data := range want {
+		size, err := getFileSize(backend, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(data)) {
+			t.Errorf("%s is %d bytes, want %d", path, size, len(data))
+		}
+
+		file, err := backend.OpenFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s reads back different content", path)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+func CommandVerify() *cobra.Command {
+	var command = &cobra.Command{
+		Use:     "verify",
+		Short:   "Verify an extracted directory against its iso",
+		Example: `  extractrr verify /path/to/file.iso /path/to/export`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return fmt.Errorf("requires two args")
+			}
+			return nil
+		},
+	}
+
+	var (
+		numWorkers   = command.Flags().Int("workers", runtime.NumCPU(), "Number of parallel workers")
+		bufferSize   = bufferFlag(command.Flags(), "Buffer size for file reading")
+		showProgress = command.Flags().Bool("progress", true, "Show progress bar")
+		strip        = command.Flags().Int("strip-components", 0, "Strip NUMBER leading components from in-image paths")
+		paths        = command.Flags().StringArray("path", nil, "Only verify this in-image file or directory (can be repeated)")
+		useCache     = command.Flags().Bool("hash-cache", true, "Skip files already verified against this image while their size and mtime are unchanged")
+	)
+
+	command.RegisterFlagCompletionFunc("path", completeImagePathFlag)
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		isoFile := args[0]
+		extractDir := args[1]
+		startTime := time.Now()
+		logger := slog.With("job", newJobID(), "iso", isoFile, "dest", extractDir)
+
+		filter, err := newPathFilter(filterOptions{Paths: *paths})
+		if err != nil {
+			return err
+		}
+
+		src := &imageSource{Path: isoFile}
+		jobs, totalSize, err := scanImageJobs(src, *strip, filter)
+		if err != nil {
+			return err
+		}
+		for i := range jobs {
+			jobs[i].DstPath = filepath.Join(extractDir, jobs[i].DstPath)
+		}
+
+		// Verified files are remembered per image fingerprint and in-image path
+		var cache *hashCache
+		var fingerprint string
+		if *useCache {
+			source, err := describeSource(isoFile)
+			if err != nil {
+				return err
+			}
+			fingerprint = source.Hash
+
+			if cache, err = openHashCache(); err != nil {
+				logger.Warn("Not using hash cache", "error", err)
+			}
+		}
+
+		logger.Info("Verifying files", "files", len(jobs), "bytes", totalSize, "size", humanize.IBytes(uint64(totalSize)), "workers", *numWorkers)
+
+		// Each worker buffer is split between the image and the destination side
+		results, err := runPool(logger, src, jobs, totalSize, poolOptions{Workers: *numWorkers, BufferSize: 2 * alignBufferSize(*bufferSize), ShowProgress: *showProgress}, func(ctx context.Context, image SourceBackend, job Job, buffer []byte, progressChan chan<- int64) fileResult {
+			key := fingerprint + ":" + job.SrcPath
+			if cache.verified(job.DstPath, key) {
+				progressChan <- job.Size
+				return fileResult{}
+			}
+
+			err := verifyFile(image, job, buffer, progressChan)
+			if err == nil {
+				cache.markVerified(job.DstPath, key)
+			}
+			return fileResult{Err: err}
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := cache.save(); err != nil {
+			logger.Warn("Failed to save hash cache", "error", err)
+		}
+
+		failed := reportFailures(logger, jobs, results)
+
+		logger.Info("Verification completed", "files", len(jobs), "failed", failed, "duration", time.Since(startTime).String())
+		if failed > 0 {
+			return fmt.Errorf("verification failed: %d of %d files differ", failed, len(jobs))
+		}
+
+		logger.Info("All files match")
+
+		return nil
+	}
+
+	return command
+}
+
+// verifyFile compares a file in the image with its extracted copy
+func verifyFile(image SourceBackend, job Job, buffer []byte, progressChan chan<- int64) error {
+	half := len(buffer) / 2
+	srcBuf, dstBuf := buffer[:half], buffer[half:]
+
+	f, err := openExtracted(job.DstPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if f.Size != job.Size {
+		return fmt.Errorf("size mismatch: expected %d bytes, found %d", job.Size, f.Size)
+	}
+
+	var offset int64
+	_, err = readImageFile(image, job.SrcPath, srcBuf, func(chunk []byte) error {
+		n, err := io.ReadFull(f, dstBuf[:len(chunk)])
+		if err != nil {
+			return fmt.Errorf("failed to read destination: %w", err)
+		}
+		if !bytes.Equal(chunk, dstBuf[:n]) {
+			return fmt.Errorf("content differs at offset %d", offset)
+		}
+
+		offset += int64(n)
+		progressChan <- int64(n)
+
+		return nil
+	})
+
+	return err
+}
+
+func CommandTest() *cobra.Command {
+	var command = &cobra.Command{
+		Use:     "test",
+		Short:   "Test that every file in an iso can be read",
+		Example: `  extractrr test /path/to/file.iso`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("requires one arg")
+			}
+			return nil
+		},
+	}
+
+	var (
+		numWorkers   = command.Flags().Int("workers", runtime.NumCPU(), "Number of parallel workers")
+		bufferSize   = bufferFlag(command.Flags(), "Buffer size for file reading")
+		showProgress = command.Flags().Bool("progress", true, "Show progress bar")
+	)
+
+	command.RunE = func(c *cobra.Command, args []string) error {
+		isoFile := args[0]
+		startTime := time.Now()
+		logger := slog.With("job", newJobID(), "iso", isoFile)
+
+		src := &imageSource{Path: isoFile}
+		jobs, totalSize, err := scanImageJobs(src, 0, nil)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("Testing files", "files", len(jobs), "bytes", totalSize, "size", humanize.IBytes(uint64(totalSize)), "workers", *numWorkers)
+
+		results, err := runPool(logger, src, jobs, totalSize, poolOptions{Workers: *numWorkers, BufferSize: *bufferSize, ShowProgress: *showProgress}, func(ctx context.Context, image SourceBackend, job Job, buffer []byte, progressChan chan<- int64) fileResult {
+			_, err := readImageFile(image, job.SrcPath, buffer, func(chunk []byte) error {
+				progressChan <- int64(len(chunk))
+				return nil
+			})
+			return fileResult{Err: err}
+		})
+		if err != nil {
+			return err
+		}
+
+		failed := reportFailures(logger, jobs, results)
+
+		logger.Info("Test completed", "files", len(jobs), "failed", failed, "duration", time.Since(startTime).String())
+		if failed > 0 {
+			return fmt.Errorf("test failed: %d of %d files could not be read", failed, len(jobs))
+		}
+
+		logger.Info("All files OK")
+
+		return nil
+	}
+
+	return command
+}
+
+// reportFailures logs every failed job and returns how many there were
+func reportFailures(logger *slog.Logger, jobs []Job, results []fileResult) int {
+	failed := 0
+	for i, result := range results {
+		if result.Err == nil {
+			continue
+		}
+
+		logger.Error("File check failed", "file", jobs[i].SrcPath, "bytes", jobs[i].Size, "error", result.Err)
+		failed++
+	}
+
+	return failed
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxJobAttempts is how often a file is tried before a hanging worker fails it
+const maxJobAttempts = 3
+
+// checkHung replaces workers that made no progress on their file for hungAfter. The
+// stuck read or write can't be interrupted, so the worker and its image handle are left
+// behind and a fresh worker with its own handle takes over the requeued file.
+func (s *poolState) checkHung(hungAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.aborted != nil {
+		return
+	}
+
+	for id, w := range s.workers {
+		if !w.busy || w.abandoned {
+			continue
+		}
+		idle := time.Since(w.lastProgress)
+		if idle < hungAfter {
+			continue
+		}
+
+		job := w.job
+		s.attempts[job]++
+		s.incidents = append(s.incidents, w.file)
+		s.abandonLocked(w)
+
+		if s.attempts[job] < maxJobAttempts {
+			s.logger.Warn("Worker hung, requeueing file", "worker", id, "file", w.file, "idle", idle.Round(time.Second).String(), "attempt", s.attempts[job])
+			s.queue = append([]int{job}, s.queue...)
+		} else {
+			s.logger.Error("Worker hung, giving up on file", "worker", id, "file", w.file, "idle", idle.Round(time.Second).String(), "attempts", s.attempts[job])
+			s.results[job].Err = fmt.Errorf("worker hung %d times without progress for %s", s.attempts[job], hungAfter)
+		}
+
+		s.spawnLocked()
+		s.cond.Broadcast()
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolStateRequeuesHungJob(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := newPoolState(logger, []Job{{SrcPath: "/a", Size: 100}, {SrcPath: "/b"}}, nil, 0)
+	s.counter = &atomic.Int64{}
+	startWorkers(s, 1)
+
+	id := 0
+	for attempt := 1; attempt <= maxJobAttempts; attempt++ {
+		idx, ok := s.next(id)
+		if !ok || idx != 0 {
+			t.Fatalf("attempt %d: worker %d got job %d, want the hung job 0 first", attempt, id, idx)
+		}
+
+		s.progress(id, 60)
+		s.workers[id].lastProgress = time.Now().Add(-time.Hour)
+		s.checkHung(time.Minute)
+
+		if !s.workers[id].abandoned || s.workers[id].cancel == nil {
+			t.Fatalf("attempt %d: hung worker %d was not abandoned", attempt, id)
+		}
+		if len(s.workers) != attempt+1 {
+			t.Fatalf("attempt %d: %d workers registered, want a replacement for every hung one", attempt, len(s.workers))
+		}
+		if live := s.liveLocked(); live != 1 {
+			t.Fatalf("attempt %d: %d workers count as running, want only the replacement", attempt, live)
+		}
+		// The requeued file starts over, the abandoned attempt must not count twice
+		if s.processed != 0 || s.counter.Load() != 0 {
+			t.Fatalf("attempt %d: %d bytes processed, %d counted, want the abandoned attempt's bytes taken back", attempt, s.processed, s.counter.Load())
+		}
+		s.progress(id, 10)
+		if s.processed != 0 {
+			t.Fatalf("attempt %d: progress of the abandoned worker was counted", attempt)
+		}
+		id = s.nextWorker - 1
+	}
+
+	if len(s.incidents) != maxJobAttempts {
+		t.Errorf("recorded %d incidents, want %d", len(s.incidents), maxJobAttempts)
+	}
+	if s.results[0].Err == nil || s.results[0].Err.Error() == "not processed" {
+		t.Errorf("job hanging every attempt has error %v, want it failed as hung", s.results[0].Err)
+	}
+
+	// The other job still gets done by the last replacement
+	if idx, ok := s.next(id); !ok || idx != 1 {
+		t.Fatalf("got job %d, want 1", idx)
+	}
+	s.finish(id, fileResult{})
+	s.exit(id)
+	s.wg.Wait()
+
+	// Once their stuck call returns abandoned workers are unregistered too
+	for stuck := range maxJobAttempts {
+		s.exit(stuck)
+	}
+	if len(s.workers) != 0 {
+		t.Errorf("%d workers still registered after all exited", len(s.workers))
+	}
+
+	if s.results[1].Err != nil {
+		t.Errorf("job 1 failed: %v", s.results[1].Err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// statsInterval is how often the throughput of every worker is sampled
+const statsInterval = time.Second
+
+// stats samples per-worker throughput until done is closed. The progress bar shows the
+// slowest worker, so one stuck on a bad region of the image stands out from the total,
+// and SIGUSR1 dumps all of them to stderr.
+func (s *poolState) stats(done <-chan struct{}) {
+	dump := make(chan os.Signal, 1)
+	notifyDump(dump)
+	defer signal.Stop(dump)
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-dump:
+			s.dumpWorkers(os.Stderr)
+		case now := <-ticker.C:
+			s.sample(now)
+		}
+	}
+}
+
+// sample updates the throughput of every worker since the last sample
+func (s *poolState) sample(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var slowest *workerState
+	slowestID := 0
+	for id, w := range s.workers {
+		if !w.busy || w.abandoned {
+			continue
+		}
+
+		elapsed := now.Sub(w.sampledAt)
+		if elapsed <= 0 {
+			continue
+		}
+		w.rate = float64(w.bytes-w.sampled) / elapsed.Seconds()
+		w.sampled, w.sampledAt = w.bytes, now
+		w.measured = true
+
+		if slowest == nil || w.rate < slowest.rate {
+			slowest, slowestID = w, id
+		}
+	}
+
+	// With a single worker the bar's own speed already says it all
+	if s.bar == nil || s.liveLocked() < 2 {
+		return
+	}
+	suffix := ""
+	if slowest != nil {
+		suffix = fmt.Sprintf("slowest #%d %s/s %s", slowestID, humanize.IBytes(uint64(slowest.rate)), path.Base(slowest.file))
+	}
+	s.bar.Set("suffix", suffix)
+}
+
+// dumpWorkers writes what every worker is doing and how fast
+func (s *poolState) dumpWorkers(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, 0, len(s.workers))
+	for id := range s.workers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "extractrr: %d workers, %s processed\n", s.liveLocked(), humanize.IBytes(uint64(s.processed)))
+	for _, id := range ids {
+		worker := s.workers[id]
+		switch {
+		case worker.abandoned:
+			fmt.Fprintf(&b, "  #%d abandoned, stuck in %s\n", id, worker.file)
+		case !worker.busy:
+			fmt.Fprintf(&b, "  #%d idle\n", id)
+		default:
+			rate := "measuring"
+			if worker.measured {
+				rate = humanize.IBytes(uint64(worker.rate)) + "/s"
+			}
+			fmt.Fprintf(&b, "  #%d %s, %s of %s, no progress for %s, %s\n", id, rate, humanize.IBytes(uint64(worker.bytes)), humanize.IBytes(uint64(worker.size)), time.Since(worker.lastProgress).Round(time.Second), worker.file)
+		}
+	}
+
+	io.WriteString(w, b.String())
+}